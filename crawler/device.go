@@ -3,28 +3,75 @@ package crawler
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/pilebones/go-udev/netlink"
 )
 
 const (
-	BASE_DEVPATH = "/sys/devices"
+	BASE_SYSPATH = "/sys"
+	BASE_DEVPATH = BASE_SYSPATH + "/devices"
+
+	// MAX_ATTR_SIZE is the max size of an attribute file read into Device.Attrs, bigger files are skipped
+	MAX_ATTR_SIZE = 4096
 )
 
+var errAbort = errors.New("abort signal receive")
+
+// PathError is returned when a file or a directory of sysfs can't be read while crawling (ie: permission denied).
+// Use errors.As to know which path failed and decide to continue (see: WithSkipUnreadable) or abort.
+type PathError struct {
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("reading %s: %v", e.Path, e.Err)
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
 type Device struct {
-	KObj string
-	Env  map[string]string
+	KObj    string
+	Env     map[string]string
+	Attrs   map[string]string // sysfs attributes (ie: vendor, product...), only filled using WithAttributes option
+	Settled bool              // true only for the sentinel sent at the end of the crawling with WithSettled option
 }
 
 // ExistingDevices return all plugged devices matched by the matcher
 // All uevent files inside /sys/devices is crawled to match right env values
-func ExistingDevices(queue chan Device, errs chan error, matcher netlink.Matcher) chan struct{} {
+func ExistingDevices(queue chan Device, errs chan error, matcher netlink.Matcher, opts ...Option) chan struct{} {
+	o := newOptions(opts)
+	return crawl(queue, errs, matcher, o, func(done <-chan struct{}) error {
+		return walkDevices(done, BASE_DEVPATH, queue, matcher, o)
+	})
+}
+
+// ExistingDevicesForSubsystem is like ExistingDevices but only devices of the subsystem (ie: "net", "block", "usb")
+// are crawled, from /sys/class/<subsystem> and /sys/bus/<subsystem>/devices. It's much faster than crawling
+// all devices. Links are resolved so each device is sent once with its real path as KObj.
+// Note: WithConcurrency option is ignored.
+func ExistingDevicesForSubsystem(subsystem string, queue chan Device, errs chan error, matcher netlink.Matcher, opts ...Option) chan struct{} {
+	o := newOptions(opts)
+	return crawl(queue, errs, matcher, o, func(done <-chan struct{}) error {
+		return walkSubsystem(done, BASE_SYSPATH, subsystem, queue, matcher, o)
+	})
+}
+
+// crawl compile the matcher then run walk in background, queue is closed at the end
+func crawl(queue chan Device, errs chan error, matcher netlink.Matcher, opts *options, walk func(done <-chan struct{}) error) chan struct{} {
 	quit := make(chan struct{}, 1)
 
 	if matcher != nil {
@@ -37,48 +84,311 @@ func ExistingDevices(queue chan Device, errs chan error, matcher netlink.Matcher
 	}
 
 	go func() {
-		err := filepath.Walk(BASE_DEVPATH, func(path string, info os.FileInfo, err error) error {
-			select {
-			case <-quit:
-				return errors.New("abort signal receive")
-			default:
-				if err != nil {
-					return err
-				}
+		err := walk(quit)
+		if err == nil {
+			err = sendSettled(quit, queue, opts)
+		}
+		if err != nil {
+			errs <- err
+		}
 
-				if info.IsDir() || info.Name() != "uevent" {
-					return nil
-				}
+		close(queue)
+	}()
+	return quit
+}
 
-				env, err := getEventFromUEventFile(path)
-				if err != nil {
-					return err
-				}
+// sendSettled send the sentinel of the end of the crawling if enabled (see: WithSettled)
+func sendSettled(done <-chan struct{}, queue chan Device, opts *options) error {
+	if !opts.settled {
+		return nil
+	}
+	select {
+	case queue <- Device{Settled: true}:
+		return nil
+	case <-done:
+		return errAbort
+	}
+}
+
+// ExistingDevicesContext is like ExistingDevices but the crawling is stopped as soon as ctx is done.
+// In any case queue is closed at the end, but the cancellation of ctx isn't reported on errs.
+func ExistingDevicesContext(ctx context.Context, queue chan Device, errs chan error, matcher netlink.Matcher, opts ...Option) {
+	existingDevicesContext(ctx, BASE_DEVPATH, queue, errs, matcher, opts...)
+}
 
-				kObj := filepath.Dir(path)
+// existingDevicesContext is ExistingDevicesContext crawling root
+func existingDevicesContext(ctx context.Context, root string, queue chan Device, errs chan error, matcher netlink.Matcher, opts ...Option) {
+	go func() {
+		defer close(queue)
 
-				// Append to env subsystem if existing
-				if link, err := os.Readlink(kObj + "/subsystem"); err == nil {
-					env["SUBSYSTEM"] = filepath.Base(link)
+		if matcher != nil {
+			if err := matcher.Compile(); err != nil {
+				select {
+				case errs <- fmt.Errorf("Wrong matcher, err: %w", err):
+				case <-ctx.Done():
 				}
+				return
+			}
+		}
 
-				if matcher == nil || matcher.EvaluateEnv(env) {
-					queue <- Device{
-						KObj: kObj,
-						Env:  env,
-					}
+		o := newOptions(opts)
+		err := walkDevices(ctx.Done(), root, queue, matcher, o)
+		if err == nil {
+			err = sendSettled(ctx.Done(), queue, o)
+		}
+		if err != nil && ctx.Err() == nil {
+			select {
+			case errs <- err:
+			case <-ctx.Done():
+			}
+		}
+	}()
+}
+
+// walkDevices crawl uevent files inside root and send devices matched by the matcher on queue
+// until done is closed (or receive a value)
+func walkDevices(done <-chan struct{}, root string, queue chan Device, matcher netlink.Matcher, opts *options) error {
+	if opts.concurrency > 1 {
+		return walkDevicesConcurrently(done, root, queue, matcher, opts)
+	}
+
+	return filepath.Walk(root, walkUEventFiles(done, opts, func(path string) error {
+		return handleUEventFile(done, path, queue, matcher, opts)
+	}))
+}
+
+// walkDevicesConcurrently is like walkDevices but uevent files are handled by a pool of workers,
+// so devices are sent on queue without any order
+func walkDevicesConcurrently(done <-chan struct{}, root string, queue chan Device, matcher netlink.Matcher, opts *options) error {
+	var (
+		once     sync.Once
+		firstErr error
+		stop     = make(chan struct{}) // closed on first error or when done
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(stop)
+		})
+	}
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-done:
+			fail(errAbort)
+		case <-finished:
+		}
+	}()
+
+	paths := make(chan string)
+	wg := sync.WaitGroup{}
+	for i := 0; i < opts.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if err := handleUEventFile(stop, path, queue, matcher, opts); err != nil {
+					fail(err)
 				}
+			}
+		}()
+	}
+
+	err := filepath.Walk(root, walkUEventFiles(stop, opts, func(path string) error {
+		select {
+		case paths <- path:
+			return nil
+		case <-stop:
+			return errAbort
+		}
+	}))
+	close(paths)
+	wg.Wait()
+
+	if err != nil {
+		fail(err)
+	}
+	once.Do(func() {}) // wait for a concurrent fail() before reading firstErr
+	return firstErr
+}
+
+// walkSubsystem send devices of the subsystem matched by the matcher on queue until done is closed,
+// devices are listed from links in <sysRoot>/class/<subsystem> and <sysRoot>/bus/<subsystem>/devices
+func walkSubsystem(done <-chan struct{}, sysRoot, subsystem string, queue chan Device, matcher netlink.Matcher, opts *options) error {
+	if subsystem == "" || subsystem == "." || subsystem == ".." || strings.Contains(subsystem, "/") {
+		return fmt.Errorf("Wrong subsystem (got: %q)", subsystem)
+	}
+
+	found := false
+	seen := make(map[string]bool) // a device could be linked from class and bus directories
+	for _, dir := range []string{
+		filepath.Join(sysRoot, "class", subsystem),
+		filepath.Join(sysRoot, "bus", subsystem, "devices"),
+	} {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		found = true
+		if err != nil {
+			if opts.skipUnreadable {
+				continue
+			}
+			return &PathError{Path: dir, Err: err}
+		}
+
+		for _, entry := range entries {
+			select {
+			case <-done:
+				return errAbort
+			default:
+			}
+
+			kObj, err := filepath.EvalSymlinks(filepath.Join(dir, entry.Name()))
+			if err != nil || seen[kObj] {
+				continue // dangling link or already sent
+			}
+			seen[kObj] = true
+
+			path := filepath.Join(kObj, "uevent")
+			if _, err := os.Stat(path); err != nil {
+				continue // not a device
+			}
+			if err := handleUEventFile(done, path, queue, matcher, opts); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("Unknown subsystem %s", subsystem)
+	}
+	return nil
+}
+
+// walkUEventFiles return a filepath.WalkFunc which call fn for each uevent file until done is closed,
+// unreadable directories are skipped with WithSkipUnreadable option
+func walkUEventFiles(done <-chan struct{}, opts *options, fn func(path string) error) filepath.WalkFunc {
+	return func(path string, info os.FileInfo, err error) error {
+		select {
+		case <-done:
+			return errAbort
+		default:
+		}
+
+		if err != nil {
+			if opts.skipUnreadable {
 				return nil
 			}
-		})
+			return &PathError{Path: path, Err: err}
+		}
+
+		if info.IsDir() || info.Name() != "uevent" {
+			return nil
+		}
+
+		return fn(path)
+	}
+}
+
+// handleUEventFile read the device of an uevent file and send it on queue if matched by the matcher
+func handleUEventFile(done <-chan struct{}, path string, queue chan Device, matcher netlink.Matcher, opts *options) error {
+	env, err := getEventFromUEventFile(path)
+	if err != nil {
+		if opts.skipUnreadable {
+			return nil
+		}
+		return &PathError{Path: path, Err: err}
+	}
+
+	kObj := filepath.Dir(path)
+
+	// Append to env subsystem if existing
+	if link, err := os.Readlink(kObj + "/subsystem"); err == nil {
+		env["SUBSYSTEM"] = filepath.Base(link)
+	}
+
+	if matcher != nil && !matcher.EvaluateEnv(env) {
+		return nil
+	}
+
+	device := Device{
+		KObj: kObj,
+		Env:  env,
+	}
+	if opts.attributes {
+		device.Attrs = getAttributes(kObj)
+	}
 
+	select {
+	case queue <- device:
+		return nil
+	case <-done:
+		return errAbort
+	}
+}
+
+// getAttributes return sysfs attributes of the device, ie: content of regular files inside the device directory.
+// Unreadable, binary or too big (see: MAX_ATTR_SIZE) files are skipped, uevent file too (already in env).
+func getAttributes(kObj string) map[string]string {
+	rv := make(map[string]string)
+
+	entries, err := ioutil.ReadDir(kObj)
+	if err != nil {
+		return rv
+	}
+
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || entry.Mode().Perm()&0444 == 0 || entry.Name() == "uevent" {
+			continue
+		}
+
+		value, err := readAttribute(filepath.Join(kObj, entry.Name()))
 		if err != nil {
-			errs <- err
+			continue
 		}
+		rv[entry.Name()] = value
+	}
+	return rv
+}
 
-		close(queue)
-	}()
-	return quit
+// readAttribute return the content of an attribute file without trailing whitespaces
+func readAttribute(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// Read one more byte to detect too big file (sysfs reports size of 4096 for every file)
+	data, err := ioutil.ReadAll(io.LimitReader(f, MAX_ATTR_SIZE+1))
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > MAX_ATTR_SIZE {
+		return "", fmt.Errorf("attribute too big (max: %d bytes)", MAX_ATTR_SIZE)
+	}
+
+	if !isPrintable(data) {
+		return "", errors.New("binary attribute")
+	}
+
+	return strings.TrimRight(string(data), " \t\n"), nil
+}
+
+func isPrintable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
 }
 
 // getEventFromUEventFile return all env var define in file
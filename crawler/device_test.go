@@ -1,8 +1,17 @@
 package crawler
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEventFromUEventData(t *testing.T) {
@@ -54,3 +63,350 @@ DEVNAME=vcs3`,
 		}
 	}
 }
+
+// newSysfsFixture create a fake sysfs tree with an uevent file per device (relative path => uevent content)
+func newSysfsFixture(t testing.TB, devices map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for path, uevent := range devices {
+		dir := filepath.Join(root, path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal("unable to create fixture, err:", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "uevent"), []byte(uevent), 0644); err != nil {
+			t.Fatal("unable to create fixture, err:", err)
+		}
+	}
+	return root
+}
+
+func TestWalkDevices(t *testing.T) {
+	root := newSysfsFixture(t, map[string]string{
+		"virtual/mem/null": "MAJOR=1\nMINOR=3\nDEVNAME=null\n",
+		"virtual/mem/zero": "MAJOR=1\nMINOR=5\nDEVNAME=zero\n",
+	})
+
+	queue := make(chan Device, 2)
+	if err := walkDevices(make(chan struct{}), root, queue, nil, newOptions(nil)); err != nil {
+		t.Fatal("unable to walk devices, err:", err)
+	}
+	close(queue)
+
+	count := 0
+	for device := range queue {
+		if !strings.HasPrefix(device.KObj, root) || device.Env["MAJOR"] != "1" {
+			t.Fatalf("wrong device (got: %v)", device)
+		}
+		count++
+	}
+	if count != 2 {
+		t.Fatalf("wrong number of devices (got: %d, wanted: 2)", count)
+	}
+
+	// Nobody read the queue but the walk must be aborted
+	done := make(chan struct{})
+	close(done)
+	if err := walkDevices(done, root, make(chan Device), nil, newOptions(nil)); err != errAbort {
+		t.Fatal("walk should be aborted, got:", err)
+	}
+}
+
+func TestWalkSubsystem(t *testing.T) {
+	root := newSysfsFixture(t, map[string]string{
+		"devices/virtual/net/lo":                   "INTERFACE=lo\nIFINDEX=1\n",
+		"devices/pci0000:00/0000:00:03.0/net/eth0": "INTERFACE=eth0\nIFINDEX=2\n",
+		"devices/virtual/misc/tun":                 "MAJOR=10\nMINOR=200\n",
+	})
+	links := map[string]string{
+		"class/net/lo":              "../../devices/virtual/net/lo",
+		"class/net/eth0":            "../../devices/pci0000:00/0000:00:03.0/net/eth0",
+		"class/net/gone":            "../../devices/virtual/net/gone", // dangling
+		"bus/net/devices/eth0":      "../../../devices/pci0000:00/0000:00:03.0/net/eth0",
+		"class/misc/tun":            "../../devices/virtual/misc/tun",
+		"class/net/bonding_masters": "",
+	}
+	for link, target := range links {
+		path := filepath.Join(root, link)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal("unable to create fixture, err:", err)
+		}
+		var err error
+		if target == "" {
+			err = os.WriteFile(path, []byte("lo eth0\n"), 0644) // regular file, not a device
+		} else {
+			err = os.Symlink(target, path)
+		}
+		if err != nil {
+			t.Fatal("unable to create fixture, err:", err)
+		}
+	}
+
+	queue := make(chan Device, 10)
+	if err := walkSubsystem(make(chan struct{}), root, "net", queue, nil, newOptions(nil)); err != nil {
+		t.Fatal("unable to walk subsystem, err:", err)
+	}
+	close(queue)
+
+	var kObjs []string
+	for device := range queue {
+		kObjs = append(kObjs, strings.TrimPrefix(device.KObj, root))
+	}
+	sort.Strings(kObjs)
+	if !reflect.DeepEqual(kObjs, []string{"/devices/pci0000:00/0000:00:03.0/net/eth0", "/devices/virtual/net/lo"}) {
+		t.Fatalf("wrong devices, each net device should be sent once with its real path (got: %v)", kObjs)
+	}
+
+	for _, subsystem := range []string{"", "..", "net/../misc", "block"} {
+		if err := walkSubsystem(make(chan struct{}), root, subsystem, make(chan Device, 10), nil, newOptions(nil)); err == nil {
+			t.Fatalf("walk should fail with subsystem %q", subsystem)
+		}
+	}
+
+	done := make(chan struct{})
+	close(done)
+	if err := walkSubsystem(done, root, "net", make(chan Device), nil, newOptions(nil)); err != errAbort {
+		t.Fatal("walk should be aborted, got:", err)
+	}
+}
+
+func TestExistingDevicesContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := make(chan Device)
+	ExistingDevicesContext(ctx, queue, make(chan error), nil)
+
+	// Nobody read the queue anymore after cancellation
+	cancel()
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("queue should be closed when ctx is cancelled")
+	case _, more := <-queue:
+		for more {
+			_, more = <-queue
+		}
+	}
+}
+
+func TestGetAttributes(t *testing.T) {
+	root := newSysfsFixture(t, map[string]string{
+		"usb1/1-1": "DEVTYPE=usb_device\n",
+	})
+	kObj := filepath.Join(root, "usb1/1-1")
+
+	files := map[string]struct {
+		content []byte
+		perm    os.FileMode
+	}{
+		"idVendor":    {content: []byte("058f\n"), perm: 0444},
+		"product":     {content: []byte("Mass Storage Device \n"), perm: 0444},
+		"descriptors": {content: []byte{0x12, 0x01, 0x00, 0x02}, perm: 0444},
+		"big":         {content: bytes.Repeat([]byte("a"), MAX_ATTR_SIZE+1), perm: 0444},
+		"remove":      {content: nil, perm: 0200},
+	}
+	for name, f := range files {
+		if err := os.WriteFile(filepath.Join(kObj, name), f.content, f.perm); err != nil {
+			t.Fatal("unable to create fixture, err:", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(kObj, "power"), 0755); err != nil {
+		t.Fatal("unable to create fixture, err:", err)
+	}
+
+	expected := map[string]string{
+		"idVendor": "058f",
+		"product":  "Mass Storage Device",
+	}
+	if attrs := getAttributes(kObj); !reflect.DeepEqual(attrs, expected) {
+		t.Fatalf("wrong attributes (got: %v, expected: %v)", attrs, expected)
+	}
+
+	// Attributes are only read with the option
+	for _, tcase := range []struct {
+		opts     *options
+		expected map[string]string
+	}{
+		{opts: newOptions(nil), expected: nil},
+		{opts: newOptions([]Option{WithAttributes()}), expected: expected},
+	} {
+		queue := make(chan Device, 1)
+		if err := walkDevices(make(chan struct{}), root, queue, nil, tcase.opts); err != nil {
+			t.Fatal("unable to walk devices, err:", err)
+		}
+		if device := <-queue; !reflect.DeepEqual(device.Attrs, tcase.expected) {
+			t.Fatalf("wrong device attributes (got: %v, expected: %v)", device.Attrs, tcase.expected)
+		}
+	}
+}
+
+// newBigSysfsFixture create a fake sysfs tree with n devices
+func newBigSysfsFixture(t testing.TB, n int) string {
+	devices := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		devices[fmt.Sprintf("pci0000:00/0000:00:%02x.0/dev%d", i%32, i)] = fmt.Sprintf("MAJOR=%d\nMINOR=%d\nDEVNAME=dev%d\n", i/256, i%256, i)
+	}
+	return newSysfsFixture(t, devices)
+}
+
+func TestWalkDevicesConcurrently(t *testing.T) {
+	root := newBigSysfsFixture(t, 100)
+
+	collect := func(opts *options) map[string]Device {
+		queue := make(chan Device)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- walkDevices(make(chan struct{}), root, queue, nil, opts)
+			close(queue)
+		}()
+
+		devices := make(map[string]Device)
+		for device := range queue {
+			devices[device.KObj] = device
+		}
+		if err := <-errs; err != nil {
+			t.Fatal("unable to walk devices, err:", err)
+		}
+		return devices
+	}
+
+	serial := collect(newOptions(nil))
+	concurrent := collect(newOptions([]Option{WithConcurrency(8)}))
+	if len(serial) != 100 || !reflect.DeepEqual(serial, concurrent) {
+		t.Fatalf("concurrent walk should find the same devices (got: %d, wanted: %d)", len(concurrent), len(serial))
+	}
+
+	// Nobody read the queue but the walk must be aborted
+	done := make(chan struct{})
+	close(done)
+	if err := walkDevices(done, root, make(chan Device), nil, newOptions([]Option{WithConcurrency(8)})); err != errAbort {
+		t.Fatal("walk should be aborted, got:", err)
+	}
+}
+
+func benchmarkWalkDevices(b *testing.B, opts ...Option) {
+	root := newBigSysfsFixture(b, 2000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		queue := make(chan Device, 64)
+		go func() {
+			for range queue {
+			}
+		}()
+		if err := walkDevices(make(chan struct{}), root, queue, nil, newOptions(opts)); err != nil {
+			b.Fatal("unable to walk devices, err:", err)
+		}
+		close(queue)
+	}
+}
+
+func BenchmarkWalkDevicesSerial(b *testing.B) {
+	benchmarkWalkDevices(b)
+}
+
+func BenchmarkWalkDevicesConcurrent(b *testing.B) {
+	benchmarkWalkDevices(b, WithConcurrency(8))
+}
+
+func TestSettled(t *testing.T) {
+	root := newSysfsFixture(t, map[string]string{
+		"virtual/mem/null": "MAJOR=1\nMINOR=3\nDEVNAME=null\n",
+		"virtual/mem/zero": "MAJOR=1\nMINOR=5\nDEVNAME=zero\n",
+	})
+
+	run := func(opts []Option, walkErr error) (devices int, settled []int) {
+		queue, errs := make(chan Device), make(chan error, 1)
+		o := newOptions(opts)
+		crawl(queue, errs, nil, o, func(done <-chan struct{}) error {
+			if walkErr != nil {
+				return walkErr
+			}
+			return walkDevices(done, root, queue, nil, o)
+		})
+		for device := range queue {
+			if device.Settled {
+				if device.KObj != "" || device.Env != nil {
+					t.Fatalf("sentinel should be empty (got: %v)", device)
+				}
+				settled = append(settled, devices)
+				continue
+			}
+			devices++
+		}
+		return devices, settled
+	}
+
+	if devices, settled := run([]Option{WithSettled()}, nil); devices != 2 || len(settled) != 1 || settled[0] != 2 {
+		t.Fatalf("sentinel should be sent once after the last device (devices: %d, sentinel after: %v)", devices, settled)
+	}
+	if _, settled := run([]Option{WithSettled(), WithConcurrency(2)}, nil); len(settled) != 1 || settled[0] != 2 {
+		t.Fatalf("sentinel should be sent after the last device with concurrency (sentinel after: %v)", settled)
+	}
+	if _, settled := run(nil, nil); len(settled) != 0 {
+		t.Fatal("sentinel should be disabled by default")
+	}
+	if _, settled := run([]Option{WithSettled()}, errors.New("failure")); len(settled) != 0 {
+		t.Fatal("sentinel shouldn't be sent on error")
+	}
+}
+
+func TestUnreadablePath(t *testing.T) {
+	root := newSysfsFixture(t, map[string]string{
+		"virtual/mem/null": "MAJOR=1\nMINOR=3\nDEVNAME=null\n",
+	})
+
+	// A dangling uevent file can't be read even by root
+	broken := filepath.Join(root, "virtual", "broken")
+	if err := os.MkdirAll(broken, 0755); err != nil {
+		t.Fatal("unable to create fixture, err:", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "missing"), filepath.Join(broken, "uevent")); err != nil {
+		t.Fatal("unable to create fixture, err:", err)
+	}
+	// An unreadable directory (ignored by root)
+	locked := filepath.Join(root, "virtual", "locked")
+	if err := os.MkdirAll(filepath.Join(locked, "dev"), 0755); err != nil {
+		t.Fatal("unable to create fixture, err:", err)
+	}
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal("unable to create fixture, err:", err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	walk := func(opts ...Option) (int, error) {
+		queue := make(chan Device, 10)
+		err := walkDevices(make(chan struct{}), root, queue, nil, newOptions(opts))
+		close(queue)
+		return len(queue), err
+	}
+
+	_, err := walk()
+	var pathErr *PathError
+	if !errors.As(err, &pathErr) {
+		t.Fatalf("walk should fail with a PathError (got: %v)", err)
+	}
+	if pathErr.Path != filepath.Join(broken, "uevent") && pathErr.Path != locked {
+		t.Fatalf("wrong path in error (got: %s)", pathErr.Path)
+	}
+	if !strings.HasPrefix(err.Error(), "reading "+pathErr.Path+": ") {
+		t.Fatalf("wrong error message (got: %s)", err)
+	}
+
+	if count, err := walk(WithSkipUnreadable()); err != nil || count != 1 {
+		t.Fatalf("unreadable paths should be skipped (count: %d, err: %v)", count, err)
+	}
+	if count, err := walk(WithSkipUnreadable(), WithConcurrency(2)); err != nil || count != 1 {
+		t.Fatalf("unreadable paths should be skipped with concurrency (count: %d, err: %v)", count, err)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Log("running as root, unreadable directory can't be tested")
+		return
+	}
+	if err := os.Remove(filepath.Join(broken, "uevent")); err != nil {
+		t.Fatal("unable to remove fixture, err:", err)
+	}
+	if _, err := walk(); !errors.As(err, &pathErr) || pathErr.Path != locked || !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("walk should fail on the unreadable directory (got: %v)", err)
+	}
+}
@@ -0,0 +1,254 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pilebones/go-udev/netlink"
+)
+
+// EnumerateAndMonitor send on queue all existing devices then their uevents, without missing a device plugged
+// during the enumeration nor sending it twice. The socket is opened first and uevents received during the
+// crawling are held, then they are sent once all existing devices are, and it switches to live monitoring.
+//
+// Existing devices are sent as "add" uevents with DEVPATH and ACTION env, and a zero ReceivedAt (SeqNum is zero too).
+// They are crawled in the order of ExistingDevices, then uevents are sent in the order of reception.
+// Held "add" uevents of enumerated devices are dropped when their SEQNUM isn't greater than the kernel one
+// (/sys/kernel/uevent_seqnum) when the device was enumerated, or without SEQNUM, unless a "remove" (or a "move" away)
+// of the device was held before. Other actions are always sent, ie: a "change" received during the enumeration,
+// or a "remove" of a device unplugged before the crawler found it. Once held uevents are sent, dedup is over.
+//
+// Up to 4096 uevents are held (see: WithMaxHeld), next ones are dropped and an error wrapping netlink.ErrUEventOverflow
+// is sent on errs at the end of the enumeration, like an overflow of the socket receive buffer.
+//
+// The matcher is evaluated on both existing devices and uevents. Among options, only WithConcurrency, WithSkipUnreadable
+// and WithMaxHeld are relevant: attributes aren't part of uevents and the end of the enumeration isn't signaled.
+// It's a function of crawler rather than netlink because crawler already depends on netlink.
+//
+// The socket is closed, and queue and errs too, when ctx is done (not reported on errs) or when the monitoring
+// stops on error. An error is returned only if the socket can't be opened.
+func EnumerateAndMonitor(ctx context.Context, mode netlink.Mode, queue chan netlink.UEvent, errs chan error, matcher netlink.Matcher, opts ...Option) error {
+	conn := &netlink.UEventConn{}
+	if err := conn.Connect(mode); err != nil {
+		return err
+	}
+	enumerateAndMonitor(ctx, conn, BASE_DEVPATH, queue, errs, matcher, opts...)
+	return nil
+}
+
+// enumerateAndMonitor is EnumerateAndMonitor on a connected conn, crawling root (which is the devices directory of sysfs)
+func enumerateAndMonitor(ctx context.Context, conn *netlink.UEventConn, root string, queue chan netlink.UEvent, errs chan error, matcher netlink.Matcher, opts ...Option) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// The matcher is only evaluated by the merging goroutine below, compiled once to not race between workers
+	var compileErr error
+	if matcher != nil {
+		compileErr = matcher.Compile()
+	}
+
+	live, liveErrs := make(chan netlink.UEvent), make(chan error)
+	devices, devicesErrs := make(chan Device), make(chan error)
+	if compileErr != nil {
+		close(live)
+		close(liveErrs)
+		close(devices)
+	} else {
+		conn.MonitorContext(ctx, live, liveErrs, nil)
+		existingDevicesContext(ctx, root, devices, devicesErrs, nil, opts...)
+	}
+
+	go func() {
+		defer close(errs)
+		defer close(queue)
+		defer conn.Close()
+		defer cancel()
+
+		var (
+			sysRoot   = filepath.Dir(root)
+			dedup     = newEnumeratedDevices(newOptions(opts).maxHeld)
+			outbox    []netlink.UEvent // waiting to be sent on queue, in order
+			errbox    []error
+			done      = ctx.Done()
+			cancelled bool
+		)
+		if compileErr != nil {
+			errbox = append(errbox, compileErr)
+		}
+
+		for {
+			// Workers must exit (they stop on ctx) before closing conn
+			if devices == nil && live == nil && liveErrs == nil && (cancelled || len(outbox) == 0 && len(errbox) == 0) {
+				return
+			}
+
+			var (
+				out     chan netlink.UEvent
+				next    netlink.UEvent
+				errOut  chan error
+				nextErr error
+			)
+			if !cancelled && len(outbox) > 0 {
+				out, next = queue, outbox[0]
+			}
+			if !cancelled && len(errbox) > 0 {
+				errOut, nextErr = errs, errbox[0]
+			}
+
+			// Devices and live uevents are read once outbox is empty (backpressure), except uevents received
+			// during the enumeration, which are held to not overflow the socket buffer
+			var devicesIn chan Device
+			var liveIn chan netlink.UEvent
+			if len(outbox) == 0 || cancelled {
+				devicesIn, liveIn = devices, live
+			}
+			if devices != nil {
+				liveIn = live
+			}
+
+			select {
+			case device, more := <-devicesIn:
+				if !more {
+					// Enumeration completed: send held uevents which aren't duplicated, next ones are live
+					devices = nil
+					released, err := dedup.release()
+					if err != nil {
+						errbox = append(errbox, err)
+					}
+					outbox = append(outbox, released...)
+					continue
+				}
+				if device.Settled {
+					continue
+				}
+				uevent := deviceUEvent(sysRoot, device)
+				if matcher == nil || matcher.Evaluate(uevent) {
+					dedup.add(uevent.KObj, kernelSeqNum(sysRoot))
+					outbox = append(outbox, uevent)
+				}
+			case err := <-devicesErrs:
+				errbox = append(errbox, err)
+			case uevent, more := <-liveIn:
+				switch {
+				case !more:
+					live = nil
+				case matcher != nil && !matcher.Evaluate(uevent):
+				case devices != nil:
+					dedup.hold(uevent)
+				default:
+					outbox = append(outbox, uevent)
+				}
+			case err, more := <-liveErrs:
+				switch {
+				case !more:
+					liveErrs = nil
+				case err != ctx.Err():
+					errbox = append(errbox, err)
+				}
+			case out <- next:
+				outbox = outbox[1:]
+			case errOut <- nextErr:
+				errbox = errbox[1:]
+			case <-done:
+				done, cancelled = nil, true // drain workers until they exit
+			}
+		}
+	}()
+}
+
+// defaultMaxHeld is the default max number of uevents held during the enumeration (see: WithMaxHeld)
+const defaultMaxHeld = 4096
+
+// enumeratedDevices hold uevents received during the enumeration, to drop the "add" ones of enumerated devices
+type enumeratedDevices struct {
+	seqNums map[string]uint64 // devpath => kernel SEQNUM when the device was enumerated (0 if unknown)
+	held    []netlink.UEvent  // uevents received during the enumeration
+	maxHeld int
+	dropped int // uevents dropped because held was full
+}
+
+func newEnumeratedDevices(maxHeld int) *enumeratedDevices {
+	if maxHeld <= 0 {
+		maxHeld = defaultMaxHeld
+	}
+	return &enumeratedDevices{seqNums: make(map[string]uint64), maxHeld: maxHeld}
+}
+
+// add register an enumerated device, seqNum is the kernel SEQNUM read once the device was crawled
+func (s *enumeratedDevices) add(kObj string, seqNum uint64) {
+	s.seqNums[kObj] = seqNum
+}
+
+// hold keep an uevent received during the enumeration, it's dropped and counted once maxHeld uevents are held
+func (s *enumeratedDevices) hold(e netlink.UEvent) {
+	if len(s.held) >= s.maxHeld {
+		s.dropped++
+		return
+	}
+	s.held = append(s.held, e)
+}
+
+// release return held uevents which aren't duplicated, in order, and an error if some were dropped by hold.
+// The set is cleared: next uevents are live and never duplicates.
+func (s *enumeratedDevices) release() ([]netlink.UEvent, error) {
+	var released []netlink.UEvent
+	for _, uevent := range s.held {
+		if s.keep(uevent) {
+			released = append(released, uevent)
+		}
+	}
+
+	var err error
+	if s.dropped > 0 {
+		err = fmt.Errorf("Unable to hold uevents received during the enumeration (dropped: %d), err: %w", s.dropped, netlink.ErrUEventOverflow)
+	}
+	*s = enumeratedDevices{maxHeld: s.maxHeld}
+	return released, err
+}
+
+// keep return false if the uevent is the "add" of an enumerated device which happened before it was enumerated
+// (or without SEQNUM to compare), the device is forgotten once an "add" is checked or once it is removed (or moved)
+func (s *enumeratedDevices) keep(e netlink.UEvent) bool {
+	switch e.Action {
+	case netlink.ADD:
+		seqNum, ok := s.seqNums[e.KObj]
+		if !ok {
+			return true
+		}
+		delete(s.seqNums, e.KObj)
+		return seqNum != 0 && e.SeqNum > seqNum // ie: plugged again after the enumeration
+	case netlink.REMOVE:
+		delete(s.seqNums, e.KObj)
+	case netlink.MOVE:
+		delete(s.seqNums, e.Env["DEVPATH_OLD"])
+	}
+	return true
+}
+
+// kernelSeqNum return the SEQNUM of the last uevent sent by the kernel, 0 if it can't be read
+func kernelSeqNum(sysRoot string) uint64 {
+	data, err := os.ReadFile(filepath.Join(sysRoot, "kernel", "uevent_seqnum"))
+	if err != nil {
+		return 0
+	}
+	seqNum, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seqNum
+}
+
+// deviceUEvent return the "add" uevent of an enumerated device, with KObj relative to sysfs like in uevents
+func deviceUEvent(sysRoot string, device Device) netlink.UEvent {
+	kObj := strings.TrimPrefix(device.KObj, sysRoot)
+	env := make(map[string]string, len(device.Env)+2)
+	for k, v := range device.Env {
+		env[k] = v
+	}
+	env["ACTION"] = netlink.ADD.String()
+	env["DEVPATH"] = kObj
+	return netlink.UEvent{Action: netlink.ADD, KObj: kObj, Env: env}
+}
@@ -0,0 +1,210 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pilebones/go-udev/netlink"
+)
+
+// sendUEvent send the uevent to conn like the kernel, conn must trust all senders
+func sendUEvent(t *testing.T, conn *netlink.UEventConn, uevent netlink.UEvent) {
+	t.Helper()
+
+	addr, err := syscall.Getsockname(conn.Fd)
+	if err != nil {
+		t.Fatal("unable to get netlink socket address, err:", err)
+	}
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		t.Fatal("unable to open netlink socket, err:", err)
+	}
+	defer syscall.Close(fd)
+
+	if uevent.Env == nil {
+		uevent.Env = make(map[string]string)
+	}
+	uevent.Env["ACTION"] = uevent.Action.String()
+	uevent.Env["DEVPATH"] = uevent.KObj
+	if err := syscall.Sendto(fd, uevent.Bytes(), 0, addr); err != nil {
+		t.Fatal("unable to send uevent, err:", err)
+	}
+}
+
+func TestEnumerateAndMonitor(t *testing.T) {
+	root := newSysfsFixture(t, map[string]string{
+		"devices/virtual/mem/null":   "MAJOR=1\nMINOR=3\nDEVNAME=null\n",
+		"devices/virtual/mem/random": "MAJOR=1\nMINOR=8\nDEVNAME=random\n",
+		"devices/virtual/mem/zero":   "MAJOR=1\nMINOR=5\nDEVNAME=zero\n",
+		"devices/virtual/foo":        "",
+	})
+	if err := os.MkdirAll(filepath.Join(root, "kernel"), 0755); err != nil {
+		t.Fatal("unable to create fixture, err:", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "kernel", "uevent_seqnum"), []byte("100\n"), 0644); err != nil {
+		t.Fatal("unable to create fixture, err:", err)
+	}
+
+	conn := &netlink.UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(netlink.UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+
+	// Kernel SEQNUM is 100 when devices are enumerated
+	env := func(seqNum string) map[string]string {
+		return map[string]string{"MAJOR": "1", "SEQNUM": seqNum}
+	}
+	for _, uevent := range []netlink.UEvent{
+		{Action: netlink.ADD, KObj: "/devices/virtual/mem/null", Env: env("50")}, // duplicate of an existing device
+		{Action: netlink.CHANGE, KObj: "/devices/virtual/mem/zero", Env: env("51")},
+		{Action: netlink.REMOVE, KObj: "/devices/virtual/mem/zero", Env: env("52")},
+		{Action: netlink.ADD, KObj: "/devices/virtual/mem/zero", Env: env("53")},    // plugged again
+		{Action: netlink.ADD, KObj: "/devices/virtual/foo"},                         // not matched
+		{Action: netlink.ADD, KObj: "/devices/virtual/mem/full", Env: env("54")},    // new device
+		{Action: netlink.ADD, KObj: "/devices/virtual/mem/random", Env: env("150")}, // triggered after its enumeration
+	} {
+		sendUEvent(t, conn, uevent)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue, errs := make(chan netlink.UEvent), make(chan error, 1)
+	matcher := netlink.NewEnvRule("MAJOR", "1")
+	enumerateAndMonitor(ctx, conn, filepath.Join(root, "devices"), queue, errs, &matcher)
+	// The enumeration is blocked by the backpressure until queue is read, so uevents are held meanwhile
+	time.Sleep(100 * time.Millisecond)
+
+	expected := []struct {
+		action   netlink.KObjAction
+		kObj     string
+		existing bool
+	}{
+		{netlink.ADD, "/devices/virtual/mem/null", true},
+		{netlink.ADD, "/devices/virtual/mem/random", true},
+		{netlink.ADD, "/devices/virtual/mem/zero", true},
+		{netlink.CHANGE, "/devices/virtual/mem/zero", false},
+		{netlink.REMOVE, "/devices/virtual/mem/zero", false},
+		{netlink.ADD, "/devices/virtual/mem/zero", false},
+		{netlink.ADD, "/devices/virtual/mem/full", false},
+		{netlink.ADD, "/devices/virtual/mem/random", false},
+	}
+	for _, e := range expected {
+		select {
+		case uevent := <-queue:
+			if uevent.Action != e.action || uevent.KObj != e.kObj || uevent.ReceivedAt.IsZero() != e.existing {
+				t.Fatalf("wrong uevent (got: %s@%s received at %v, wanted: %s@%s, existing: %v)", uevent.Action, uevent.KObj, uevent.ReceivedAt, e.action, e.kObj, e.existing)
+			}
+			if uevent.Env["DEVPATH"] != e.kObj || uevent.Env["ACTION"] != e.action.String() {
+				t.Fatalf("wrong env of %s (got: %v)", uevent.KObj, uevent.Env)
+			}
+		case err := <-errs:
+			t.Fatal("unexpected error, err:", err)
+		case <-time.After(time.Second):
+			t.Fatalf("missing %s@%s", e.action, e.kObj)
+		}
+	}
+
+	// Live uevents aren't deduplicated anymore
+	for _, uevent := range []netlink.UEvent{
+		{Action: netlink.REMOVE, KObj: "/devices/virtual/mem/null", Env: env("200")},
+		{Action: netlink.ADD, KObj: "/devices/virtual/mem/null", Env: env("10")},
+	} {
+		sendUEvent(t, conn, uevent)
+		select {
+		case got := <-queue:
+			if got.Action != uevent.Action || got.KObj != uevent.KObj {
+				t.Fatalf("wrong live uevent (got: %s@%s, wanted: %s@%s)", got.Action, got.KObj, uevent.Action, uevent.KObj)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("missing live %s@%s", uevent.Action, uevent.KObj)
+		}
+	}
+
+	cancel()
+	for err := range errs {
+		t.Fatal("cancellation shouldn't be reported, err:", err)
+	}
+	if _, more := <-queue; more {
+		t.Fatal("queue should be closed")
+	}
+	if conn.IsConnected() {
+		t.Fatal("socket should be closed")
+	}
+}
+
+func TestEnumerateAndMonitorWrongMatcher(t *testing.T) {
+	conn := &netlink.UEventConn{}
+	if err := conn.Connect(netlink.UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+
+	queue, errs := make(chan netlink.UEvent), make(chan error, 1)
+	matcher := netlink.RuleDefinition{Env: map[string]string{"DEVPATH": "("}}
+	enumerateAndMonitor(context.Background(), conn, t.TempDir(), queue, errs, &matcher)
+	if err := <-errs; err == nil {
+		t.Fatal("wrong matcher should be reported")
+	}
+	if _, more := <-queue; more {
+		t.Fatal("queue should be closed")
+	}
+}
+
+func TestEnumeratedDevices(t *testing.T) {
+	dedup := newEnumeratedDevices(0)
+	for _, kObj := range []string{"/devices/a", "/devices/b", "/devices/c"} {
+		dedup.add(kObj, 0) // SEQNUM unknown
+	}
+	dedup.add("/devices/s", 100)
+
+	for _, c := range []struct {
+		uevent netlink.UEvent
+		kept   bool
+	}{
+		{netlink.UEvent{Action: netlink.ADD, KObj: "/devices/a", SeqNum: 200}, false},
+		{netlink.UEvent{Action: netlink.ADD, KObj: "/devices/a"}, true}, // only the first one is a duplicate
+		{netlink.UEvent{Action: netlink.REMOVE, KObj: "/devices/b"}, true},
+		{netlink.UEvent{Action: netlink.ADD, KObj: "/devices/b"}, true},
+		{netlink.UEvent{Action: netlink.MOVE, KObj: "/devices/d", Env: map[string]string{"DEVPATH_OLD": "/devices/c"}}, true},
+		{netlink.UEvent{Action: netlink.ADD, KObj: "/devices/c"}, true},
+		{netlink.UEvent{Action: netlink.CHANGE, KObj: "/devices/e"}, true},
+		{netlink.UEvent{Action: netlink.ADD, KObj: "/devices/s", SeqNum: 100}, false}, // before its enumeration
+	} {
+		if kept := dedup.keep(c.uevent); kept != c.kept {
+			t.Fatalf("wrong dedup of %s@%s (got: %v, wanted: %v)", c.uevent.Action, c.uevent.KObj, kept, c.kept)
+		}
+	}
+
+	// Plugged again after its enumeration
+	dedup.add("/devices/s", 100)
+	if !dedup.keep(netlink.UEvent{Action: netlink.ADD, KObj: "/devices/s", SeqNum: 101}) {
+		t.Fatal("add uevent after the enumeration of the device should be kept")
+	}
+}
+
+func TestEnumeratedDevicesRelease(t *testing.T) {
+	dedup := newEnumeratedDevices(2)
+	dedup.add("/devices/a", 0)
+	for _, kObj := range []string{"/devices/a", "/devices/b", "/devices/c", "/devices/d"} {
+		dedup.hold(netlink.UEvent{Action: netlink.ADD, KObj: kObj})
+	}
+
+	released, err := dedup.release()
+	if len(released) != 1 || released[0].KObj != "/devices/b" {
+		t.Fatalf("wrong released uevents (got: %v)", released)
+	}
+	if !errors.Is(err, netlink.ErrUEventOverflow) || !strings.Contains(err.Error(), "dropped: 2") {
+		t.Fatal("dropped uevents should be reported as an overflow, got:", err)
+	}
+
+	// The set is cleared
+	released, err = dedup.release()
+	if len(released) != 0 || err != nil || len(dedup.seqNums) != 0 {
+		t.Fatalf("set should be cleared (got: %v, err: %v)", released, err)
+	}
+}
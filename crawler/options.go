@@ -0,0 +1,64 @@
+package crawler
+
+// Option allow to customize the crawling of existing devices
+type Option func(*options)
+
+type options struct {
+	attributes  bool // read sysfs attributes of each device
+	concurrency int  // number of workers handling devices, serial crawling if lower than 2
+	settled     bool // send a Device with Settled at the end of a complete crawling
+
+	skipUnreadable bool // ignore unreadable directories and uevent files instead of aborting the crawling
+	maxHeld        int  // max uevents held by EnumerateAndMonitor during the enumeration (default: defaultMaxHeld)
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithAttributes enable the reading of sysfs attributes (see: Device.Attrs).
+// It's disabled by default because reading all attribute files slows down the crawling.
+func WithAttributes() Option {
+	return func(o *options) {
+		o.attributes = true
+	}
+}
+
+// WithConcurrency allow to handle up to n devices at the same time (ie: read uevent and attribute files).
+// Devices are sent on the queue without any order.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// WithSettled enable the sending of a sentinel Device with Settled set to true (and no KObj nor Env) after the last
+// existing device, once the crawling is complete (not on error nor abort). It tells the exact moment the initial
+// enumeration is done, ie: to switch to live mode when devices and uevents are handled by the same consumer.
+// To not miss uevents between the enumeration and the monitoring, start Monitor first, then crawl and handle existing
+// devices until the sentinel, then handle the uevents received in the meantime (some devices could be in both).
+func WithSettled() Option {
+	return func(o *options) {
+		o.settled = true
+	}
+}
+
+// WithSkipUnreadable silently skip unreadable directories and uevent files (ie: permission denied) and continue the
+// crawling. By default the crawling is aborted and a *PathError with the offending path is sent on errs.
+func WithSkipUnreadable() Option {
+	return func(o *options) {
+		o.skipUnreadable = true
+	}
+}
+
+// WithMaxHeld allow EnumerateAndMonitor to hold up to n uevents received during the enumeration (default: 4096),
+// next ones are dropped and reported with an error wrapping netlink.ErrUEventOverflow.
+func WithMaxHeld(n int) Option {
+	return func(o *options) {
+		o.maxHeld = n
+	}
+}
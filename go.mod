@@ -1,8 +1,11 @@
 module github.com/pilebones/go-udev
 
-go 1.17
+go 1.23
 
-require github.com/kr/pretty v0.3.0
+require (
+	github.com/kr/pretty v0.3.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/kr/text v0.2.0 // indirect
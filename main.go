@@ -1,67 +1,134 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/pilebones/go-udev/crawler"
 	"github.com/pilebones/go-udev/netlink"
-
-	"github.com/kr/pretty"
 )
 
 var (
 	filePath              *string
 	monitorMode, infoMode *bool
+	attrsMode             *bool
+	statsMode             *bool
+	testMode              *bool
+	eventPath             *string
+	sourceMode            *string
+	jsonOutput            *bool
+	fieldsFilter          *string
+	countLimit            *int
+	forwardAddr           *string
 )
 
 func init() {
-	filePath = flag.String("file", "", "Optionnal input file path with matcher-rules (default: no matcher)")
+	filePath = flag.String("file", "", "Optionnal input file path with matcher-rules in JSON or YAML (default: no matcher)")
 	monitorMode = flag.Bool("monitor", false, "Enable monitor mode")
 	infoMode = flag.Bool("info", false, "Enable crawler mode")
+	attrsMode = flag.Bool("attrs", false, "Read sysfs attributes of devices in crawler mode (slower)")
+	statsMode = flag.Bool("stats", false, "Print a summary of devices per subsystem at the end of crawler mode")
+	testMode = flag.Bool("test", false, "Test matcher-rules of -file against the captured uevents of -event, without monitoring")
+	eventPath = flag.String("event", "", "Input file path with captured uevents for test mode (raw msg, \"KEY=VALUE\" lines, JSON or record file)")
+	sourceMode = flag.String("mode", "udev", "Source of uevents in monitor mode: kernel, udev or both")
+	jsonOutput = flag.Bool("json", false, "Print each uevent or device as one JSON object per line on stdout (ie: for jq)")
+	fieldsFilter = flag.String("fields", "", "Comma-separated env keys to print, ie: SUBSYSTEM,DEVNAME (default: all env)")
+	countLimit = flag.Int("count", 0, "Exit monitor mode after N uevents matched by the matcher-rules (default: no limit)")
+	forwardAddr = flag.String("forward", "", "Forward uevents of monitor mode as JSON lines to unix:<path> or host:port")
+}
+
+// parseMode return the netlink mode of the -mode flag
+func parseMode(s string) (netlink.Mode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "kernel":
+		return netlink.KernelEvent, nil
+	case "udev":
+		return netlink.UdevEvent, nil
+	case "both":
+		return netlink.AllEvents, nil
+	}
+	return 0, fmt.Errorf("Wrong mode (got: %q, wanted: kernel, udev or both)", s)
 }
 
 func main() {
 	flag.Parse()
 
-	*monitorMode = true // Debuging을 위한 Option추가(모니터 모드 강제 활성화)
+	if *testMode {
+		test()
+		return
+	}
+
 	// *filePath = "matcher.sample" // Debuging을 위한 Option추가(Rule 파일 설정)
 
-	matcher, err := getOptionnalMatcher() // 원하는 Device만 출력하는 Rule을 적용할 때 사용.(Rule은 "matcher.sample" 참고)
+	run, err := chooseMode(*monitorMode, *infoMode)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	if monitorMode == nil && infoMode == nil {
-		log.Fatalln("You should use only one mode:", os.Args[0], "-monitor|-info")
+	mode, err := parseMode(*sourceMode)
+	if err != nil {
+		log.Fatalln(err)
 	}
+	fields = parseFields(*fieldsFilter)
 
-	if (monitorMode != nil && *monitorMode) && (infoMode != nil && *infoMode) {
-		log.Fatalln("Unable to enable both mode : monitor & info")
+	if *countLimit < 0 {
+		log.Fatalln("Wrong count (got:", *countLimit, "wanted: a positive number)")
 	}
 
-	if *monitorMode {
-		monitor(matcher)
+	matcher, err := getOptionnalMatcher() // 원하는 Device만 출력하는 Rule을 적용할 때 사용.(Rule은 "matcher.sample" 참고)
+	if err != nil {
+		log.Fatalln(err)
 	}
 
-	if *infoMode {
+	switch run {
+	case runMonitor:
+		monitor(matcher, mode)
+	case runInfo:
 		info(matcher)
 	}
 }
 
+// runMode is the mode of the CLI chosen with -monitor or -info
+type runMode int
+
+const (
+	runMonitor runMode = iota + 1
+	runInfo
+)
+
+// chooseMode return the mode enabled by -monitor and -info flags, exactly one of them must be set
+func chooseMode(monitor, info bool) (runMode, error) {
+	switch {
+	case monitor && info:
+		return 0, fmt.Errorf("Unable to enable both mode : monitor & info")
+	case monitor:
+		return runMonitor, nil
+	case info:
+		return runInfo, nil
+	}
+	return 0, fmt.Errorf("You should use only one mode: %s -monitor|-info", os.Args[0])
+}
+
 // info run info mode
 func info(matcher netlink.Matcher) {
 	log.Println("Get existing devices...")
 
 	queue := make(chan crawler.Device)
 	errors := make(chan error)
-	quit := crawler.ExistingDevices(queue, errors, matcher)
+	var opts []crawler.Option
+	if *attrsMode {
+		opts = append(opts, crawler.WithAttributes())
+	}
+	quit := crawler.ExistingDevices(queue, errors, matcher, opts...)
+	var stats netlink.Stats
 
 	// Signal handler to quit properly monitor mode
 	signals := make(chan os.Signal, 1)
@@ -79,29 +146,156 @@ func info(matcher netlink.Matcher) {
 		case device, more := <-queue:
 			if !more {
 				log.Println("Finished processing existing devices")
+				if *statsMode {
+					log.Println("Summary:", stats.Snapshot())
+				}
 				return
 			}
-			log.Println("Detect device at", device.KObj, "with env", device.Env)
+			stats.Add(netlink.UEvent{Action: netlink.ADD, KObj: device.KObj, Env: device.Env})
+			printDevice(device)
 		case err := <-errors:
 			log.Println("ERROR:", err)
 		}
 	}
 }
 
+// test run test mode: print whether the rules match each captured uevent and which rules matched
+func test() {
+	if *filePath == "" || *eventPath == "" {
+		log.Fatalln("Test mode requires rules and uevents:", os.Args[0], "-test -file <rules> -event <uevents>")
+	}
+
+	rules, err := netlink.LoadRules(*filePath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	uevents, err := loadUEvents(*eventPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	for _, uevent := range uevents {
+		if _, err := netlink.TestRules(rules, uevent); err != nil {
+			log.Fatalln(err)
+		}
+
+		matched, index, rule := rules.EvaluateMatch(uevent)
+		switch {
+		case matched && rule != nil:
+			fmt.Printf("%s@%s: matched by rule #%d (%s)\n", uevent.Action, uevent.KObj, index+1, rule)
+		case matched: // only negated rules
+			fmt.Printf("%s@%s: matched (not excluded by negated rules)\n", uevent.Action, uevent.KObj)
+		case rule != nil:
+			fmt.Printf("%s@%s: excluded by rule #%d (%s)\n", uevent.Action, uevent.KObj, index+1, rule)
+		default:
+			fmt.Printf("%s@%s: not matched\n", uevent.Action, uevent.KObj)
+		}
+	}
+}
+
+// loadUEvents read captured uevents from a file which could be a record file (see: netlink.Recorder),
+// an uevent in JSON, a raw msg or "KEY=VALUE" lines (ie: output of "udevadm monitor --property")
+func loadUEvents(path string) ([]netlink.UEvent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read uevents, err: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("GOUDEV")):
+		replayer, err := netlink.NewReplayer(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		var uevents []netlink.UEvent
+		for {
+			_, msg, err := replayer.ReadMsg()
+			if err == io.EOF {
+				return uevents, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			uevent, err := netlink.ParseUEvent(msg)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to parse recorded uevent, err: %w", err)
+			}
+			uevents = append(uevents, *uevent)
+		}
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		var uevent netlink.UEvent
+		if err := json.Unmarshal(data, &uevent); err != nil {
+			return nil, fmt.Errorf("Unable to parse uevent, err: %w", err)
+		}
+		return []netlink.UEvent{uevent}, nil
+	case !bytes.Contains(data, []byte{0x00}):
+		// "KEY=VALUE" lines, with an "action@devpath" header or like the output of "udevadm monitor --property"
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		at, eq := strings.Index(lines[0], "@"), strings.Index(lines[0], "=")
+		if at <= 0 || (eq >= 0 && eq < at) {
+			uevents, err := netlink.ParseUdevadmMonitor(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return uevents, nil
+		}
+		data = []byte(strings.Join(lines, "\x00"))
+	}
+
+	uevent, err := netlink.ParseUEvent(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse uevent, err: %w", err)
+	}
+	return []netlink.UEvent{*uevent}, nil
+}
+
 // monitor run monitor mode(모니터 모드 함수)
-func monitor(matcher netlink.Matcher) {
+func monitor(matcher netlink.Matcher, mode netlink.Mode) {
 	log.Println("Monitoring UEvent kernel message to user-space...")
 
-	conn := new(netlink.UEventConn)
-	// 소켓 통신(netlink.UdevEvent : 커널 이벤트가 아닌 udev 이벤트로 설정 / 커널 이벤트보다 더 많은 정보를 제공)
-	if err := conn.Connect(netlink.UdevEvent); err != nil {
-		log.Fatalln("Unable to connect to Netlink Kobject UEvent socket")
+	if *forwardAddr != "" {
+		var err error
+		if forwarder, err = netlink.NewForwarder(*forwardAddr, forwardQueueSize, log.Default()); err != nil {
+			log.Fatalln(err)
+		}
+		defer func() {
+			forwarder.Close() // pending uevents are written before exit
+			if dropped := forwarder.Dropped(); dropped > 0 {
+				log.Println("Uevents dropped by -forward:", dropped)
+			}
+		}()
+	}
+
+	conn := newConn()
+	// 소켓 통신(-mode 옵션, 기본값 netlink.UdevEvent : 커널 이벤트가 아닌 udev 이벤트로 설정 / 커널 이벤트보다 더 많은 정보를 제공)
+	if err := conn.Connect(mode); err != nil {
+		log.Fatalln("Unable to connect to Netlink Kobject UEvent socket, err:", err)
 	}
 	defer conn.Close()
 
-	queue := make(chan netlink.UEvent)           // 장치 Event가 발생했을 때 해당 정보를 담기 위한 Queue
-	errors := make(chan error)                   // Error 관련 채널
-	quit := conn.Monitor(queue, errors, matcher) // 모니터 모드 시작(quit : 종료)
+	queue := make(chan netlink.UEvent) // 장치 Event가 발생했을 때 해당 정보를 담기 위한 Queue
+	errors := make(chan error)         // Error 관련 채널
+	reloadable := netlink.NewReloadableMatcher(matcher)
+	quit := conn.Monitor(queue, errors, reloadable) // 모니터 모드 시작(quit : 종료)
+
+	// Reload rules of -file on SIGHUP without restarting the monitoring
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	defer signal.Stop(reloads)
+	go func() {
+		for range reloads {
+			matcher, err := getOptionnalMatcher()
+			if err == nil {
+				err = reloadable.Reload(matcher)
+			}
+			if err != nil {
+				log.Println("Unable to reload matcher-rules, keeping the current ones, err:", err)
+				continue
+			}
+			log.Println("Matcher-rules reloaded")
+		}
+	}()
 
 	// Signal handler to quit properly monitor mode
 	signals := make(chan os.Signal, 1)
@@ -109,43 +303,47 @@ func monitor(matcher netlink.Matcher) {
 	go func() {
 		<-signals
 		log.Println("Exiting monitor mode...")
-		close(quit)
-		os.Exit(0)
+		close(quit) // queue and errors are closed by the worker once stopped
 	}()
 
-	// Handling message from queue
+	// Handling message from queue until both channels are closed
 	// 메시지를 출력하는 부분
-	for {
+	for queue != nil || errors != nil {
 		select {
-		case uevent := <-queue:
-			log.Println("Handle", pretty.Sprint(uevent))
-		case err := <-errors:
+		case uevent, more := <-queue:
+			if !more {
+				queue = nil
+				continue
+			}
+			printUEvent(uevent)
+		case err, more := <-errors:
+			if !more {
+				errors = nil
+				continue
+			}
 			log.Println("ERROR:", err)
 		}
 	}
+	log.Println("Monitoring stopped:", conn.StopReason())
+}
 
+// newConn return the connection of monitor mode configured with flags
+func newConn() *netlink.UEventConn {
+	return &netlink.UEventConn{
+		MatchedUEventLimit: *countLimit, // -count: the monitoring stops and queue is closed once reached
+	}
 }
 
-// getOptionnalMatcher Parse and load config file which contains rules for matching
+// getOptionnalMatcher Parse and load config file which contains rules for matching (JSON or YAML)
 // 규칙을 정해놓은 파일이 존재하는지 확인하고, 로드함.
 func getOptionnalMatcher() (matcher netlink.Matcher, err error) {
 	if filePath == nil || *filePath == "" {
 		return nil, nil
 	}
 
-	stream, err := ioutil.ReadFile(*filePath)
+	rules, err := netlink.LoadRules(*filePath)
 	if err != nil {
 		return nil, err
 	}
-
-	if stream == nil {
-		return nil, fmt.Errorf("Empty, no rules provided in \"%s\", err: %w", *filePath, err)
-	}
-
-	var rules netlink.RuleDefinitions
-	if err := json.Unmarshal(stream, &rules); err != nil {
-		return nil, fmt.Errorf("Wrong rule syntax, err: %w", err)
-	}
-
-	return &rules, nil
+	return rules, nil
 }
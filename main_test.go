@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pilebones/go-udev/crawler"
+	"github.com/pilebones/go-udev/netlink"
+)
+
+func TestParseMode(t *testing.T) {
+	for input, expected := range map[string]netlink.Mode{
+		"kernel": netlink.KernelEvent,
+		"udev":   netlink.UdevEvent,
+		"both":   netlink.AllEvents,
+		"Both ":  netlink.AllEvents,
+	} {
+		mode, err := parseMode(input)
+		if err != nil || mode != expected {
+			t.Fatalf("wrong mode for %q (got: %s, wanted: %s, err: %v)", input, mode, expected, err)
+		}
+	}
+
+	for _, input := range []string{"", "all", "kernel|udev"} {
+		if _, err := parseMode(input); err == nil {
+			t.Fatalf("wrong mode should be rejected (got: %q)", input)
+		}
+	}
+}
+
+func TestModeFlag(t *testing.T) {
+	f := flag.CommandLine.Lookup("mode")
+	if f == nil || f.DefValue != "udev" {
+		t.Fatal("-mode flag should default to udev")
+	}
+
+	defer f.Value.Set(f.DefValue)
+	defer flag.CommandLine.Lookup("monitor").Value.Set("false")
+	if err := flag.CommandLine.Parse([]string{"-monitor", "-mode", "kernel"}); err != nil {
+		t.Fatal("unable to parse flags, err:", err)
+	}
+	if mode, err := parseMode(*sourceMode); err != nil || mode != netlink.KernelEvent {
+		t.Fatalf("-mode should drive the netlink mode (got: %s, err: %v)", mode, err)
+	}
+}
+
+func TestChooseMode(t *testing.T) {
+	for _, tcase := range []struct {
+		monitor, info bool
+		expected      runMode
+	}{
+		{monitor: true, expected: runMonitor},
+		{info: true, expected: runInfo},
+		{monitor: true, info: true},
+		{},
+	} {
+		run, err := chooseMode(tcase.monitor, tcase.info)
+		if run != tcase.expected || (err == nil) != (tcase.expected != 0) {
+			t.Fatalf("wrong mode for -monitor=%v -info=%v (got: %d, wanted: %d, err: %v)", tcase.monitor, tcase.info, run, tcase.expected, err)
+		}
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(w io.Writer, enabled bool) { stdout, *jsonOutput = w, enabled }(stdout, *jsonOutput)
+	stdout, *jsonOutput = &buf, true
+
+	printUEvent(netlink.UEvent{Action: netlink.ADD, KObj: "/devices/foo", SeqNum: 42, Env: map[string]string{"SEQNUM": "42", "SUBSYSTEM": "usb"}})
+	printDevice(crawler.Device{KObj: "/sys/devices/bar", Env: map[string]string{"DEVNAME": "bar"}, Attrs: map[string]string{"vendor": "0x8086"}})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("one JSON object per line is expected (got: %q)", buf.String())
+	}
+
+	var uevent netlink.UEvent
+	if err := json.Unmarshal([]byte(lines[0]), &uevent); err != nil {
+		t.Fatal("wrong JSON of uevent, err:", err)
+	}
+	if uevent.Action != netlink.ADD || uevent.KObj != "/devices/foo" || uevent.SeqNum != 42 || uevent.Env["SUBSYSTEM"] != "usb" {
+		t.Fatalf("wrong JSON of uevent (got: %s)", lines[0])
+	}
+
+	var device deviceJSON
+	if err := json.Unmarshal([]byte(lines[1]), &device); err != nil {
+		t.Fatal("wrong JSON of device, err:", err)
+	}
+	if device.Action != "add" || device.KObj != "/sys/devices/bar" || device.Env["DEVNAME"] != "bar" || device.Attrs["vendor"] != "0x8086" {
+		t.Fatalf("wrong JSON of device (got: %s)", lines[1])
+	}
+}
+
+func TestFieldsOutput(t *testing.T) {
+	if parsed := parseFields(" SUBSYSTEM, DEVNAME,,"); len(parsed) != 2 || parsed[0] != "SUBSYSTEM" || parsed[1] != "DEVNAME" {
+		t.Fatalf("wrong parsed fields (got: %q)", parsed)
+	}
+	if parseFields("") != nil {
+		t.Fatal("all env should be printed without -fields")
+	}
+
+	var buf bytes.Buffer
+	defer func(w io.Writer, enabled bool, keys []string) {
+		stdout, *jsonOutput, fields = w, enabled, keys
+	}(stdout, *jsonOutput, fields)
+	stdout, *jsonOutput, fields = &buf, true, []string{"SUBSYSTEM", "UNKNOWN"}
+
+	printUEvent(netlink.UEvent{Action: netlink.ADD, KObj: "/devices/foo", Env: map[string]string{"SUBSYSTEM": "usb", "DEVNAME": "bus/usb/001/005"}})
+	var uevent netlink.UEvent
+	if err := json.Unmarshal(buf.Bytes(), &uevent); err != nil {
+		t.Fatal("wrong JSON of uevent, err:", err)
+	}
+	if uevent.Action != netlink.ADD || uevent.KObj != "/devices/foo" || len(uevent.Env) != 1 || uevent.Env["SUBSYSTEM"] != "usb" {
+		t.Fatalf("only selected env should be printed with action and kobj (got: %s)", buf.String())
+	}
+}
+
+func TestPrettyOutput(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	raw := netlink.UEvent{Action: netlink.ADD, KObj: "/devices/foo", Env: map[string]string{"SUBSYSTEM": "usb"}}.BytesUdev()
+	uevent, err := netlink.ParseUEvent(raw)
+	if err != nil {
+		t.Fatal("unable to parse uevent, err:", err)
+	}
+	printUEvent(*uevent)
+
+	out := buf.String()
+	if !strings.Contains(out, `"/devices/foo"`) || !strings.Contains(out, `"SUBSYSTEM":"usb"`) {
+		t.Fatalf("uevent should be printed (got: %s)", out)
+	}
+	if strings.Contains(out, "[]uint8{") || strings.Contains(out, "Magic") {
+		t.Fatalf("raw msg and header shouldn't be printed (got: %s)", out)
+	}
+	if uevent.Raw == nil || uevent.Header == nil {
+		t.Fatal("printed uevent shouldn't be modified")
+	}
+}
+
+func TestCountFlag(t *testing.T) {
+	f := flag.CommandLine.Lookup("count")
+	defer f.Value.Set(f.DefValue)
+
+	if conn := newConn(); conn.MatchedUEventLimit != 0 {
+		t.Fatalf("monitoring shouldn't be limited by default (got: %d)", conn.MatchedUEventLimit)
+	}
+	if err := flag.CommandLine.Parse([]string{"-count", "1"}); err != nil {
+		t.Fatal("unable to parse flags, err:", err)
+	}
+	if conn := newConn(); conn.MatchedUEventLimit != 1 {
+		t.Fatalf("-count should limit the matched uevents (got: %d)", conn.MatchedUEventLimit)
+	}
+}
+
+func TestForwardOutput(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("unable to listen, err:", err)
+	}
+	defer l.Close()
+
+	defer func(f *netlink.Forwarder, w io.Writer, enabled bool, keys []string) {
+		forwarder, stdout, *jsonOutput, fields = f, w, enabled, keys
+	}(forwarder, stdout, *jsonOutput, fields)
+	if forwarder, err = netlink.NewForwarder(l.Addr().String(), forwardQueueSize, nil); err != nil {
+		t.Fatal("unable to create forwarder, err:", err)
+	}
+	defer forwarder.Close()
+	var out bytes.Buffer
+	stdout, *jsonOutput, fields = &out, true, []string{"SUBSYSTEM"}
+
+	printUEvent(netlink.UEvent{Action: netlink.ADD, KObj: "/devices/foo", Env: map[string]string{"SUBSYSTEM": "usb", "DEVNAME": "bus/usb/001/005"}})
+	if strings.Contains(out.String(), "DEVNAME") {
+		t.Fatalf("only selected env should be printed (got: %s)", out.String())
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal("unable to accept, err:", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	var uevent netlink.UEvent
+	if err := json.NewDecoder(conn).Decode(&uevent); err != nil {
+		t.Fatal("wrong forwarded JSON line, err:", err)
+	}
+	if uevent.KObj != "/devices/foo" || len(uevent.Env) != 2 || uevent.Env["DEVNAME"] != "bus/usb/001/005" {
+		t.Fatalf("uevent should be forwarded with the whole env, whatever -fields (got: %+v)", uevent)
+	}
+}
@@ -0,0 +1,72 @@
+package netlink
+
+import (
+	"fmt"
+	"time"
+)
+
+// MonitorBatch is like Monitor but uevents matched by the matcher are delivered by batches on batches, ie: to insert
+// them into a database at once. A batch is sent as soon as it holds maxBatch uevents or maxWait elapsed since its first
+// uevent (no time trigger if maxWait isn't positive), so an idle socket never holds a batch longer than maxWait.
+// The partial batch is flushed when the worker exit (on quit, limits or fatal error) then batches is closed,
+// so batches must be read until closed. MatchedUEventLimit counts uevents, not batches.
+func (c *UEventConn) MonitorBatch(batches chan []UEvent, errs chan error, matcher Matcher, maxBatch int, maxWait time.Duration) chan struct{} {
+	if maxBatch < 1 {
+		quit := make(chan struct{}, 1)
+		quit <- struct{}{}
+		c.setStopReason(StopError)
+		close(batches)
+		reportAndClose(errs, fmt.Errorf("Wrong batch size (got: %d, wanted: at least 1)", maxBatch))
+		return quit
+	}
+
+	queue := make(chan UEvent, maxBatch)
+	quit := c.Monitor(queue, errs, matcher)
+	go batchUEvents(queue, batches, maxBatch, maxWait)
+	return quit
+}
+
+// batchUEvents group uevents of queue into batches until queue is closed, then flush the partial batch and close batches
+func batchUEvents(queue chan UEvent, batches chan []UEvent, maxBatch int, maxWait time.Duration) {
+	defer close(batches)
+
+	var (
+		batch   []UEvent
+		timer   *time.Timer
+		expired <-chan time.Time // nil while the batch is empty, so it never trips
+	)
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+		if len(batch) > 0 {
+			batches <- batch
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case uevent, ok := <-queue:
+			if !ok {
+				flush()
+				return
+			}
+			if batch == nil {
+				batch = make([]UEvent, 0, maxBatch)
+				if maxWait > 0 {
+					timer = time.NewTimer(maxWait)
+					expired = timer.C
+				}
+			}
+			batch = append(batch, uevent)
+			if len(batch) >= maxBatch {
+				flush()
+			}
+		case <-expired:
+			timer, expired = nil, nil
+			flush()
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package netlink
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// sendBatchSamples send n add uevents of distinct devices to conn
+func sendBatchSamples(testing *testing.T, conn *UEventConn, n int) {
+	for i := 0; i < n; i++ {
+		kObj := fmt.Sprintf("/devices/virtual/mem/dev%d", i)
+		sample := UEvent{Action: ADD, KObj: kObj, Env: map[string]string{"DEVPATH": kObj}}
+		sendMsg(testing, conn, sample.Bytes())
+	}
+}
+
+func TestMonitorBatchSize(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	batches := make(chan []UEvent)
+	quit := conn.MonitorBatch(batches, make(chan error, 1), nil, 2, time.Hour)
+	sendBatchSamples(testing, conn, 3)
+
+	select {
+	case batch := <-batches:
+		t.FatalfIf(len(batch) != 2 || batch[0].KObj != "/devices/virtual/mem/dev0" || batch[1].KObj != "/devices/virtual/mem/dev1",
+			"Wrong batch flushed by size (got: %v)", batch)
+	case <-time.After(time.Second):
+		t.Fatalf("Full batch should be flushed")
+	}
+	select {
+	case batch := <-batches:
+		t.Fatalf("Partial batch shouldn't be flushed before maxWait (got: %v)", batch)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(quit)
+	waitStopReason(testing, conn)
+	select {
+	case batch := <-batches:
+		t.FatalfIf(len(batch) != 1 || batch[0].KObj != "/devices/virtual/mem/dev2", "Wrong partial batch flushed on quit (got: %v)", batch)
+	case <-time.After(time.Second):
+		t.Fatalf("Partial batch should be flushed on quit")
+	}
+	_, ok := <-batches
+	t.FatalfIf(ok, "Batches should be closed after the last batch")
+}
+
+func TestMonitorBatchTimeout(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	batches := make(chan []UEvent)
+	quit := conn.MonitorBatch(batches, make(chan error, 1), nil, 100, 50*time.Millisecond)
+	defer func() {
+		close(quit)
+		waitStopReason(testing, conn)
+		for range batches {
+		}
+	}()
+
+	start := time.Now()
+	sendBatchSamples(testing, conn, 3)
+	select {
+	case batch := <-batches:
+		t.FatalfIf(len(batch) != 3, "Wrong batch flushed by timer (got: %d uevents, wanted: 3)", len(batch))
+		t.FatalfIf(time.Since(start) < 50*time.Millisecond, "Batch shouldn't be flushed before maxWait")
+	case <-time.After(time.Second):
+		t.Fatalf("Partial batch should be flushed after maxWait")
+	}
+}
+
+func TestMonitorBatchWrongSize(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{}
+	batches, errs := make(chan []UEvent), make(chan error)
+	conn.MonitorBatch(batches, errs, nil, 0, time.Second)
+
+	_, ok := <-batches
+	t.FatalfIf(ok, "Batches should be closed")
+	t.FatalfIf(<-errs == nil, "Wrong batch size should be reported")
+	t.FatalfIf(conn.StopReason() != StopError, "Wrong stop reason (got: %s)", conn.StopReason())
+}
@@ -0,0 +1,155 @@
+package netlink
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Client is a high-level entry point which manage the socket, the monitoring worker and
+// its clean shutdown, ie:
+//
+//	client := new(netlink.Client)
+//	if err := client.Open(netlink.UdevEvent); err != nil { ... }
+//	defer client.Close()
+//	for uevent := range client.Subscribe(matcher) { ... }
+//
+// Options of the underlying connection (ie: ReceiveBufferSize, Dedup...) could be set on Conn before Open.
+// Use UEventConn directly for advanced usages.
+type Client struct {
+	Conn      UEventConn
+	Reconnect bool   // Use MonitorWithReconnect instead of a monitoring stopped on fatal error
+	Logger    Logger // allow to log internal diagnostics of the client and of Conn (default: NopLogger)
+
+	mu         sync.Mutex
+	ctx        context.Context
+	cancel     context.CancelFunc
+	quit       chan struct{}
+	queue      chan UEvent
+	errs       chan error
+	subscribed bool
+	closed     bool
+	matcher    *ReloadableMatcher // matcher of Subscribe, see: Reload
+	slog       *slog.Logger       // logger of delivered uevents, see: SetSlogHandler
+	slogLevel  slog.Level
+}
+
+// Open allow to connect the client to the netlink socket with the mode
+func (c *Client) Open(mode Mode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.errs != nil {
+		return fmt.Errorf("Client already opened")
+	}
+
+	if c.Logger != nil && c.Conn.Logger == nil {
+		c.Conn.Logger = c.Logger
+	}
+	if err := c.Conn.Connect(mode); err != nil {
+		return err
+	}
+	c.Conn.logger().Printf("netlink: client opened (mode: %s)", mode)
+
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.queue = make(chan UEvent)
+	c.errs = make(chan error)
+	return nil
+}
+
+// Subscribe start the monitoring and return the channel of uevents matched by the matcher.
+// The channel is closed once the monitoring is stopped (ie: on Close, limits or fatal error).
+// Only the first call start the monitoring, next ones return the same channel whatever the matcher.
+// If the client isn't opened or already closed, a closed channel is returned.
+func (c *Client) Subscribe(matcher Matcher) <-chan UEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.errs == nil || c.closed {
+		queue := make(chan UEvent)
+		close(queue)
+		return queue
+	}
+
+	if !c.subscribed {
+		c.subscribed = true
+		c.matcher = NewReloadableMatcher(matcher)
+		queue := c.queue
+		if c.slog != nil {
+			queue = make(chan UEvent)
+			go c.logUEvents(queue)
+		}
+
+		if c.Reconnect {
+			c.quit = c.Conn.MonitorWithReconnect(queue, c.errs, c.matcher)
+		} else {
+			c.Conn.MonitorContext(c.ctx, queue, c.errs, c.matcher)
+		}
+	}
+	return c.queue
+}
+
+// Reload replace the matcher of Subscribe without restarting the monitoring, the new matcher is compiled first
+// and the current one is kept on error (see: ReloadableMatcher).
+func (c *Client) Reload(matcher Matcher) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.subscribed || c.closed {
+		return fmt.Errorf("Client isn't monitoring")
+	}
+	if err := c.matcher.Reload(matcher); err != nil {
+		return fmt.Errorf("Wrong matcher, err: %w", err)
+	}
+	c.Conn.logger().Printf("netlink: client matcher reloaded")
+	return nil
+}
+
+// Errors return the channel of errors of the monitoring (nil until the client is opened).
+// It should be consumed concurrently of the uevents, it is closed on Close.
+func (c *Client) Errors() <-chan error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.errs
+}
+
+// Close stop the monitoring, wait for the worker to exit while discarding pending uevents
+// and errors, then close the socket. Close could be called many times.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.errs == nil || c.closed {
+		return nil
+	}
+	c.closed = true
+
+	if !c.subscribed {
+		close(c.queue)
+		close(c.errs)
+		c.cancel()
+		return c.Conn.Close()
+	}
+
+	if c.Reconnect {
+		close(c.quit)
+	}
+	c.cancel()
+
+	// Channels are closed by the worker once stopped
+	c.Conn.logger().Printf("netlink: closing client, pending uevents are discarded")
+	for queue, errs := c.queue, c.errs; queue != nil || errs != nil; {
+		select {
+		case _, more := <-queue:
+			if !more {
+				queue = nil
+			}
+		case _, more := <-errs:
+			if !more {
+				errs = nil
+			}
+		}
+	}
+	return c.Conn.Close()
+}
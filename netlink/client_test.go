@@ -0,0 +1,59 @@
+package netlink
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClient(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	client := new(Client)
+	client.Conn.TrustAllSenders = true
+	if queue := client.Subscribe(nil); queue == nil {
+		t.Fatal("Subscribe should return a closed channel when the client isn't opened")
+	} else if _, more := <-queue; more {
+		t.Fatal("Subscribe should return a closed channel when the client isn't opened")
+	}
+
+	err := client.Open(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to open client, err: %v", err)
+	t.FatalfIf(client.Open(UdevEvent) == nil, "Open twice should fail")
+
+	queue := client.Subscribe(&RuleDefinitions{Rules: []RuleDefinition{{Env: map[string]string{"SUBSYSTEM": "usb"}}}})
+	t.FatalfIf(client.Subscribe(nil) != queue, "Subscribe should return the same channel")
+
+	sendMsg(testing, &client.Conn, UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"SUBSYSTEM": "block"}}.Bytes())
+	sendMsg(testing, &client.Conn, UEvent{Action: ADD, KObj: "/devices/bar", Env: map[string]string{"SUBSYSTEM": "usb"}}.Bytes())
+	select {
+	case uevent := <-queue:
+		t.FatalfIf(uevent.KObj != "/devices/bar", "Wrong uevent, got: %s", uevent.KObj)
+	case err := <-client.Errors():
+		t.Fatal("Unexpected error:", err)
+	case <-time.After(time.Second):
+		t.Fatal("Uevent should be received")
+	}
+
+	t.FatalfIf(client.Close() != nil, "Close should succeed")
+	t.FatalfIf(client.Close() != nil, "Close should be idempotent")
+	_, more := <-queue
+	t.FatalfIf(more, "Queue should be closed")
+	_, more = <-client.Errors()
+	t.FatalfIf(more, "Errors should be closed")
+}
+
+func TestClientWrongMatcher(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	client := &Client{Reconnect: true}
+	client.Conn.TrustAllSenders = true
+	err := client.Open(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to open client, err: %v", err)
+
+	queue := client.Subscribe(&RuleDefinitions{Rules: []RuleDefinition{{Env: map[string]string{"SUBSYSTEM": "("}}}})
+	err = <-client.Errors()
+	t.FatalfIf(err == nil, "Wrong matcher should be reported")
+	_, more := <-queue
+	t.FatalfIf(more, "Queue should be closed")
+	t.FatalfIf(client.Close() != nil, "Close should succeed")
+}
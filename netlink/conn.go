@@ -1,15 +1,23 @@
 package netlink
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"iter"
+	"math"
 	"os"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 type Mode int
 
 // Mode determines event source: kernel events or udev-processed events.
+// It's the bitmask of netlink multicast groups bound on Connect (SockaddrNetlink.Groups): the kernel sends uevents
+// to group 1 and udevd to group 2, other groups are only used by custom senders (see: UEventConn.CustomGroups).
 // See libudev/libudev-monitor.c.
 // 커널 이벤트와 udev 이벤트를 나타내는 Mode
 const (
@@ -18,8 +26,63 @@ const (
 	// Events that are processed by udev - much richer, with more attributes (such as vendor info, serial numbers and more).
 	// Udev 이벤트 vendor 정보나 serial 정보 등의 Kernel 이벤트보다 더 많은 것을 제공.
 	UdevEvent Mode = 2
+	// Both kernel and udev events, Mode is used as a bitmask of netlink multicast groups
+	// 커널 이벤트와 Udev 이벤트 모두 수신
+	AllEvents = KernelEvent | UdevEvent
 )
 
+func (mode Mode) String() string {
+	switch mode {
+	case KernelEvent:
+		return "kernel"
+	case UdevEvent:
+		return "udev"
+	case AllEvents:
+		return "kernel|udev"
+	}
+	return fmt.Sprintf("Mode(%d)", int(mode))
+}
+
+// Has return true if all groups of m are subscribed by mode
+func (mode Mode) Has(m Mode) bool {
+	return mode&m == m
+}
+
+// Validate return an error if mode is empty or contains unknown groups
+func (mode Mode) Validate() error {
+	if mode == 0 || mode&^AllEvents != 0 {
+		return fmt.Errorf("Wrong mode (got: %d, wanted: KernelEvent, UdevEvent or both)", mode)
+	}
+	return nil
+}
+
+// ValidateGroups is like Validate but any bitmask of the 32 netlink multicast groups is allowed
+func (mode Mode) ValidateGroups() error {
+	if mode <= 0 || int64(mode) > math.MaxUint32 {
+		return fmt.Errorf("Wrong netlink groups bitmask (got: %d, wanted: 1 to %d)", mode, uint32(math.MaxUint32))
+	}
+	return nil
+}
+
+// monitorPollTimeout is the max duration Monitor wait on an idle socket before checking quit signal
+const monitorPollTimeout = 100 * time.Millisecond
+
+// maxPooledBufferSize is the max size of read buffers kept in the pool
+const maxPooledBufferSize = 64 * 1024
+
+// ErrUEventOverflow is sent by Monitor when the socket receive buffer overflowed,
+// some uevents have been dropped by the kernel but the monitoring continue
+var ErrUEventOverflow = errors.New("uevent receive buffer overflow, some uevents were dropped")
+
+// ErrConnClosed is sent on errs by monitoring methods started on a closed UEventConn
+var ErrConnClosed = errors.New("netlink connection closed")
+
+// ErrNoEvent is returned by PollUEvent when no msg is available on the socket, it wraps syscall.EAGAIN
+var ErrNoEvent = fmt.Errorf("no uevent available: %w", syscall.EAGAIN)
+
+// recvfrom is syscall.Recvfrom, overridden in tests
+var recvfrom = syscall.Recvfrom
+
 // Generic connection
 type NetlinkConn struct {
 	Fd   int                     // 소켓 File Descriptor
@@ -27,10 +90,57 @@ type NetlinkConn struct {
 }
 
 type UEventConn struct {
+	overflows    uint64 // number of overflow of the socket receive buffer (first field to be 64-bit aligned for atomic operations)
+	readDeadline int64  // deadline of ReadMsg in unix nanoseconds, 0 means no deadline (see: SetReadDeadline)
+
+	rateDropped, rateCoalesced uint64 // uevents dropped and coalesced by RateLimit
+	skippedEnvs                uint64 // malformed env entries skipped by LenientParsing
+	untrusted                  uint64 // msgs dropped because of their sender (see: TrustAllSenders)
+	closed                     int32  // 1 once Close is called, until the next successful Connect
+
 	NetlinkConn
 
 	// Options
-	MatchedUEventLimit int // allow to stop monitor mode after X event(s) matched by the matcher(해당 값 만큼 매칭이 일치하면, 모니터 모드를 종료.)
+	MatchedUEventLimit   int           // allow to stop monitor mode after X event(s) matched by the matcher(해당 값 만큼 매칭이 일치하면, 모니터 모드를 종료.)
+	MatchedUEventTimeout time.Duration // allow to stop monitor mode after a duration, whatever the number of matched events(해당 시간이 지나면, 모니터 모드를 종료.)
+	ReceiveBufferSize    int           // size in bytes of the socket receive buffer set on Connect if not zero, the kernel doubles this value (see: man 7 socket)
+	ReconnectMaxBackoff  time.Duration // max delay between reconnection attempts of MonitorWithReconnect (default: 30s)
+	Actions              []KObjAction  // allow to drop uevents with other actions before evaluating the matcher (disabled if empty)
+	Dedup                time.Duration // allow to drop uevents identical to one delivered less than Dedup before, ignoring SEQNUM (disabled if zero)
+	NetNS                *NetNS        // network namespace to create the socket in on Connect (default: namespace of the process)
+	Metrics              Metrics       // allow to count received, matched, dropped uevents and errors (default: NopMetrics)
+	DetectSeqNumGap      bool          // allow Monitor to send a *SeqNumGapError on errs when SEQNUM of received uevents are not contiguous, udev events aren't checked with WithFilter
+	Logger               Logger        // allow to log internal diagnostics (default: NopLogger)
+	RateLimit            float64       // allow to pace the delivery of Monitor to X uevents per second, see RateLimited for the policy (disabled if zero)
+	RateBurst            int           // max uevents delivered at once by RateLimit (default: 1)
+	LenientParsing       bool          // allow to skip malformed env entries instead of dropping the whole uevent (see: ParseUEventLenient, SkippedEnvs)
+	InferSubsystem       bool          // allow Monitor to set SUBSYSTEM guessed from KObj when the env var is missing, before matching (see: DevPath.InferSubsystem)
+	Credentials          bool          // allow to capture the sender of each msg into UEvent.Sender, msgs are read with recvmsg and SO_PASSCRED
+	NormalizeEnv         bool          // allow to uppercase env keys and trim env values of uevents before matching (see: UEvent.Normalize)
+	UnknownActions       bool          // allow to deliver uevents with an action unknown by this library (UEvent.ActionKnown is false) instead of dropping them
+	TrackDevices         int           // allow Monitor to enrich remove uevents with the env of the last uevents of the device, for up to X devices (disabled if zero, see: DeviceTracker)
+	TrustAllSenders      bool          // allow msgs sent by any process, by default only the kernel and the udev daemon (root) are trusted (ie: to replay or inject uevents in tests)
+	ParseWorkers         int           // allow Monitor to parse and match msgs in X goroutines, uevents stay ordered per device only (disabled if lower than 2, see: Monitor)
+	CustomGroups         bool          // allow Connect to bind to any bitmask of netlink groups, not only KernelEvent and UdevEvent (see: Mode.ValidateGroups), msgs of other groups need TrustAllSenders
+
+	// Transform allow to rewrite uevents (ie: add a TENANT env var or strip secrets) after parsing, before
+	// they are tracked, matched and delivered by monitoring methods. The uevent is dropped when it return an error,
+	// which is reported on errs. It must be safe for concurrent use with ParseWorkers.
+	Transform func(*UEvent) error
+
+	closeMu    sync.Mutex     // serialize Close with Connect and the registration of monitoring loops
+	closing    chan struct{}  // closed by Close to stop monitoring loops
+	closeWaker *waker         // woken by Close to interrupt monitoring loops waiting on Fd
+	loops      sync.WaitGroup // monitoring loops using Fd, Close waits for them before closing Fd
+	fdMu       sync.RWMutex   // protect Fd and filter replaced by connect while MonitorWithReconnect is running
+	pipelineMu sync.Mutex     // protect the stateful steps of filterMsg (onMsg, tracker, seqNums, dedup) used by ParseWorkers
+	seqNums    SeqNumChecker
+	dedup      *dedupCache
+	tracker    *DeviceTracker
+	filter     []string               // subsystems of the socket filter (see: WithFilter)
+	stopReason int32                  // StopReason of the last monitoring
+	onMsg      func(msg []byte) error // called with each raw msg read by Monitor (ie: Recorder), msg mustn't be retained
+	buffers    sync.Pool              // of *[]byte, reused to read msgs
 }
 
 // Connect allow to connect to system socket AF_NETLINK with family NETLINK_KOBJECT_UEVENT to
@@ -38,154 +148,1089 @@ type UEventConn struct {
 // see:
 // - http://elixir.free-electrons.com/linux/v3.12/source/include/uapi/linux/netlink.h#L23
 // - http://elixir.free-electrons.com/linux/v3.12/source/include/uapi/linux/socket.h#L11
+// Use KernelEvent|UdevEvent (or AllEvents) as mode to subscribe to both groups at once.
+// With CustomGroups, mode could be any bitmask of netlink groups, it's applied verbatim to Addr.Groups.
 func (c *UEventConn) Connect(mode Mode) (err error) {
+	if err = c.connect(mode); err != nil {
+		return
+	}
+
+	closeWaker, err := newWaker()
+	if err != nil {
+		syscall.Close(c.fd())
+		return fmt.Errorf("Unable to create waker, err: %w", err)
+	}
+
+	c.closeMu.Lock()
+	c.closing = make(chan struct{})
+	c.closeWaker = closeWaker
+	atomic.StoreInt32(&c.closed, 0)
+	c.closeMu.Unlock()
+	return
+}
+
+// connect create the socket of Connect, without resetting the state of Close (ie: to reconnect a running monitoring)
+func (c *UEventConn) connect(mode Mode) (err error) {
+	c.fdMu.Lock()
+	defer c.fdMu.Unlock()
+
+	if err = c.validateMode(mode); err != nil {
+		return
+	}
+
+	if err = c.validateActions(); err != nil {
+		return
+	}
 
 	// AF_NETLINK : 커널 사용자 인터페이스 장치 / SOCK_RAW : 가공하지 않은 소켓 / NETLINK_KOBJECT_UEVENT : uevent를 Listen하기 위한 프로토콜
-	if c.Fd, err = syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_KOBJECT_UEVENT); err != nil {
+	socket := func() error {
+		return ignoringEINTR(func() (err error) {
+			c.Fd, err = syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_KOBJECT_UEVENT)
+			return
+		})
+	}
+	if c.NetNS != nil {
+		err = withNetNS(c.NetNS, socket)
+	} else {
+		err = socket()
+	}
+	if err != nil {
+		c.Fd = -1
+		err = privilegesError("create", err)
 		return
 	}
+	// Fd is reset on failure, so Close and IsConnected never use a closed fd (it could be reused by another file)
+	defer func() {
+		if err != nil {
+			syscall.Close(c.Fd)
+			c.Fd = -1
+		}
+	}()
 
 	c.Addr = syscall.SockaddrNetlink{
 		Family: syscall.AF_NETLINK,
 		Groups: uint32(mode), // mode : netlink.UdevEvent(Udev 이벤트)
 	}
 
-	if err = syscall.Bind(c.Fd, &c.Addr); err != nil {
-		syscall.Close(c.Fd)
+	if c.ReceiveBufferSize > 0 {
+		if err = c.setReceiveBufferSize(c.ReceiveBufferSize); err != nil {
+			return
+		}
+	}
+
+	if err = ignoringEINTR(func() error { return syscall.Bind(c.Fd, &c.Addr) }); err != nil {
+		err = privilegesError("bind", err)
+		return
+	}
+
+	if c.Credentials || c.checkSenders() {
+		if err = setPassCred(c.Fd); err != nil {
+			return
+		}
 	}
 
+	if len(c.filter) > 0 {
+		if err = attachSubsystemFilter(c.Fd, c.filter); err != nil {
+			return
+		}
+	}
 	return
 }
 
-// Close allow to close file descriptor and socket bound
+// IsPermissionError return true if err is caused by a lack of privileges (EPERM or EACCES),
+// ie: Connect run by an unprivileged user without CAP_NET_ADMIN
+func IsPermissionError(err error) bool {
+	return errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES)
+}
+
+// privilegesError wrap a permission error of the socket operation op to explain that privileges are required,
+// other errors are returned unchanged
+func privilegesError(op string, err error) error {
+	if !IsPermissionError(err) {
+		return err
+	}
+	return fmt.Errorf("Unable to %s netlink socket, run as root or grant CAP_NET_ADMIN (ie: setcap cap_net_admin+ep <binary>), err: %w", op, err)
+}
+
+// validateMode check mode according to CustomGroups, a warning is logged for groups unused by the kernel and udevd
+func (c *UEventConn) validateMode(mode Mode) error {
+	if !c.CustomGroups {
+		return mode.Validate()
+	}
+	if err := mode.ValidateGroups(); err != nil {
+		return err
+	}
+	if mode&^AllEvents != 0 {
+		c.logger().Printf("netlink: binding to custom groups %#x, uevents are only sent to groups 1 (kernel) and 2 (udev)", uint32(mode))
+	}
+	return nil
+}
+
+// setReceiveBufferSize set SO_RCVBUF, the value is capped by net.core.rmem_max so
+// SO_RCVBUFFORCE is used as fallback to exceed this limit (only allowed for privileged process)
+func (c *UEventConn) setReceiveBufferSize(size int) error {
+	if err := syscall.SetsockoptInt(c.Fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, size); err != nil {
+		return fmt.Errorf("Unable to set socket receive buffer size, err: %w", err)
+	}
+
+	// The kernel doubles the value to allow space for bookkeeping overhead
+	if actual, err := syscall.GetsockoptInt(c.Fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF); err == nil && actual >= 2*size {
+		return nil
+	}
+
+	// Ignore error: unprivileged process keeps the capped value
+	syscall.SetsockoptInt(c.Fd, syscall.SOL_SOCKET, syscall.SO_RCVBUFFORCE, size)
+	return nil
+}
+
+// GetReceiveBufferSize return the actual size of the socket receive buffer
+// Note: the value is doubled by the kernel compared to the requested ReceiveBufferSize
+func (c *UEventConn) GetReceiveBufferSize() (int, error) {
+	c.fdMu.RLock()
+	defer c.fdMu.RUnlock()
+	return syscall.GetsockoptInt(c.Fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+}
+
+// ignoringEINTR call fn again while it fails with EINTR (ie: syscall interrupted by a signal)
+func ignoringEINTR(fn func() error) error {
+	for {
+		if err := fn(); err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// Close allow to close file descriptor and socket bound.
+// It's idempotent and safe to call from many goroutines: next calls return nil without closing Fd again
+// (it could be reused by another file). Running monitorings (Monitor, MonitorContext, MonitorCallback...)
+// are stopped with StopClosed and Close waits for them to exit before closing Fd. Blocking reads
+// (ReadMsg, ReadUEvent, Events...) aren't interrupted, they must be stopped before Close (ie: with SetReadDeadline).
 func (c *UEventConn) Close() error {
-	return syscall.Close(c.Fd)
+	c.closeMu.Lock()
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		c.closeMu.Unlock()
+		return nil
+	}
+	if c.closing != nil {
+		close(c.closing)
+	}
+	if c.closeWaker != nil {
+		c.closeWaker.Wake()
+	}
+	c.closeMu.Unlock()
+
+	c.loops.Wait()
+	if c.closeWaker != nil {
+		c.closeWaker.Close()
+		c.closeWaker = nil
+	}
+	fd := c.fd()
+	if fd < 0 {
+		return nil // socket already closed by a failed reconnection
+	}
+	return syscall.Close(fd)
+}
+
+// fd return Fd, which could be replaced concurrently by MonitorWithReconnect
+func (c *UEventConn) fd() int {
+	c.fdMu.RLock()
+	defer c.fdMu.RUnlock()
+	return c.Fd
+}
+
+// acquireFd register a monitoring loop using Fd, so Close waits for it (see: releaseFd) before closing Fd.
+// It return the channel closed by Close, or false if the conn is already closed.
+func (c *UEventConn) acquireFd() (<-chan struct{}, bool) {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return nil, false
+	}
+	if c.closing == nil {
+		c.closing = make(chan struct{}) // conn not created by Connect, ie: Fd set by the caller
+	}
+	c.loops.Add(1)
+	return c.closing, true
+}
+
+// releaseFd unregister a monitoring loop registered by acquireFd
+func (c *UEventConn) releaseFd() {
+	c.loops.Done()
+}
+
+// closeWakeFd return the fd readable once Close is called, to interrupt waitReadable (-1 if none).
+// It must only be called by a monitoring loop registered by acquireFd.
+func (c *UEventConn) closeWakeFd() int {
+	if c.closeWaker == nil {
+		return -1
+	}
+	return c.closeWaker.r
+}
+
+// IsConnected return true if Fd is still an open uevent netlink socket, ie: to decide to reconnect after errors.
+// It doesn't read any msg nor clear the pending error of the socket (ie: an overflow reported by the next read).
+// It's safe to call while MonitorWithReconnect replaces the socket.
+func (c *UEventConn) IsConnected() bool {
+	c.fdMu.RLock()
+	defer c.fdMu.RUnlock()
+	return c.isConnected()
+}
+
+// isConnected is IsConnected, fdMu must be held
+func (c *UEventConn) isConnected() bool {
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return false
+	}
+	domain, err := syscall.GetsockoptInt(c.Fd, syscall.SOL_SOCKET, syscall.SO_DOMAIN)
+	if err != nil || domain != syscall.AF_NETLINK {
+		return false
+	}
+	protocol, err := syscall.GetsockoptInt(c.Fd, syscall.SOL_SOCKET, syscall.SO_PROTOCOL)
+	return err == nil && protocol == syscall.NETLINK_KOBJECT_UEVENT
 }
 
 // 데이터를 수신하는 부분
-func (c *UEventConn) msgPeek() (int, *[]byte, error) {
+// msgPeek grow buf until the next msg fits in it and return the size of the msg.
+// Each uevent is sent in a single datagram (kernel uevents are at most 2048 bytes, see UEVENT_BUFFER_SIZE,
+// libudev ones are bigger), so there is nothing to reassemble: the max supported size is only bounded by
+// the socket receive buffer (see: ReceiveBufferSize). MSG_TRUNC makes the kernel return the real size of
+// the datagram even if buf is too small, so buf is grown at once instead of page by page.
+// flags are added to the flags of recvfrom, ie: MSG_DONTWAIT to not wait for a msg.
+func (c *UEventConn) msgPeek(buf *[]byte, flags int) (int, error) {
 	var n int
 	var err error
-	buf := make([]byte, os.Getpagesize())
+	*buf = (*buf)[:cap(*buf)]
 	for {
 		// Just read how many bytes are available in the socket
 		// Warning: syscall.MSG_PEEK is a blocking call
 		// MSG_PEEK : 데이터가 읽혀지더라도 입력 버퍼에서 데이터가 지워지지 않음(입력버퍼에 수신된 데이터의 존재 유무 확인을 위한 옵션)
-		if n, _, err = syscall.Recvfrom(c.Fd, buf, syscall.MSG_PEEK); err != nil {
-			return n, &buf, err
+		err = ignoringEINTR(func() (err error) {
+			n, _, err = recvfrom(c.Fd, *buf, syscall.MSG_PEEK|syscall.MSG_TRUNC|flags)
+			return
+		})
+		if err != nil {
+			return n, err
 		}
 
 		// 모든 메시지를 버퍼 안에 저장할 수 있는 경우: break
-		if n < len(buf) {
+		if n < len(*buf) {
 			break
 		}
 
 		// 충분하지 않은 경우 버퍼 크기를 늘림.
-		buf = make([]byte, len(buf)+os.Getpagesize())
+		// n is the real size when the msg is truncated, otherwise (n == len) grow by a page
+		*buf = make([]byte, max(n, len(*buf))+os.Getpagesize())
+	}
+	return n, err
+}
+
+// getBuffer return a buffer of at least one page from the pool
+func (c *UEventConn) getBuffer() *[]byte {
+	if buf, ok := c.buffers.Get().(*[]byte); ok {
+		return buf
+	}
+	buf := make([]byte, os.Getpagesize())
+	return &buf
+}
+
+// putBuffer give back buf to the pool once its content isn't referenced anymore
+func (c *UEventConn) putBuffer(buf *[]byte) {
+	if cap(*buf) > maxPooledBufferSize {
+		return // don't keep memory of an exceptionally big msg
 	}
-	return n, &buf, err
+	c.buffers.Put(buf)
 }
 
-func (c *UEventConn) msgRead(buf *[]byte) error {
+// msgRead read the next msg into buf, the sender is returned only with the Credentials option or
+// when senders are checked (see: TrustAllSenders)
+func (c *UEventConn) msgRead(buf *[]byte) (*Sender, error) {
 	if buf == nil {
-		return errors.New("empty buffer")
+		return nil, errors.New("empty buffer")
 	}
 
-	n, _, err := syscall.Recvfrom(c.Fd, *buf, 0)
+	var (
+		n      int
+		sender *Sender
+	)
+	err := ignoringEINTR(func() (err error) {
+		if !c.Credentials && !c.checkSenders() {
+			n, err = syscall.Read(c.Fd, *buf) // like Recvfrom without allocating the sender address
+			return
+		}
+
+		oob := make([]byte, credentialsOOBSize)
+		var (
+			oobn int
+			from syscall.Sockaddr
+		)
+		if n, oobn, _, from, err = syscall.Recvmsg(c.Fd, *buf, oob, 0); err == nil {
+			sender = parseSender(from, oob[:oobn])
+		}
+		return
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// The datagram fills buf: it could be truncated if it changed since msgPeek (ie: another reader of the socket)
+	if n == len(*buf) {
+		return nil, fmt.Errorf("Truncated uevent msg (size >= %d bytes)", n)
 	}
 
 	// Extract only real data from buffer and return that
 	*buf = (*buf)[:n]
 
+	return sender, nil
+}
+
+// isOverflow return true and increase overflow counter if err reports an overflow of the socket receive buffer
+func (c *UEventConn) isOverflow(err error) bool {
+	if !errors.Is(err, syscall.ENOBUFS) {
+		return false
+	}
+	overflows := atomic.AddUint64(&c.overflows, 1)
+	c.metrics().IncError()
+	c.logger().Printf("netlink: socket receive buffer overflowed, uevents were lost (overflows: %d)", overflows)
+	return true
+}
+
+// readError count a fatal read error and return it with context
+func (c *UEventConn) readError(err error) error {
+	c.metrics().IncError()
+	c.logger().Printf("netlink: unable to read uevent: %v", err)
+	return fmt.Errorf("Unable to read uevent, err: %w", err)
+}
+
+// SkippedEnvs return how many malformed env entries were skipped with LenientParsing
+func (c *UEventConn) SkippedEnvs() uint64 {
+	return atomic.LoadUint64(&c.skippedEnvs)
+}
+
+// parse parse msg with the parsing options of the connection (see: LenientParsing, UnknownActions, NormalizeEnv),
+// skipped env entries are counted and logged
+func (c *UEventConn) parse(msg []byte) (*UEvent, error) {
+	uevent, skipped, err := ParseOptions{Lenient: c.LenientParsing, UnknownActions: c.UnknownActions, Normalize: c.NormalizeEnv}.Parse(msg)
+	if skipped > 0 {
+		atomic.AddUint64(&c.skippedEnvs, uint64(skipped))
+		c.logger().Printf("netlink: %d malformed env entries skipped in uevent %s@%s", skipped, uevent.Action, uevent.KObj)
+	}
+	return uevent, err
+}
+
+// Overflows return how many times the socket receive buffer overflowed (ie: uevents dropped by the kernel)
+func (c *UEventConn) Overflows() uint64 {
+	return atomic.LoadUint64(&c.overflows)
+}
+
+// SetReadDeadline allow to bound the blocking calls of ReadMsg and ReadUEvent, once t is reached they
+// return os.ErrDeadlineExceeded which implements net.Error with Timeout() == true.
+// A zero value for t means no deadline. The deadline only applies to reads started after the call,
+// Monitor methods aren't affected.
+func (c *UEventConn) SetReadDeadline(t time.Time) error {
+	var deadline int64
+	if !t.IsZero() {
+		deadline = t.UnixNano()
+	}
+	atomic.StoreInt64(&c.readDeadline, deadline)
 	return nil
 }
 
 // ReadMsg allow to read an entire uevent msg
 func (c *UEventConn) ReadMsg() (msg []byte, err error) {
-	// Just read how many bytes are available in the socket
-	_, buf, err := c.msgPeek()
-	if err != nil {
+	if err := c.waitDeadline(); err != nil {
+		return nil, err
+	}
+
+	var sender *Sender
+	if msg, sender, err = c.readMsg(); err != nil {
+		return nil, err
+	}
+	if err = c.checkSender(sender, msg); err != nil {
 		return nil, err
 	}
+	return msg, nil
+}
+
+// waitDeadline wait for an available msg until the deadline set by SetReadDeadline, if any
+func (c *UEventConn) waitDeadline() error {
+	deadline := atomic.LoadInt64(&c.readDeadline)
+	if deadline == 0 {
+		return nil
+	}
+
+	timeout := time.Until(time.Unix(0, deadline))
+	if timeout <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+
+	readable, err := waitReadable(c.Fd, -1, timeout)
+	if err != nil {
+		return err
+	}
+	if !readable {
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+// readMsg is like ReadMsg without deadline, the msg is copied out of a pooled buffer
+func (c *UEventConn) readMsg() ([]byte, *Sender, error) {
+	buf, sender, err := c.readPooledMsg()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer c.putBuffer(buf)
+
+	return append([]byte(nil), *buf...), sender, nil
+}
+
+// readPooledMsg read the next msg into a buffer of the pool, which must be given back with putBuffer.
+// The sender is nil unless it is captured (see: msgRead), it isn't checked yet.
+func (c *UEventConn) readPooledMsg() (*[]byte, *Sender, error) {
+	return c.readPooledMsgFlags(0)
+}
+
+// readPooledMsgFlags is like readPooledMsg with additional flags to wait for the msg (see: msgPeek)
+func (c *UEventConn) readPooledMsgFlags(peekFlags int) (*[]byte, *Sender, error) {
+	buf := c.getBuffer()
+
+	// Just read how many bytes are available in the socket
+	if _, err := c.msgPeek(buf, peekFlags); err != nil {
+		c.putBuffer(buf)
+		return nil, nil, err
+	}
 
 	// Now read complete data
-	err = c.msgRead(buf)
+	sender, err := c.msgRead(buf)
+	if err != nil {
+		c.putBuffer(buf)
+		return nil, nil, err
+	}
+	return buf, sender, nil
+}
 
-	return *buf, err
+// readUEvent read the next msg and handle it (see: handleMsg), the buffer is reused by next reads
+// because parsed uevents don't reference it.
+func (c *UEventConn) readUEvent(matcher Matcher, errs chan error) (*UEvent, error) {
+	buf, sender, err := c.readPooledMsg()
+	if err != nil {
+		return nil, err
+	}
+	defer c.putBuffer(buf)
+
+	return c.handleMsg(*buf, sender, matcher, errs), nil
 }
 
 // ReadMsg allow to read an entire uevent msg
 func (c *UEventConn) ReadUEvent() (*UEvent, error) {
-	msg, err := c.ReadMsg()
+	if err := c.waitDeadline(); err != nil {
+		return nil, err
+	}
+	return c.readParsedUEvent(0)
+}
+
+// PollUEvent is a non-blocking ReadUEvent: it return ErrNoEvent at once when no msg is available, so it could be
+// integrated into the poll loop of the caller (Fd is readable when a msg is available) without a goroutine.
+// As many msgs could be pending, it should be called in a loop until ErrNoEvent.
+// Like ReadUEvent, the matcher isn't applied and a msg which can't be parsed return an error (try again for the next one).
+func (c *UEventConn) PollUEvent() (*UEvent, error) {
+	uevent, err := c.readParsedUEvent(syscall.MSG_DONTWAIT)
+	if errors.Is(err, syscall.EAGAIN) {
+		return nil, ErrNoEvent
+	}
+	return uevent, err
+}
+
+// readParsedUEvent read and parse the next msg, peekFlags are passed to msgPeek
+func (c *UEventConn) readParsedUEvent(peekFlags int) (*UEvent, error) {
+	buf, sender, err := c.readPooledMsgFlags(peekFlags)
 	if err != nil {
 		return nil, err
 	}
+	defer c.putBuffer(buf)
+	receivedAt := time.Now()
+
+	if err := c.checkSender(sender, *buf); err != nil {
+		return nil, err
+	}
+
+	uevent, err := c.parse(*buf)
+	if err != nil {
+		return nil, err
+	}
+	uevent.ReceivedAt = receivedAt
+	if c.Credentials {
+		uevent.Sender = sender
+	}
+	return uevent, nil
+}
+
+// ReadUEventRaw is like ReadUEvent but it return the raw msg too, even if it can't be parsed (ie: to log or record it).
+// The returned msg is a copy which isn't reused by next reads.
+func (c *UEventConn) ReadUEventRaw() (*UEvent, []byte, error) {
+	if err := c.waitDeadline(); err != nil {
+		return nil, nil, err
+	}
+
+	msg, sender, err := c.readMsg()
+	if err != nil {
+		return nil, nil, err
+	}
+	receivedAt := time.Now()
+
+	if err := c.checkSender(sender, msg); err != nil {
+		return nil, msg, err
+	}
+
+	uevent, err := c.parse(msg)
+	if err != nil {
+		return nil, msg, err
+	}
+	uevent.ReceivedAt = receivedAt
+	if c.Credentials {
+		uevent.Sender = sender
+	}
+	return uevent, msg, nil
+}
+
+// validateActions check that all actions of the Actions option are known
+func (c *UEventConn) validateActions() error {
+	if err := NewActionMatcher(c.Actions...).Compile(); err != nil {
+		return fmt.Errorf("Wrong actions, err: %w", err)
+	}
+	return nil
+}
+
+// compile validate options and compile the matcher before monitoring
+func (c *UEventConn) compile(matcher Matcher) error {
+	if err := c.validateActions(); err != nil {
+		return err
+	}
+
+	if matcher != nil {
+		if err := matcher.Compile(); err != nil {
+			return fmt.Errorf("Wrong matcher, err: %w", err)
+		}
+	}
+	return nil
+}
+
+// report count and send a non-fatal error on errs, errors are ignored when errs is nil
+func (c *UEventConn) report(errs chan error, err error) {
+	c.metrics().IncError()
+	c.logger().Printf("netlink: %v", err)
+	if errs != nil {
+		errs <- err
+	}
+}
+
+// reportAndClose send err on errs then close it without blocking the caller, as errs may not be read
+// until Monitor returns (ie: a wrong matcher reported before any worker is started)
+func reportAndClose(errs chan error, err error) {
+	go func() {
+		errs <- err
+		close(errs)
+	}()
+}
+
+// handleMsg parse msg and apply the matcher, it return nil if the uevent must be dropped.
+// Non-fatal errors are sent on errs.
+func (c *UEventConn) handleMsg(msg []byte, sender *Sender, matcher Matcher, errs chan error) *UEvent {
+	metrics := c.metrics()
+	metrics.IncReceived()
+
+	uevent := c.filterMsg(msg, sender, matcher, errs)
+	if uevent == nil {
+		metrics.IncDropped()
+	} else {
+		metrics.IncMatched()
+	}
+	return uevent
+}
+
+// filterMsg is the pipeline of handleMsg without metrics
+func (c *UEventConn) filterMsg(msg []byte, sender *Sender, matcher Matcher, errs chan error) *UEvent {
+	receivedAt := time.Now() // msg was just read
+
+	if c.checkSender(sender, msg) != nil {
+		return nil // spoofed uevent, counted by Untrusted
+	}
+
+	c.pipelineMu.Lock()
+	var err error
+	if c.onMsg != nil {
+		err = c.onMsg(msg)
+	}
+	c.pipelineMu.Unlock()
+	if err != nil {
+		c.report(errs, err)
+	}
+
+	uevent, err := c.parse(msg)
+	if err != nil {
+		c.report(errs, fmt.Errorf("Unable to parse uevent, err: %w", err))
+		return nil // Drop uevent if not known
+	}
+	uevent.ReceivedAt = receivedAt
+	if c.Credentials {
+		uevent.Sender = sender
+	}
+
+	if c.InferSubsystem {
+		uevent.BackfillSubsystem()
+	}
+
+	if c.Transform != nil {
+		if err := c.Transform(uevent); err != nil {
+			c.report(errs, fmt.Errorf("Uevent %s@%s dropped by Transform, err: %w", uevent.Action, uevent.KObj, err))
+			return nil
+		}
+	}
+
+	// Before filters, to remember devices whatever the matcher and to match remove uevents on enriched env
+	if c.TrackDevices > 0 {
+		c.pipelineMu.Lock()
+		if c.tracker == nil || c.tracker.size != c.TrackDevices {
+			c.tracker = NewDeviceTracker(c.TrackDevices)
+		}
+		c.tracker.Track(uevent)
+		c.pipelineMu.Unlock()
+	}
+
+	// Udev events of other subsystems are dropped by the socket filter, their SEQNUM would be seen as a gap
+	if c.DetectSeqNumGap && !(uevent.Source == UdevEvent && c.filtered()) {
+		c.pipelineMu.Lock()
+		err := c.seqNums.Check(*uevent)
+		c.pipelineMu.Unlock()
+		if err != nil {
+			c.report(errs, err) // only a warning, uevent is still delivered
+		}
+	}
+
+	// Cheap filter on actions before the matcher
+	if len(c.Actions) > 0 && !(ActionMatcher{Actions: c.Actions}).EvaluateAction(uevent.Action) {
+		return nil
+	}
+
+	// 정의한 Rule 파일이 있고, 정의한 Rule과 일치하는지
+	if matcher != nil && !matcher.Evaluate(*uevent) {
+		return nil // Drop uevent if not match(다르면, 해당 Uevent를 Skip / 출력하지 않음)
+	}
 
-	return ParseUEvent(msg)
+	if c.Dedup > 0 {
+		c.pipelineMu.Lock()
+		if c.dedup == nil || c.dedup.window != c.Dedup {
+			c.dedup = newDedupCache(c.Dedup)
+		}
+		duplicate := c.dedup.duplicate(*uevent, uevent.ReceivedAt)
+		c.pipelineMu.Unlock()
+		if duplicate {
+			return nil
+		}
+	}
+
+	return uevent
 }
 
 // Monitor run in background a worker to read netlink msg in loop and notify
 // when msg receive inside a queue using channel.
 // To be notified with only relevant message, use Matcher.
+// Use StopReason to know why the worker exited.
+// Monitor owns queue and errs: both are closed when the worker exit (on quit, limits or fatal error),
+// so they mustn't be shared with another producer and consumers could range over them.
+// A wrong matcher doesn't block the caller: queue is closed and the error is sent on errs in background.
+// With ParseWorkers, msgs are parsed and matched by a pool of goroutines: uevents of a device (same DEVPATH)
+// are delivered in order, but uevents of different devices could be delivered in any order. In this case,
+// Metrics, Logger and the matcher must be safe for concurrent use, RateLimit and DetectSeqNumGap aren't supported.
 // 모니터링을 진행하는 부분
 func (c *UEventConn) Monitor(queue chan UEvent, errs chan error, matcher Matcher) chan struct{} {
 	quit := make(chan struct{}, 1)
+	c.setStopReason(StopNone)
 
 	// 정의한 Rule 파일이 있으면, 비교를 위해 Rule파일에있는 값을 정규표현식 Compile 함.
-	if matcher != nil {
-		if err := matcher.Compile(); err != nil {
-			errs <- fmt.Errorf("Wrong matcher, err: %w", err)
-			quit <- struct{}{}
-			close(queue)
-			return quit
-		}
+	if err := c.compileMonitor(matcher); err != nil {
+		c.setStopReason(StopError)
+		quit <- struct{}{}
+		close(queue)
+		reportAndClose(errs, err)
+		return quit
+	}
+	closing, ok := c.acquireFd()
+	if !ok {
+		c.setStopReason(StopClosed)
+		quit <- struct{}{}
+		close(queue)
+		reportAndClose(errs, ErrConnClosed)
+		return quit
 	}
 	// Main
 	go func() {
-		bufToRead := make(chan *[]byte, 1) // 정보를 저장하기 위한 Byte Array 채널 생성
-		count := 0                         // 매칭 Count를 위한 값
-	loop:
-		for {
+		defer close(errs)
+		defer close(queue)
+
+		reason, err := c.monitorLoop(quit, closing, queue, errs, matcher, c.newLimits())
+		c.setOnMsg(nil) // the hook of Recorder.Monitor only lasts one run
+		c.releaseFd()
+		c.setStopReason(reason)
+		if err != nil {
+			errs <- err
+		}
+	}()
+	return quit
+}
+
+// setOnMsg set the hook called with each raw msg read by monitoring loops, it return false if another hook is
+// already set (ie: the conn is already recorded). A nil hook always remove the current one.
+func (c *UEventConn) setOnMsg(onMsg func(msg []byte) error) bool {
+	c.pipelineMu.Lock()
+	defer c.pipelineMu.Unlock()
+
+	if onMsg != nil && c.onMsg != nil {
+		return false
+	}
+	c.onMsg = onMsg
+	return true
+}
+
+// monitorLoop read netlink msg in loop and send uevents matched by the compiled matcher on queue,
+// until quit, closing or limits are reached. Non-fatal errors are sent on errs and the fatal one is returned.
+// The caller must have registered the loop with acquireFd.
+func (c *UEventConn) monitorLoop(quit chan struct{}, closing <-chan struct{}, queue chan UEvent, errs chan error, matcher Matcher, limits *limits) (StopReason, error) {
+	if c.ParseWorkers > 1 {
+		return c.monitorLoopParallel(quit, closing, queue, errs, matcher, limits)
+	}
+	rl := c.newRateLimiter()
+
+	// deliver send the uevent on queue, it return false if the monitoring must stop with reason
+	deliver := func(uevent UEvent) (StopReason, bool) {
+		// 받은 Raw 데이터를 최종적으로 파싱한 출력 데이터를 queue에 전송
+		select {
+		case queue <- uevent:
+		case <-quit:
+			return StopQuit, false
+		case <-closing:
+			return StopClosed, false
+		}
+		// 매칭 임계값을 설정해 놓았고, 그 이상으로 탐지가 되었다면 종료.
+		if limits.matched() {
+			return StopLimit, false // stop iteration when reach limit of uevent
+		}
+		return StopNone, true
+	}
+
+	for {
+		select {
+		case <-quit:
+			return StopQuit, nil // stop iteration in case of stop signal received
+		case <-closing:
+			return StopClosed, nil
+		default:
+		}
+
+		// Deliver uevents pending because of RateLimit
+		for uevent, ok := rl.next(time.Now()); ok; uevent, ok = rl.next(time.Now()) {
+			if reason, ok := deliver(uevent); !ok {
+				return reason, nil
+			}
+		}
+
+		timeout, expired := limits.wait(monitorPollTimeout)
+		if expired {
+			return StopTimeout, nil // stop iteration when reach timeout
+		}
+
+		// Wait for available uevent without blocking forever, so quit is honored on idle socket
+		ready, err := waitReadable(c.Fd, c.closeWakeFd(), rl.wait(timeout, time.Now()))
+		if err != nil {
+			return StopError, fmt.Errorf("Unable to check available uevent, err: %w", err)
+		}
+		if !ready {
+			continue // timeout reached, check quit again
+		}
+
+		uevent, err := c.readUEvent(matcher, errs) // 데이터를 수신하고 출력에 맞게 Parsing함.(중요)
+		if c.isOverflow(err) {
+			errs <- ErrUEventOverflow
+			continue // kernel dropped uevents but the socket is still usable
+		}
+		if err != nil {
+			return StopError, c.readError(err)
+		}
+
+		if uevent == nil {
+			continue // Drop uevent if not known or not match
+		}
+
+		if !rl.add(*uevent, time.Now()) {
+			continue // pending, coalesced or dropped by RateLimit
+		}
+		if reason, ok := deliver(*uevent); !ok {
+			return reason, nil
+		}
+	}
+}
+
+// MonitorSubtree is like Monitor but only uevents of the device at devpath and of its descendants are sent
+// (see: NewSubtreeRule), ie: a disk and its partitions. The matcher, if not nil, applies on top of it.
+func (c *UEventConn) MonitorSubtree(queue chan UEvent, errs chan error, devpath string, matcher Matcher) chan struct{} {
+	subtree := NewSubtreeRule(devpath)
+	if matcher == nil {
+		return c.Monitor(queue, errs, &subtree)
+	}
+	return c.Monitor(queue, errs, AndMatcher{&subtree, matcher})
+}
+
+// MonitorContext run in background a worker like Monitor but the worker is stopped as soon
+// as ctx is done, even while waiting for a msg on the socket.
+// When ctx is done, ctx.Err() is sent once on errs. In any case, queue and errs are closed when the worker exit.
+func (c *UEventConn) MonitorContext(ctx context.Context, queue chan UEvent, errs chan error, matcher Matcher) {
+	c.setStopReason(StopNone)
+	closing, acquired := c.acquireFd()
+
+	go func() {
+		defer close(errs)
+		defer close(queue)
+
+		reason := StopError
+		defer func() {
+			c.setStopReason(reason)
+		}()
+
+		if !acquired {
+			reason = StopClosed
+			errs <- ErrConnClosed
+			return
+		}
+		defer c.releaseFd()
+
+		if err := c.compile(matcher); err != nil {
+			errs <- err
+			return
+		}
+
+		// Wake up the worker blocked on the socket when ctx is done or on Close
+		w, err := newWaker()
+		if err != nil {
+			errs <- fmt.Errorf("Unable to create waker, err: %w", err)
+			return
+		}
+		defer w.Close()
+
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
 			select {
-			case <-quit:
-				break loop // stop iteration in case of stop signal received
-			case buf := <-bufToRead: // Read one by one(데이터를 수신 받았을 때,)
-				err := c.msgRead(buf)
-				if err != nil {
-					errs <- fmt.Errorf("Unable to read uevent, err: %w", err)
-					break loop // stop iteration in case of error
-				}
+			case <-ctx.Done():
+				w.Wake()
+			case <-closing:
+				w.Wake()
+			case <-done:
+			}
+		}()
 
-				uevent, err := ParseUEvent(*buf) // 받은 데이터를 출력에 맞게 Parsing함.(중요)
-				if err != nil {
-					errs <- fmt.Errorf("Unable to parse uevent, err: %w", err)
-					continue loop // Drop uevent if not known
-				}
+		limits := c.newLimits()
+		rl := c.newRateLimiter()
 
-				// 정의한 Rule 파일이 있고,
-				if matcher != nil {
-					// 정의한 Rule과 일치하는지
-					if !matcher.Evaluate(*uevent) {
-						continue loop // Drop uevent if not match(다르면, 해당 Uevent를 Skip / 출력하지 않음)
-					}
-				}
-				queue <- *uevent // 받은 Raw 데이터를 최종적으로 파싱한 출력 데이터를 queue에 전송
-				count++
-				// 매칭 임계값을 설정해 놓았고, 그 이상으로 탐지가 되었다면 종료.
-				if c.MatchedUEventLimit > 0 && count >= c.MatchedUEventLimit {
-					break loop // stop iteration when reach limit of uevent
+		// deliver send the uevent on queue, it return false if the monitoring must stop
+		deliver := func(uevent UEvent) bool {
+			select {
+			case queue <- uevent:
+			case <-ctx.Done():
+				reason = StopQuit
+				errs <- ctx.Err()
+				return false
+			case <-closing:
+				reason = StopClosed
+				return false
+			}
+
+			if limits.matched() {
+				reason = StopLimit
+				return false // stop iteration when reach limit of uevent
+			}
+			return true
+		}
+
+		for {
+			// Deliver uevents pending because of RateLimit
+			for uevent, ok := rl.next(time.Now()); ok; uevent, ok = rl.next(time.Now()) {
+				if !deliver(uevent) {
+					return
 				}
+			}
+
+			timeout, expired := limits.wait(-1)
+			if expired {
+				reason = StopTimeout
+				return
+			}
+
+			ready, err := waitReadable(c.Fd, w.r, rl.wait(timeout, time.Now()))
+			if err != nil {
+				errs <- fmt.Errorf("Unable to wait for uevent, err: %w", err)
+				return
+			}
+			if ctx.Err() != nil {
+				reason = StopQuit
+				errs <- ctx.Err()
+				return
+			}
+			select {
+			case <-closing:
+				reason = StopClosed
+				return
 			default:
-				_, buf, err := c.msgPeek() // 데이터를 수신하는 부분
-				if err != nil {
-					errs <- fmt.Errorf("Unable to check available uevent, err: %w", err)
-					break loop // stop iteration in case of error
-				}
-				bufToRead <- buf // 데이터를 수신받아서, 파싱하기 위한 채널 데이터 전송. (case buf := <-bufToRead 로 이동.)
+			}
+			if !ready {
+				continue // timeout reached
+			}
+
+			uevent, err := c.readUEvent(matcher, errs)
+			if c.isOverflow(err) {
+				errs <- ErrUEventOverflow
+				continue
+			}
+			if err != nil {
+				errs <- c.readError(err)
+				return
+			}
+
+			if uevent == nil {
+				continue // Drop uevent if not known or not match
+			}
+
+			if !rl.add(*uevent, time.Now()) {
+				continue // pending, coalesced or dropped by RateLimit
+			}
+			if !deliver(*uevent) {
+				return
 			}
 		}
 	}()
-	return quit
+}
+
+// MonitorCallback run the read loop in the caller goroutine and call fn for each uevent matched by the matcher.
+// It blocks until fn return an error (returned as is), a read error occurs, MatchedUEventLimit
+// or MatchedUEventTimeout is reached (use StopReason to know which one).
+// Unparsable uevents and overflows of the receive buffer are skipped silently.
+// Note: no msg is read while fn is running, so fn shouldn't block too long otherwise the
+// socket receive buffer could overflow and uevents would be dropped by the kernel.
+func (c *UEventConn) MonitorCallback(matcher Matcher, fn func(UEvent) error) error {
+	reason := StopError
+	c.setStopReason(StopNone)
+	defer func() {
+		c.setStopReason(reason)
+	}()
+
+	if err := c.compile(matcher); err != nil {
+		return err
+	}
+
+	closing, acquired := c.acquireFd()
+	if !acquired {
+		reason = StopClosed
+		return ErrConnClosed
+	}
+	defer func() {
+		if acquired {
+			c.releaseFd()
+		}
+	}()
+
+	limits := c.newLimits()
+	for {
+		timeout, expired := limits.wait(-1)
+		if expired {
+			reason = StopTimeout
+			return nil
+		}
+		ready, err := waitReadable(c.Fd, c.closeWakeFd(), timeout)
+		select {
+		case <-closing:
+			reason = StopClosed
+			return nil
+		default:
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to wait for uevent, err: %w", err)
+		}
+		if !ready {
+			continue // timeout reached
+		}
+
+		uevent, err := c.readUEvent(matcher, nil)
+		if c.isOverflow(err) {
+			continue
+		}
+		if err != nil {
+			return c.readError(err)
+		}
+
+		if uevent == nil {
+			continue
+		}
+
+		// Fd isn't used while fn is running, so fn could call Close
+		c.releaseFd()
+		acquired = false
+		if err := fn(*uevent); err != nil {
+			reason = StopQuit
+			return err
+		}
+		if closing, acquired = c.acquireFd(); !acquired {
+			reason = StopClosed
+			return nil
+		}
+
+		if limits.matched() {
+			reason = StopLimit
+			return nil
+		}
+	}
+}
+
+// Events return an iterator over uevents matched by the matcher, ie:
+//
+//	for uevent, err := range conn.Events(matcher) { ... }
+//
+// Non-fatal errors (overflow, parsing...) are yielded with a zero UEvent and the iteration continue,
+// fatal ones are yielded before the end of the iteration. Breaking the loop stop reading the socket.
+// Use SetReadDeadline to bound the waiting of each uevent.
+func (c *UEventConn) Events(matcher Matcher) iter.Seq2[UEvent, error] {
+	return func(yield func(UEvent, error) bool) {
+		if err := c.compile(matcher); err != nil {
+			yield(UEvent{}, err)
+			return
+		}
+
+		warnings := make(chan error, 3) // handleMsg report at most one error per step
+		for {
+			err := c.waitDeadline()
+			var uevent *UEvent
+			if err == nil {
+				uevent, err = c.readUEvent(matcher, warnings)
+			}
+			if c.isOverflow(err) {
+				if !yield(UEvent{}, ErrUEventOverflow) {
+					return
+				}
+				continue
+			}
+			if err != nil {
+				yield(UEvent{}, c.readError(err))
+				return
+			}
+
+			for len(warnings) > 0 {
+				if !yield(UEvent{}, <-warnings) {
+					return
+				}
+			}
+
+			if uevent != nil && !yield(*uevent, nil) {
+				return
+			}
+		}
+	}
 }
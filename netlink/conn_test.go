@@ -1,19 +1,1167 @@
 package netlink
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestConnect(t *testing.T) {
-	conn := new(UEventConn)
+	conn := &UEventConn{TrustAllSenders: true}
 	if err := conn.Connect(UdevEvent); err != nil {
 		t.Fatal("unable to subscribe to netlink uevent, err:", err)
 	}
 	defer conn.Close()
 
-	conn2 := new(UEventConn)
+	conn2 := &UEventConn{TrustAllSenders: true}
 	if err := conn2.Connect(UdevEvent); err != nil {
 		t.Fatal("unable to subscribe to netlink uevent a second time, err:", err)
 	}
 	defer conn2.Close()
 }
+
+func TestMonitorContext(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := make(chan UEvent)
+	errs := make(chan error)
+	conn.MonitorContext(ctx, queue, errs, nil)
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Fatal("expecting context.Canceled, got:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("worker should report ctx.Err() once ctx is cancelled")
+	}
+
+	select {
+	case _, more := <-queue:
+		if more {
+			t.Fatal("queue should be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queue should be closed when the worker exit")
+	}
+}
+
+func TestMonitorQuitOnIdleSocket(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	// Nothing is sent on the socket, worker is waiting for uevent
+	quit := conn.Monitor(make(chan UEvent), make(chan error), nil)
+	time.Sleep(200 * time.Millisecond)
+	if !isGoroutineRunning("(*UEventConn).Monitor.func") {
+		t.Fatal("monitor worker should be running")
+	}
+	close(quit)
+
+	deadline := time.Now().Add(time.Second)
+	for isGoroutineRunning("(*UEventConn).Monitor.func") {
+		if time.Now().After(deadline) {
+			t.Fatal("monitor worker should exit within a second after quit")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// isGoroutineRunning return true if a goroutine stack contains fn
+func isGoroutineRunning(fn string) bool {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	return bytes.Contains(buf, []byte(fn))
+}
+
+func TestReceiveBufferSize(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true, ReceiveBufferSize: 4096}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	size, err := conn.GetReceiveBufferSize()
+	if err != nil {
+		t.Fatal("unable to get receive buffer size, err:", err)
+	}
+	if size != 2*conn.ReceiveBufferSize {
+		t.Fatalf("wrong receive buffer size (got: %d, wanted: %d)", size, 2*conn.ReceiveBufferSize)
+	}
+}
+
+func TestOverflowCounter(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+
+	if conn.isOverflow(nil) || conn.isOverflow(syscall.EAGAIN) {
+		t.Fatal("only ENOBUFS should be considered as overflow")
+	}
+
+	if !conn.isOverflow(syscall.ENOBUFS) || !conn.isOverflow(fmt.Errorf("wrapped: %w", syscall.ENOBUFS)) {
+		t.Fatal("ENOBUFS should be considered as overflow")
+	}
+
+	if conn.Overflows() != 2 {
+		t.Fatalf("wrong overflow counter (got: %d, wanted: 2)", conn.Overflows())
+	}
+}
+
+func TestConnectBothGroups(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(KernelEvent | UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to both netlink groups, err:", err)
+	}
+	defer conn.Close()
+
+	mode := Mode(conn.Addr.Groups)
+	if !mode.Has(KernelEvent) || !mode.Has(UdevEvent) {
+		t.Fatalf("both group bits should be set (got: %b)", conn.Addr.Groups)
+	}
+
+	if err := new(UEventConn).Connect(Mode(4)); err == nil {
+		t.Fatal("unknown group should be rejected")
+	}
+}
+
+func TestConnectCustomGroups(t *testing.T) {
+	logger := &captureLogger{}
+	conn := &UEventConn{CustomGroups: true, Logger: logger}
+	mask := KernelEvent | Mode(1<<4)
+	if err := conn.Connect(mask); err != nil {
+		t.Fatal("unable to bind to custom netlink groups, err:", err)
+	}
+	defer conn.Close()
+
+	if conn.Addr.Groups != uint32(mask) {
+		t.Fatalf("custom mask should be applied verbatim (got: %#x, wanted: %#x)", conn.Addr.Groups, uint32(mask))
+	}
+	if !logger.contains("custom groups 0x11") {
+		t.Fatalf("a warning should be logged for groups unused by uevents (got: %v)", logger.lines)
+	}
+
+	for _, mode := range []Mode{0, -1} {
+		if err := (&UEventConn{CustomGroups: true}).Connect(mode); err == nil {
+			t.Fatalf("wrong bitmask should be rejected (got: %d)", mode)
+		}
+	}
+}
+
+// sendMsg send raw msg in unicast to conn, it doesn't need privileges and doesn't disturb other listeners
+func sendMsg(t *testing.T, conn *UEventConn, raw []byte) {
+	t.Helper()
+
+	addr, err := syscall.Getsockname(conn.Fd)
+	if err != nil {
+		t.Fatal("unable to get netlink socket address, err:", err)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		t.Fatal("unable to open netlink socket, err:", err)
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.Sendto(fd, raw, 0, addr); err != nil {
+		t.Fatal("unable to send msg, err:", err)
+	}
+}
+
+func TestMonitorCallback(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	for _, kobj := range []string{"/devices/foo", "/devices/bar", "/devices/stop", "/devices/baz"} {
+		sendMsg(t, conn, UEvent{Action: ADD, KObj: kobj, Env: map[string]string{"ACTION": "add"}}.Bytes())
+	}
+
+	// Only uevents matched by the matcher are handled
+	add := "^add$"
+	matcher := &RuleDefinitions{Rules: []RuleDefinition{{Action: &add}}}
+	errStop := errors.New("stop")
+	var handled []string
+	err := conn.MonitorCallback(matcher, func(e UEvent) error {
+		handled = append(handled, e.KObj)
+		if e.KObj == "/devices/stop" {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatal("callback error should be returned as is, got:", err)
+	}
+	if len(handled) != 3 {
+		t.Fatalf("wrong handled uevents (got: %v)", handled)
+	}
+
+	// Limit stops the loop without error
+	conn.MatchedUEventLimit = 1
+	err = conn.MonitorCallback(nil, func(e UEvent) error {
+		if e.KObj != "/devices/baz" {
+			t.Fatal("wrong uevent (got:", e.KObj, ")")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal("limit should stop the loop without error, got:", err)
+	}
+}
+
+func TestIgnoringEINTR(t *testing.T) {
+	calls := 0
+	err := ignoringEINTR(func() error {
+		calls++
+		if calls == 1 {
+			return syscall.EINTR // interrupted once then succeed
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("syscall should be retried on EINTR (calls: %d, err: %v)", calls, err)
+	}
+
+	calls = 0
+	err = ignoringEINTR(func() error {
+		calls++
+		return syscall.EBADF
+	})
+	if err != syscall.EBADF || calls != 1 {
+		t.Fatalf("other errors shouldn't be retried (calls: %d, err: %v)", calls, err)
+	}
+}
+
+type countingMatcher struct {
+	Matcher
+	compiled int
+}
+
+func (m *countingMatcher) Compile() error {
+	m.compiled++
+	return m.Matcher.Compile()
+}
+
+func TestMonitorWithReconnect(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	fd := conn.Fd
+	matcher := &countingMatcher{Matcher: NewActionMatcher(ADD)}
+	queue := make(chan UEvent)
+	errs := make(chan error, 1)
+	quit := conn.MonitorWithReconnect(queue, errs, matcher)
+	defer func() {
+		close(quit)
+		waitStopReason(t, conn) // before closing the socket, its fd could be reused by next tests
+	}()
+
+	breakConn(t, fd)
+
+	select {
+	case err := <-errs:
+		var rerr *ReconnectError
+		if !errors.As(err, &rerr) {
+			t.Fatalf("Wrong error, got: %v, wanted a *ReconnectError", err)
+		}
+		if rerr.Err != nil || rerr.Attempt != 1 || !errors.Is(err, syscall.ENOTSOCK) {
+			t.Fatalf("Wrong reconnection, got: %+v", rerr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for reconnection")
+	}
+
+	sendMsg(t, conn, []byte("add@/devices/foo\x00ACTION=add\x00DEVPATH=/devices/foo\x00SUBSYSTEM=usb\x00SEQNUM=1\x00"))
+	select {
+	case uevent := <-queue:
+		if uevent.KObj != "/devices/foo" {
+			t.Fatalf("Wrong uevent, got: %s", uevent.KObj)
+		}
+	case err := <-errs:
+		t.Fatal("Unexpected error:", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for uevent after reconnection")
+	}
+
+	if matcher.compiled != 1 {
+		t.Fatalf("Matcher compiled %d times, wanted: 1", matcher.compiled)
+	}
+}
+
+// breakConn replace the socket of conn by a regular file, so reading fails with ENOTSOCK
+func breakConn(t *testing.T, fd int) {
+	t.Helper()
+
+	f, err := ioutil.TempFile(t.TempDir(), "notasocket")
+	if err != nil {
+		t.Fatal("unable to create temp file, err:", err)
+	}
+	defer f.Close()
+	if err := syscall.Dup3(int(f.Fd()), fd, 0); err != nil {
+		t.Fatal("unable to replace the socket, err:", err)
+	}
+}
+
+// TestMonitorWithReconnectIsConnected must be run with -race: Fd is replaced while it's read by IsConnected
+func TestMonitorWithReconnectIsConnected(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	fd := conn.Fd
+	errs := make(chan error, 1)
+	quit := conn.MonitorWithReconnect(make(chan UEvent), errs, nil)
+	defer func() {
+		close(quit)
+		waitStopReason(t, conn)
+	}()
+
+	stop := make(chan struct{})
+	polled := make(chan int)
+	go func() {
+		n := 0
+		for {
+			select {
+			case <-stop:
+				polled <- n
+				return
+			default:
+			}
+			conn.IsConnected()
+			conn.GetReceiveBufferSize()
+			n++
+		}
+	}()
+
+	breakConn(t, fd)
+	select {
+	case err := <-errs:
+		var rerr *ReconnectError
+		if !errors.As(err, &rerr) || rerr.Err != nil {
+			t.Fatalf("Wrong reconnection, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for reconnection")
+	}
+	close(stop)
+	if n := <-polled; n == 0 {
+		t.Fatal("IsConnected should be called during the reconnection")
+	}
+	if !conn.IsConnected() {
+		t.Fatal("conn should be connected again")
+	}
+}
+
+func TestMonitorWithReconnectBlockedErrs(t *testing.T) {
+	for name, stop := range map[string]func(*UEventConn, chan struct{}){
+		"quit":  func(_ *UEventConn, quit chan struct{}) { close(quit) },
+		"close": func(conn *UEventConn, _ chan struct{}) { conn.Close() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			conn := &UEventConn{TrustAllSenders: true}
+			if err := conn.Connect(UdevEvent); err != nil {
+				t.Fatal("unable to subscribe to netlink uevent, err:", err)
+			}
+			defer conn.Close()
+
+			// Nobody reads errs: the worker is blocked on the report of the reconnection
+			fd := conn.Fd
+			errs := make(chan error)
+			quit := conn.MonitorWithReconnect(make(chan UEvent), errs, nil)
+			breakConn(t, fd)
+			time.Sleep(2 * reconnectMinBackoff)
+
+			stop(conn, quit)
+			if reason := waitStopReason(t, conn); reason == StopError {
+				t.Fatal("Wrong stop reason, got:", reason)
+			}
+		})
+	}
+}
+
+func TestSetReadDeadline(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	// Deadline reached while waiting
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	start := time.Now()
+	_, err := conn.ReadUEvent()
+	var nerr net.Error
+	if !errors.As(err, &nerr) || !nerr.Timeout() {
+		t.Fatal("read should fail with a timeout error, got:", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Fatal("read should be interrupted by the deadline, elapsed:", elapsed)
+	}
+
+	// Available msg is read before the deadline
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	sendMsg(t, conn, UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+	if uevent, err := conn.ReadUEvent(); err != nil || uevent.KObj != "/devices/foo" {
+		t.Fatalf("read should succeed (got: %v, err: %v)", uevent, err)
+	}
+
+	// Past deadline fails immediately, even if a msg is available
+	conn.SetReadDeadline(time.Now().Add(-time.Second))
+	sendMsg(t, conn, UEvent{Action: ADD, KObj: "/devices/bar", Env: map[string]string{}}.Bytes())
+	if _, err := conn.ReadMsg(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("read should fail with past deadline, got:", err)
+	}
+
+	// Zero value disables the deadline
+	conn.SetReadDeadline(time.Time{})
+	if uevent, err := conn.ReadUEvent(); err != nil || uevent.KObj != "/devices/bar" {
+		t.Fatalf("read should succeed without deadline (got: %v, err: %v)", uevent, err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	sendMsg(t, conn, UEvent{Action: REMOVE, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+	sendMsg(t, conn, []byte("wrong uevent"))
+	sendMsg(t, conn, UEvent{Action: ADD, KObj: "/devices/bar", Env: map[string]string{}}.Bytes())
+	sendMsg(t, conn, UEvent{Action: ADD, KObj: "/devices/baz", Env: map[string]string{}}.Bytes())
+
+	var (
+		kObjs []string
+		errs  []error
+	)
+	for uevent, err := range conn.Events(NewActionMatcher(ADD)) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		kObjs = append(kObjs, uevent.KObj)
+		if len(kObjs) == 2 {
+			break
+		}
+	}
+
+	if len(errs) != 1 {
+		t.Fatalf("wrong errors, got: %v", errs)
+	}
+	if fmt.Sprint(kObjs) != "[/devices/bar /devices/baz]" {
+		t.Fatalf("wrong uevents, got: %v", kObjs)
+	}
+
+	// Fatal error ends the iteration
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	n := 0
+	for _, err := range conn.Events(nil) {
+		n++
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatal("wrong error, got:", err)
+		}
+	}
+	if n != 1 {
+		t.Fatal("iteration should stop after fatal error, got yields:", n)
+	}
+
+	// Wrong matcher
+	wrong := "("
+	for _, err := range conn.Events(&RuleDefinitions{Rules: []RuleDefinition{{Action: &wrong}}}) {
+		if err == nil {
+			t.Fatal("wrong matcher should be yielded as error")
+		}
+	}
+}
+
+func TestActionsOption(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true, Actions: []KObjAction{"plug"}}
+	if err := conn.Connect(UdevEvent); err == nil {
+		conn.Close()
+		t.Fatal("connect should fail with unknown action")
+	}
+
+	conn.Actions = []KObjAction{ADD, REMOVE}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	sendMsg(t, conn, UEvent{Action: CHANGE, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+	sendMsg(t, conn, UEvent{Action: REMOVE, KObj: "/devices/bar", Env: map[string]string{}}.Bytes())
+	for uevent, err := range conn.Events(nil) {
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if uevent.Action != REMOVE {
+			t.Fatal("uevent should be dropped by actions, got:", uevent.Action)
+		}
+		break
+	}
+
+	// Actions are validated on monitoring start too
+	conn.Actions = []KObjAction{"plug"}
+	queue := make(chan UEvent)
+	errs := make(chan error, 1)
+	conn.Monitor(queue, errs, nil)
+	if err := <-errs; err == nil {
+		t.Fatal("monitor should fail with unknown action")
+	}
+	if _, ok := <-queue; ok {
+		t.Fatal("queue should be closed")
+	}
+}
+
+func TestReadUEventRaw(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	valid := []byte("add@/devices/foo\x00ACTION=add\x00")
+	sendMsg(t, conn, valid)
+	uevent, raw, err := conn.ReadUEventRaw()
+	if err != nil || uevent == nil || !bytes.Equal(raw, valid) {
+		t.Fatalf("wrong raw uevent (got: %q, err: %v)", raw, err)
+	}
+	if &raw[0] == &uevent.Raw[0] {
+		t.Fatal("raw msg and UEvent.Raw shouldn't alias each other")
+	}
+
+	// Raw msg is returned even if it can't be parsed
+	sendMsg(t, conn, []byte("wrong uevent"))
+	uevent, raw, err = conn.ReadUEventRaw()
+	if err == nil || uevent != nil || string(raw) != "wrong uevent" {
+		t.Fatalf("unparsable msg should be returned with an error (got: %q, err: %v)", raw, err)
+	}
+}
+
+func BenchmarkReadUEvent(b *testing.B) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		b.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	addr, err := syscall.Getsockname(conn.Fd)
+	if err != nil {
+		b.Fatal("unable to get netlink socket address, err:", err)
+	}
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		b.Fatal("unable to open netlink socket, err:", err)
+	}
+	defer syscall.Close(fd)
+
+	raw := []byte("change@/devices/virtual/misc/autofs\x00ACTION=change\x00DEVPATH=/devices/virtual/misc/autofs\x00SUBSYSTEM=misc\x00MAJOR=10\x00MINOR=235\x00DEVNAME=autofs\x00SEQNUM=670\x00")
+	read := map[string]func() (*UEvent, error){
+		"Pooled": func() (*UEvent, error) { return conn.readUEvent(nil, nil) },
+		"Unpooled": func() (*UEvent, error) {
+			// Like before buffers were pooled: fresh buffer, then peek and read with Recvfrom
+			buf := make([]byte, os.Getpagesize())
+			if _, _, err := syscall.Recvfrom(conn.Fd, buf, syscall.MSG_PEEK); err != nil {
+				return nil, err
+			}
+			n, _, err := syscall.Recvfrom(conn.Fd, buf, 0)
+			if err != nil {
+				return nil, err
+			}
+			return conn.handleMsg(buf[:n], nil, nil, nil), nil
+		},
+	}
+
+	for _, name := range []string{"Pooled", "Unpooled"} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := syscall.Sendto(fd, raw, 0, addr); err != nil {
+					b.Fatal("unable to send msg, err:", err)
+				}
+				if _, err := read[name](); err != nil {
+					b.Fatal("unable to read uevent, err:", err)
+				}
+			}
+		})
+	}
+}
+
+func TestMonitorSource(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(AllEvents); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	uevent := UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}
+	sendMsg(t, conn, uevent.Bytes())
+	sendMsg(t, conn, uevent.BytesUdev())
+
+	var sources []Mode
+	for e, err := range conn.Events(nil) {
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if sources = append(sources, e.Source); len(sources) == 2 {
+			break
+		}
+	}
+	if sources[0] != KernelEvent || sources[1] != UdevEvent {
+		t.Fatalf("wrong sources (got: %v)", sources)
+	}
+}
+
+func TestMonitorClosesChannels(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	queue := make(chan UEvent)
+	errs := make(chan error)
+	quit := conn.Monitor(queue, errs, nil)
+
+	sendMsg(t, conn, UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+	if uevent := <-queue; uevent.KObj != "/devices/foo" {
+		t.Fatal("wrong uevent, got:", uevent.KObj)
+	}
+	close(quit)
+
+	// Consumers ranging over channels terminate naturally
+	done := make(chan struct{})
+	go func() {
+		for range queue {
+		}
+		for range errs {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queue and errs should be closed after quit")
+	}
+	if conn.StopReason() != StopQuit {
+		t.Fatal("wrong stop reason, got:", conn.StopReason())
+	}
+
+	// Limit
+	conn.MatchedUEventLimit = 1
+	queue, errs = make(chan UEvent, 1), make(chan error)
+	conn.Monitor(queue, errs, nil)
+	sendMsg(t, conn, UEvent{Action: ADD, KObj: "/devices/bar", Env: map[string]string{}}.Bytes())
+	n := 0
+	for range queue {
+		n++
+	}
+	if _, more := <-errs; more || n != 1 {
+		t.Fatalf("channels should be closed after limit (uevents: %d)", n)
+	}
+}
+
+func TestMonitorWrongMatcher(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true}
+	matcher := &RuleDefinitions{Rules: []RuleDefinition{{Env: map[string]string{"SUBSYSTEM": "(usb"}}}}
+
+	queue, errs := make(chan UEvent), make(chan error) // errs not read yet
+	returned := make(chan struct{})
+	go func() {
+		conn.Monitor(queue, errs, matcher)
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("Monitor shouldn't block on a wrong matcher")
+	}
+
+	t.FatalfIf(conn.StopReason() != StopError, "Wrong stop reason, got: %s", conn.StopReason())
+	_, more := <-queue
+	t.FatalfIf(more, "Queue should be closed")
+	err := <-errs
+	t.FatalfIf(err == nil, "Wrong matcher should be reported")
+	_, more = <-errs
+	t.FatalfIf(more, "Errs should be closed")
+}
+
+func TestReceivedAt(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	sample := UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"USEC_INITIALIZED": "5000000"}}
+	parsed, err := ParseUEvent(sample.Bytes())
+	t.FatalfIf(err != nil || !parsed.ReceivedAt.IsZero(), "ReceivedAt should be zero for a parsed uevent (err: %v)", err)
+
+	before := time.Now()
+	sendMsg(testing, conn, sample.Bytes())
+	uevent, err := conn.ReadUEvent()
+	t.FatalfIf(err != nil, "Unable to read uevent, err: %v", err)
+	t.FatalfIf(uevent.ReceivedAt.Before(before) || uevent.ReceivedAt.After(time.Now()), "Wrong ReceivedAt, got: %s", uevent.ReceivedAt)
+
+	since, ok := uevent.InitializedSince()
+	t.FatalfIf(!ok || since != 5*time.Second, "Wrong USEC_INITIALIZED, got: %s", since)
+	_, ok = UEvent{}.InitializedSince()
+	t.FatalfIf(ok, "USEC_INITIALIZED shouldn't be found")
+
+	queue := make(chan UEvent)
+	quit := conn.Monitor(queue, make(chan error, 1), nil)
+	defer func() {
+		close(quit)
+		waitStopReason(testing, conn)
+	}()
+	before = time.Now()
+	sendMsg(testing, conn, sample.Bytes())
+	select {
+	case uevent := <-queue:
+		t.FatalfIf(uevent.ReceivedAt.Before(before) || uevent.ReceivedAt.After(time.Now()), "Wrong ReceivedAt, got: %s", uevent.ReceivedAt)
+	case <-time.After(time.Second):
+		t.Fatal("Uevent should be received")
+	}
+}
+
+func TestReadLargeUEvent(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	// Far bigger than a page and than the pooled buffers
+	modalias := strings.Repeat("x", 100*1024)
+	sample := UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"MODALIAS": modalias, "SUBSYSTEM": "usb"}}
+	for _, raw := range [][]byte{sample.Bytes(), sample.BytesUdev()} {
+		sendMsg(testing, conn, raw)
+		uevent, err := conn.ReadUEvent()
+		t.FatalfIf(err != nil, "Unable to read large uevent, err: %v", err)
+		t.FatalfIf(uevent.Modalias() != modalias || uevent.Subsystem() != "usb", "Large uevent shouldn't be truncated (got: %d bytes of MODALIAS)", len(uevent.Modalias()))
+	}
+
+	// Next small msg still fits in a pooled buffer
+	sendMsg(testing, conn, UEvent{Action: REMOVE, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+	uevent, err := conn.ReadUEvent()
+	t.FatalfIf(err != nil || uevent.Action != REMOVE, "Unable to read small uevent, err: %v", err)
+}
+
+func TestLenientParsing(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true, LenientParsing: true}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	queue := make(chan UEvent)
+	errs := make(chan error, 1)
+	quit := conn.Monitor(queue, errs, nil)
+	defer func() {
+		close(quit)
+		waitStopReason(testing, conn)
+	}()
+
+	sendMsg(testing, conn, []byte("add@/devices/foo\x00SUBSYSTEM=usb\x00GARBAGE\x00DEVTYPE=usb_device\x00"))
+	select {
+	case uevent := <-queue:
+		t.FatalfIf(uevent.Subsystem() != "usb" || uevent.DevType() != "usb_device", "Valid env entries should be delivered (got: %v)", uevent.Env)
+	case err := <-errs:
+		t.Fatalf("Uevent shouldn't be dropped, err: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("Uevent should be received")
+	}
+	t.FatalfIf(conn.SkippedEnvs() != 1, "Skipped env entry should be counted (got: %d)", conn.SkippedEnvs())
+}
+
+func TestMonitorSubtree(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	queue := make(chan UEvent, 4)
+	quit := conn.MonitorSubtree(queue, make(chan error, 1), "/devices/virtual/block/loop0", NewActionMatcher(ADD))
+	defer func() {
+		close(quit)
+		waitStopReason(testing, conn)
+	}()
+
+	for _, sample := range []UEvent{
+		{Action: ADD, KObj: "/devices/virtual/block/loop1"},            // sibling
+		{Action: ADD, KObj: "/devices/virtual/block/loop0"},            // device
+		{Action: REMOVE, KObj: "/devices/virtual/block/loop0/loop0p1"}, // filtered by the matcher
+		{Action: ADD, KObj: "/devices/virtual/block/loop0/loop0p1"},    // child
+	} {
+		sample.Env = map[string]string{"DEVPATH": sample.KObj}
+		sendMsg(testing, conn, sample.Bytes())
+	}
+
+	for _, expected := range []string{"/devices/virtual/block/loop0", "/devices/virtual/block/loop0/loop0p1"} {
+		select {
+		case uevent := <-queue:
+			t.FatalfIf(uevent.KObj != expected || uevent.Action != ADD, "Wrong uevent (got: %s@%s, wanted: add@%s)", uevent.Action, uevent.KObj, expected)
+		case <-time.After(time.Second):
+			t.Fatalf("Uevent of %s should be received", expected)
+		}
+	}
+	select {
+	case uevent := <-queue:
+		t.Fatalf("Unexpected uevent %s@%s", uevent.Action, uevent.KObj)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPollUEvent(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	// Would block
+	start := time.Now()
+	uevent, err := conn.PollUEvent()
+	t.FatalfIf(uevent != nil || !errors.Is(err, ErrNoEvent) || !errors.Is(err, syscall.EAGAIN), "Empty socket should return ErrNoEvent, got: %v", err)
+	t.FatalfIf(time.Since(start) > 100*time.Millisecond, "PollUEvent shouldn't block")
+
+	// Event available
+	sendMsg(testing, conn, UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+	sendMsg(testing, conn, UEvent{Action: REMOVE, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+	for _, expected := range []KObjAction{ADD, REMOVE} {
+		uevent, err = conn.PollUEvent()
+		t.FatalfIf(err != nil || uevent.Action != expected, "Pending uevent should be returned (got: %v, err: %v)", uevent, err)
+	}
+	_, err = conn.PollUEvent()
+	t.FatalfIf(!errors.Is(err, ErrNoEvent), "Drained socket should return ErrNoEvent, got: %v", err)
+}
+
+func TestPollUEventMocked(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	var flags int
+	previous := recvfrom
+	defer func() { recvfrom = previous }()
+	recvfrom = func(fd int, p []byte, f int) (int, syscall.Sockaddr, error) {
+		flags = f
+		return 0, nil, syscall.EWOULDBLOCK
+	}
+
+	conn := &UEventConn{TrustAllSenders: true, NetlinkConn: NetlinkConn{Fd: -1}}
+	_, err := conn.PollUEvent()
+	t.FatalfIf(err != ErrNoEvent, "EWOULDBLOCK should return ErrNoEvent, got: %v", err)
+	t.FatalfIf(flags&syscall.MSG_DONTWAIT == 0 || flags&syscall.MSG_PEEK == 0, "Msg should be peeked without waiting (flags: %#x)", flags)
+
+	recvfrom = func(fd int, p []byte, f int) (int, syscall.Sockaddr, error) {
+		return 0, nil, syscall.EBADF
+	}
+	_, err = conn.PollUEvent()
+	t.FatalfIf(!errors.Is(err, syscall.EBADF) || errors.Is(err, ErrNoEvent), "Other errors should be returned as is, got: %v", err)
+}
+
+func TestUnknownActionsOption(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true, UnknownActions: true}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	queue := make(chan UEvent)
+	errs := make(chan error, 1)
+	quit := conn.Monitor(queue, errs, nil)
+	defer func() {
+		close(quit)
+		waitStopReason(testing, conn)
+	}()
+
+	sendMsg(testing, conn, []byte("frobnicate@/devices/foo\x00SUBSYSTEM=usb\x00"))
+	select {
+	case uevent := <-queue:
+		t.FatalfIf(uevent.Action != "frobnicate" || uevent.ActionKnown, "Unknown action should be delivered as is (got: %s)", uevent.Action)
+	case err := <-errs:
+		t.Fatalf("Unknown action shouldn't be dropped, err: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("Uevent should be received")
+	}
+}
+
+func TestPermissionError(t *testing.T) {
+	for _, errno := range []syscall.Errno{syscall.EPERM, syscall.EACCES} {
+		err := privilegesError("bind", errno)
+		if !IsPermissionError(err) || !errors.Is(err, errno) {
+			t.Fatalf("permission error should be wrapped (got: %v)", err)
+		}
+		if !strings.Contains(err.Error(), "CAP_NET_ADMIN") || !strings.Contains(err.Error(), "bind") {
+			t.Fatalf("error should explain the required privileges (got: %s)", err)
+		}
+	}
+
+	if err := privilegesError("bind", syscall.EINVAL); err != syscall.EINVAL || IsPermissionError(err) {
+		t.Fatalf("other errors should be unchanged (got: %v)", err)
+	}
+	if IsPermissionError(nil) {
+		t.Fatal("nil isn't a permission error")
+	}
+}
+
+func TestIsConnected(t *testing.T) {
+	conn := &UEventConn{}
+	if conn.IsConnected() {
+		t.Fatal("conn shouldn't be connected before Connect")
+	}
+
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	if !conn.IsConnected() {
+		t.Fatal("conn should be connected")
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Fatal("unable to close conn, err:", err)
+	}
+	if conn.IsConnected() {
+		t.Fatal("conn shouldn't be connected after Close")
+	}
+
+	// Fd may be reused by another file: it mustn't be closed twice
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal("unable to create pipe, err:", err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if err := conn.Close(); err != nil {
+		t.Fatal("second Close should be a no-op, err:", err)
+	}
+	if _, err := w.Write([]byte("x")); err != nil {
+		t.Fatal("file opened after Close shouldn't be closed by a second Close, err:", err)
+	}
+
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to reconnect, err:", err)
+	}
+	defer conn.Close()
+	if !conn.IsConnected() {
+		t.Fatal("conn should be connected again")
+	}
+
+	if (&UEventConn{NetlinkConn: NetlinkConn{Fd: int(r.Fd())}}).IsConnected() {
+		t.Fatal("pipe isn't an uevent socket")
+	}
+}
+
+func TestCloseWhileMonitoring(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		for _, workers := range []int{0, 4} {
+			conn := &UEventConn{TrustAllSenders: true, ParseWorkers: workers}
+			if err := conn.Connect(UdevEvent); err != nil {
+				t.Fatal("unable to subscribe to netlink uevent, err:", err)
+			}
+
+			// queue is never read: Close must unblock the delivery
+			errs := make(chan error, 16)
+			conn.Monitor(make(chan UEvent), errs, nil)
+			for n := 0; n < 3; n++ {
+				sendMsg(t, conn, UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"DEVPATH": "/devices/foo"}}.Bytes())
+			}
+
+			closeErrs := make(chan error, 3)
+			for n := 0; n < cap(closeErrs); n++ {
+				go func() {
+					closeErrs <- conn.Close()
+				}()
+			}
+			for n := 0; n < cap(closeErrs); n++ {
+				if err := <-closeErrs; err != nil {
+					t.Fatal("concurrent Close shouldn't fail, err:", err)
+				}
+			}
+			if reason := waitStopReason(t, conn); reason != StopClosed {
+				t.Fatalf("monitoring should be stopped by Close (got: %s)", reason)
+			}
+			for err := range errs {
+				t.Fatal("Close shouldn't report any error, got:", err)
+			}
+
+			// Fd could be reused at once by another file, it mustn't be used by the monitoring anymore
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal("unable to create pipe, err:", err)
+			}
+			if _, err := w.Write([]byte("ok")); err != nil {
+				t.Fatal("file opened after Close should be usable, err:", err)
+			}
+			buf := make([]byte, 2)
+			if _, err := r.Read(buf); err != nil || string(buf) != "ok" {
+				t.Fatalf("file opened after Close should be usable (got: %q, err: %v)", buf, err)
+			}
+			r.Close()
+			w.Close()
+		}
+	}
+}
+
+func TestCloseMonitorCallbackAndContext(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	sendMsg(t, conn, UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+
+	// Close called by the callback itself
+	if err := conn.MonitorCallback(nil, func(UEvent) error { return conn.Close() }); err != nil || conn.StopReason() != StopClosed {
+		t.Fatalf("callback monitoring should be stopped by Close (got: %s, err: %v)", conn.StopReason(), err)
+	}
+	if err := conn.MonitorCallback(nil, func(UEvent) error { return nil }); err != ErrConnClosed {
+		t.Fatal("monitoring a closed conn should fail, got:", err)
+	}
+	errs := make(chan error, 1)
+	conn.Monitor(make(chan UEvent), errs, nil)
+	if err := <-errs; err != ErrConnClosed {
+		t.Fatal("monitoring a closed conn should fail, got:", err)
+	}
+
+	// Close called while MonitorContext waits for uevents
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to reconnect, err:", err)
+	}
+	errs = make(chan error, 1)
+	conn.MonitorContext(context.Background(), make(chan UEvent), errs, nil)
+	time.Sleep(10 * time.Millisecond)
+	if err := conn.Close(); err != nil {
+		t.Fatal("unable to close conn, err:", err)
+	}
+	for err := range errs {
+		t.Fatal("Close shouldn't report any error, got:", err)
+	}
+	if reason := conn.StopReason(); reason != StopClosed {
+		t.Fatalf("context monitoring should be stopped by Close (got: %s)", reason)
+	}
+}
+
+func TestTransform(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true}
+	conn.Transform = func(e *UEvent) error {
+		if e.KObj == "/devices/secret" {
+			return errors.New("forbidden device")
+		}
+		e.Env["TENANT"] = "blue"
+		delete(e.Env, "SERIAL")
+		return nil
+	}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	queue, errs := make(chan UEvent, 2), make(chan error, 2)
+	rule := NewEnvRule("TENANT", "blue")
+	quit := conn.Monitor(queue, errs, &rule)
+	defer func() {
+		close(quit)
+		waitStopReason(testing, conn)
+	}()
+
+	sendMsg(testing, conn, UEvent{Action: ADD, KObj: "/devices/secret", Env: map[string]string{}}.Bytes())
+	sendMsg(testing, conn, UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"SERIAL": "1234"}}.Bytes())
+
+	select {
+	case err := <-errs:
+		t.FatalfIf(!strings.Contains(err.Error(), "forbidden device") || !strings.Contains(err.Error(), "/devices/secret"), "Wrong reason of the drop (got: %v)", err)
+	case <-time.After(time.Second):
+		t.Fatalf("Uevent dropped by Transform should be reported")
+	}
+	select {
+	case uevent := <-queue:
+		t.FatalfIf(uevent.KObj != "/devices/foo", "Wrong uevent (got: %s)", uevent.KObj)
+		_, hasSerial := uevent.Env["SERIAL"]
+		t.FatalfIf(uevent.Env["TENANT"] != "blue" || hasSerial, "Transformed env should be delivered (got: %v)", uevent.Env)
+	case <-time.After(time.Second):
+		t.Fatalf("Transformed uevent should be matched and delivered")
+	}
+}
+
+func TestMonitorHighFd(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+
+	// Long-running processes could get fds above FD_SETSIZE (1024), which select(2) can't wait for
+	const highFd = 1500
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil || limit.Cur <= highFd {
+		conn.Close()
+		t.Skip("the limit of open files is too low, err:", err)
+	}
+	if err := syscall.Dup3(conn.Fd, highFd, syscall.O_CLOEXEC); err != nil {
+		conn.Close()
+		t.Fatal("unable to duplicate the socket, err:", err)
+	}
+	syscall.Close(conn.Fd)
+	conn.Fd = highFd
+	defer conn.Close()
+
+	// Deadline reads wait for the socket too
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := conn.ReadMsg(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("Deadline should be reached, got:", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	queue := make(chan UEvent)
+	errs := make(chan error, 1)
+	quit := conn.Monitor(queue, errs, nil)
+	defer func() {
+		close(quit)
+		waitStopReason(t, conn)
+	}()
+
+	sendMsg(t, conn, []byte("add@/devices/foo\x00ACTION=add\x00DEVPATH=/devices/foo\x00SEQNUM=1\x00"))
+	select {
+	case uevent := <-queue:
+		if uevent.KObj != "/devices/foo" {
+			t.Fatalf("Wrong uevent, got: %s", uevent.KObj)
+		}
+	case err := <-errs:
+		t.Fatal("Unexpected error:", err)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for uevent on a high fd")
+	}
+}
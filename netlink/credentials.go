@@ -0,0 +1,108 @@
+package netlink
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"syscall"
+)
+
+// ErrUntrustedSender is returned by read methods for a msg which wasn't sent by the kernel nor by the
+// udev daemon, ie: an uevent spoofed by an unprivileged process (see: UEventConn.TrustAllSenders)
+var ErrUntrustedSender = errors.New("uevent msg from an untrusted sender")
+
+// Sender identify the socket which sent an uevent msg, it is only captured with the Credentials option.
+// Kernel uevents are sent with a PortID of 0, udev ones by the udev daemon (ie: systemd-udevd as root).
+type Sender struct {
+	PortID         uint32 // netlink port id of the sending socket, 0 for the kernel
+	Groups         uint32 // multicast groups the msg was sent to (see: Mode)
+	HasCredentials bool   // false if the kernel didn't attach SCM_CREDENTIALS (ie: SO_PASSCRED not set)
+	Pid            int32  // pid of the sending process (0 for the kernel)
+	Uid            uint32 // uid of the sending process (0 for the kernel)
+	Gid            uint32 // gid of the sending process (0 for the kernel)
+}
+
+// IsKernel return true if the msg was sent by the kernel
+func (s Sender) IsKernel() bool {
+	return s.PortID == 0
+}
+
+func (s Sender) String() string {
+	if !s.HasCredentials {
+		return fmt.Sprintf("portid=%d", s.PortID)
+	}
+	return fmt.Sprintf("portid=%d pid=%d uid=%d gid=%d", s.PortID, s.Pid, s.Uid, s.Gid)
+}
+
+// trusted return true if the msg could be sent by the sender to the subscribed groups, like libudev does:
+// kernel msgs must come from the kernel (port id 0) and udev msgs from a process running as root.
+func (s Sender) trusted(groups uint32, msg []byte) bool {
+	if s.Groups&groups == 0 {
+		return false // not sent to a subscribed group
+	}
+	if s.IsKernel() {
+		return s.Groups == uint32(KernelEvent)
+	}
+	return s.Groups == uint32(UdevEvent) && s.HasCredentials && s.Uid == 0 && bytes.HasPrefix(msg, []byte("libudev\x00"))
+}
+
+// checkSenders return true if senders must be checked, msgs are then read with recvmsg and SO_PASSCRED
+func (c *UEventConn) checkSenders() bool {
+	return !c.TrustAllSenders
+}
+
+// checkSender count, log and return an error if msg wasn't sent by a trusted sender
+func (c *UEventConn) checkSender(sender *Sender, msg []byte) error {
+	if !c.checkSenders() || sender == nil {
+		return nil
+	}
+	if sender.trusted(c.Addr.Groups, msg) {
+		return nil
+	}
+	untrusted := atomic.AddUint64(&c.untrusted, 1)
+	c.logger().Printf("netlink: msg from untrusted sender dropped (%s, untrusted: %d)", sender, untrusted)
+	return fmt.Errorf("%w (%s)", ErrUntrustedSender, sender)
+}
+
+// Untrusted return how many msgs were dropped because they weren't sent by the kernel nor by the udev daemon
+func (c *UEventConn) Untrusted() uint64 {
+	return atomic.LoadUint64(&c.untrusted)
+}
+
+// setPassCred ask the kernel to attach the credentials of the sender to each msg (see: man 7 unix, SO_PASSCRED)
+func setPassCred(fd int) error {
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1); err != nil {
+		return fmt.Errorf("Unable to enable sender credentials, err: %w", err)
+	}
+	return nil
+}
+
+// credentialsOOBSize is the size of the ancillary data holding SCM_CREDENTIALS
+var credentialsOOBSize = syscall.CmsgSpace(syscall.SizeofUcred)
+
+// parseSender return the Sender of a msg from its source address and its ancillary data
+func parseSender(from syscall.Sockaddr, oob []byte) *Sender {
+	sender := &Sender{}
+	if addr, ok := from.(*syscall.SockaddrNetlink); ok {
+		sender.PortID = addr.Pid
+		sender.Groups = addr.Groups
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return sender
+	}
+	for i := range msgs {
+		if msgs[i].Header.Level != syscall.SOL_SOCKET || msgs[i].Header.Type != syscall.SCM_CREDENTIALS {
+			continue
+		}
+		cred, err := syscall.ParseUnixCredentials(&msgs[i])
+		if err != nil {
+			continue
+		}
+		sender.HasCredentials = true
+		sender.Pid, sender.Uid, sender.Gid = cred.Pid, cred.Uid, cred.Gid
+	}
+	return sender
+}
@@ -0,0 +1,135 @@
+package netlink
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCredentials(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true, Credentials: true}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	sample := UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"SUBSYSTEM": "usb"}}
+	sendMsg(testing, conn, sample.Bytes())
+	uevent, err := conn.ReadUEvent()
+	t.FatalfIf(err != nil, "Unable to read uevent, err: %v", err)
+	t.FatalfIf(uevent.Sender == nil, "Sender should be captured")
+	t.FatalfIf(uevent.Sender.IsKernel(), "Msg sent by a process shouldn't be seen as sent by the kernel (got: %s)", uevent.Sender)
+	t.FatalfIf(!uevent.Sender.HasCredentials, "Credentials should be attached")
+	t.FatalfIf(int(uevent.Sender.Pid) != os.Getpid() || int(uevent.Sender.Uid) != os.Getuid(), "Wrong credentials (got: %s)", uevent.Sender)
+
+	queue := make(chan UEvent)
+	quit := conn.Monitor(queue, make(chan error, 1), nil)
+	defer func() {
+		close(quit)
+		waitStopReason(testing, conn)
+	}()
+	sendMsg(testing, conn, sample.Bytes())
+	select {
+	case uevent := <-queue:
+		t.FatalfIf(uevent.Sender == nil || int(uevent.Sender.Pid) != os.Getpid(), "Sender should be captured by Monitor (got: %v)", uevent.Sender)
+	case <-time.After(time.Second):
+		t.Fatal("Uevent should be received")
+	}
+}
+
+func TestWithoutCredentials(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	sendMsg(testing, conn, UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+	uevent, err := conn.ReadUEvent()
+	t.FatalfIf(err != nil, "Unable to read uevent, err: %v", err)
+	t.FatalfIf(uevent.Sender != nil, "Sender should only be captured with Credentials option")
+}
+
+func TestParseSender(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	sender := parseSender(&syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Pid: 0, Groups: 1}, nil)
+	t.FatalfIf(!sender.IsKernel() || sender.HasCredentials || sender.Groups != 1, "Wrong kernel sender (got: %+v)", sender)
+
+	oob := syscall.UnixCredentials(&syscall.Ucred{Pid: 42, Uid: 1000, Gid: 100})
+	sender = parseSender(&syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Pid: 1234}, oob)
+	t.FatalfIf(sender.IsKernel() || !sender.HasCredentials, "Wrong process sender (got: %+v)", sender)
+	t.FatalfIf(sender.Pid != 42 || sender.Uid != 1000 || sender.Gid != 100, "Wrong credentials (got: %s)", sender)
+	t.FatalfIf(sender.String() != "portid=1234 pid=42 uid=1000 gid=100", "Wrong sender string (got: %s)", sender)
+}
+
+func TestUntrustedSender(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := new(UEventConn)
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	// Kernel uevent spoofed by a process
+	sample := UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"ACTION": "add", "DEVPATH": "/devices/foo"}}
+	sendMsg(testing, conn, sample.Bytes())
+	_, err = conn.ReadUEvent()
+	t.FatalfIf(!errors.Is(err, ErrUntrustedSender), "Spoofed uevent should be rejected, got: %v", err)
+	t.FatalfIf(conn.Untrusted() != 1, "Rejected msg should be counted (got: %d)", conn.Untrusted())
+
+	// Udev uevent sent by root, like the udev daemon
+	if os.Geteuid() == 0 {
+		sendMsg(testing, conn, sample.BytesUdev())
+		uevent, err := conn.ReadUEvent()
+		t.FatalfIf(err != nil || uevent.KObj != "/devices/foo", "Udev uevent sent by root should be trusted, err: %v", err)
+	}
+
+	queue := make(chan UEvent)
+	errs := make(chan error, 1)
+	quit := conn.Monitor(queue, errs, nil)
+	defer func() {
+		close(quit)
+		waitStopReason(testing, conn)
+	}()
+	sendMsg(testing, conn, sample.Bytes())
+	select {
+	case uevent := <-queue:
+		t.Fatalf("Spoofed uevent shouldn't be delivered (got: %s)", uevent.KObj)
+	case err := <-errs:
+		t.Fatalf("Spoofed uevent should be dropped silently, err: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	t.FatalfIf(conn.Untrusted() != 2, "Dropped msg should be counted (got: %d)", conn.Untrusted())
+}
+
+func TestSenderTrusted(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	kernelMsg := []byte("add@/devices/foo\x00")
+	udevMsg := UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"ACTION": "add"}}.BytesUdev()
+	all := uint32(AllEvents)
+
+	testcases := []struct {
+		sender   Sender
+		groups   uint32
+		msg      []byte
+		expected bool
+	}{
+		{Sender{PortID: 0, Groups: uint32(KernelEvent)}, all, kernelMsg, true},
+		{Sender{PortID: 0, Groups: uint32(KernelEvent)}, uint32(UdevEvent), kernelMsg, false}, // not subscribed
+		{Sender{PortID: 42, Groups: uint32(KernelEvent), HasCredentials: true}, all, kernelMsg, false},
+		{Sender{PortID: 42, Groups: uint32(UdevEvent), HasCredentials: true}, all, udevMsg, true},
+		{Sender{PortID: 42, Groups: uint32(UdevEvent), HasCredentials: true}, all, kernelMsg, false}, // not an udev msg
+		{Sender{PortID: 42, Groups: uint32(UdevEvent), HasCredentials: true, Uid: 1000}, all, udevMsg, false},
+		{Sender{PortID: 42, Groups: uint32(UdevEvent)}, all, udevMsg, false},       // no credentials
+		{Sender{PortID: 42, Groups: 0, HasCredentials: true}, all, udevMsg, false}, // unicast
+	}
+	for i, tc := range testcases {
+		t.FatalfIf(tc.sender.trusted(tc.groups, tc.msg) != tc.expected, "Wrong trust of sender #%d %+v (wanted: %v)", i, tc.sender, tc.expected)
+	}
+}
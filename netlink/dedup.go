@@ -0,0 +1,79 @@
+package netlink
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// dedupCacheSize is the max number of uevents remembered to detect duplicates
+const dedupCacheSize = 256
+
+// dedupKey return a hash identifying the uevent to detect duplicates: action, kobj and
+// all env vars except SEQNUM (which differs on each uevent)
+func dedupKey(e UEvent) uint64 {
+	keys := make([]string, 0, len(e.Env))
+	for k := range e.Env {
+		if k != "SEQNUM" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	h := fnv.New64a()
+	h.Write([]byte(e.Action))
+	h.Write([]byte{0})
+	h.Write([]byte(e.KObj))
+	for _, k := range keys {
+		h.Write([]byte{0})
+		h.Write([]byte(k))
+		h.Write([]byte{'='})
+		h.Write([]byte(e.Env[k]))
+	}
+	return h.Sum64()
+}
+
+type dedupEntry struct {
+	key  uint64
+	seen time.Time
+}
+
+// dedupCache is a LRU of the last delivered uevents
+type dedupCache struct {
+	window  time.Duration
+	entries *list.List // of *dedupEntry, most recent first
+	index   map[uint64]*list.Element
+}
+
+func newDedupCache(window time.Duration) *dedupCache {
+	return &dedupCache{
+		window:  window,
+		entries: list.New(),
+		index:   make(map[uint64]*list.Element, dedupCacheSize),
+	}
+}
+
+// duplicate return true if the same uevent was delivered less than window before now,
+// otherwise the uevent is remembered as delivered at now.
+// The window isn't extended by duplicates, so a burst of duplicates is delivered once per window.
+func (d *dedupCache) duplicate(e UEvent, now time.Time) bool {
+	key := dedupKey(e)
+	if elem, ok := d.index[key]; ok {
+		entry := elem.Value.(*dedupEntry)
+		if now.Sub(entry.seen) < d.window {
+			return true
+		}
+		entry.seen = now
+		d.entries.MoveToFront(elem)
+		return false
+	}
+
+	d.index[key] = d.entries.PushFront(&dedupEntry{key: key, seen: now})
+	if d.entries.Len() > dedupCacheSize {
+		oldest := d.entries.Back()
+		d.entries.Remove(oldest)
+		delete(d.index, oldest.Value.(*dedupEntry).key)
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package netlink
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDedupCache(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	change := UEvent{Action: CHANGE, KObj: "/devices/foo", Env: map[string]string{"SUBSYSTEM": "block", "SEQNUM": "1"}}
+	other := UEvent{Action: CHANGE, KObj: "/devices/foo", Env: map[string]string{"SUBSYSTEM": "block", "SEQNUM": "2", "DISK_MEDIA_CHANGE": "1"}}
+
+	d := newDedupCache(100 * time.Millisecond)
+	start := time.Now()
+	t.FatalfIf(d.duplicate(change, start), "First uevent shouldn't be a duplicate")
+
+	change.Env["SEQNUM"] = "3"
+	t.FatalfIf(!d.duplicate(change, start.Add(50*time.Millisecond)), "Same uevent inside the window should be a duplicate, whatever SEQNUM")
+	t.FatalfIf(d.duplicate(other, start.Add(50*time.Millisecond)), "Uevent with different env shouldn't be a duplicate")
+	t.FatalfIf(!d.duplicate(change, start.Add(99*time.Millisecond)), "Duplicates shouldn't extend the window")
+	t.FatalfIf(d.duplicate(change, start.Add(100*time.Millisecond)), "Same uevent at the end of the window shouldn't be a duplicate")
+	t.FatalfIf(!d.duplicate(change, start.Add(150*time.Millisecond)), "New window should start from the last delivered uevent")
+
+	remove := change
+	remove.Action = REMOVE
+	t.FatalfIf(d.duplicate(remove, start.Add(150*time.Millisecond)), "Uevent with different action shouldn't be a duplicate")
+
+	// Oldest uevents are evicted
+	for i := 0; i < dedupCacheSize; i++ {
+		d.duplicate(UEvent{Action: ADD, KObj: fmt.Sprintf("/devices/%d", i)}, start)
+	}
+	t.FatalfIf(d.entries.Len() != dedupCacheSize || len(d.index) != dedupCacheSize, "Cache should be bounded (got: %d)", d.entries.Len())
+	t.FatalfIf(d.duplicate(change, start.Add(150*time.Millisecond)), "Evicted uevent shouldn't be a duplicate")
+}
+
+func TestMonitorDedup(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true, Dedup: time.Minute}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	sendMsg(testing, conn, []byte("change@/devices/foo\x00ACTION=change\x00DEVPATH=/devices/foo\x00SEQNUM=1\x00"))
+	sendMsg(testing, conn, []byte("change@/devices/foo\x00ACTION=change\x00DEVPATH=/devices/foo\x00SEQNUM=2\x00"))
+	sendMsg(testing, conn, []byte("change@/devices/bar\x00ACTION=change\x00DEVPATH=/devices/bar\x00SEQNUM=3\x00"))
+
+	var seqNums []uint64
+	for uevent, err := range conn.Events(nil) {
+		t.FatalfIf(err != nil, "Unexpected error: %v", err)
+		if seqNums = append(seqNums, uevent.SeqNum); len(seqNums) == 2 {
+			break
+		}
+	}
+	t.FatalfIf(fmt.Sprint(seqNums) != "[1 3]", "Duplicated uevent should be dropped (got: %v)", seqNums)
+}
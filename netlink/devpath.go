@@ -0,0 +1,112 @@
+package netlink
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DevPath is the path of a device relative to sysfs, ie: "/devices/pci0000:00/0000:00:14.0/usb2/2-1"
+type DevPath string
+
+// NewDevPath return the cleaned DevPath of a kobj, which could be relative to sysfs (ie: KObj of an UEvent)
+// or absolute (ie: KObj of a crawled device). Trailing slashes are removed.
+func NewDevPath(kObj string) DevPath {
+	p := path.Clean("/" + kObj)
+	if p == sysfsPath {
+		return "/"
+	}
+	if strings.HasPrefix(p, sysfsPath+"/") {
+		return DevPath(p[len(sysfsPath):])
+	}
+	return DevPath(p)
+}
+
+// Path return the DevPath of the uevent
+func (e UEvent) Path() DevPath {
+	return NewDevPath(e.KObj)
+}
+
+func (p DevPath) String() string {
+	return string(p)
+}
+
+// SysPath return the absolute path of the device in sysfs, ie: "/sys/devices/..."
+func (p DevPath) SysPath() string {
+	return sysPath(string(p))
+}
+
+// Base return the last component of the path, ie: "2-1" for "/devices/pci0000:00/0000:00:14.0/usb2/2-1"
+func (p DevPath) Base() string {
+	return path.Base(string(p))
+}
+
+// Parent return the path of the parent device, or an empty DevPath for the root
+func (p DevPath) Parent() DevPath {
+	if p == "" || p == "/" {
+		return ""
+	}
+	return DevPath(path.Dir(string(p)))
+}
+
+// Components return the components of the path, ie: ["devices", "virtual", "net", "lo"]
+func (p DevPath) Components() []string {
+	trimmed := strings.Trim(string(p), "/")
+	if trimmed == "" {
+		return nil
+	}
+	return strings.Split(trimmed, "/")
+}
+
+// Contains return true if other is p or one of its descendants, ie: "/devices/.../block/sda" contains
+// "/devices/.../block/sda/sda1" but not "/devices/.../block/sdab"
+func (p DevPath) Contains(other DevPath) bool {
+	if p == "/" {
+		return strings.HasPrefix(string(other), "/")
+	}
+	return other == p || strings.HasPrefix(string(other), string(p)+"/")
+}
+
+// IsVirtual return true if the device isn't backed by hardware (ie: "/devices/virtual/net/lo")
+func (p DevPath) IsVirtual() bool {
+	return strings.HasPrefix(string(p), "/devices/virtual/")
+}
+
+// IsUSB return true if the device is plugged behind an USB bus (ie: ".../usb2/2-1/2-1:1.0")
+func (p DevPath) IsUSB() bool {
+	for _, c := range p.Components() {
+		if isUSBBus(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUSBBus return true for the name of an USB root hub, ie: "usb2"
+func isUSBBus(name string) bool {
+	if len(name) <= len("usb") || !strings.HasPrefix(name, "usb") {
+		return false
+	}
+	for _, r := range name[len("usb"):] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Subsystem return the subsystem of the device from its "subsystem" link in sysfs (ie: "usb")
+func (p DevPath) Subsystem() (string, error) {
+	link, err := os.Readlink(filepath.Join(p.SysPath(), "subsystem"))
+	if err != nil {
+		return "", fmt.Errorf("Unable to read subsystem of %s, err: %w", p, err)
+	}
+	return filepath.Base(link), nil
+}
+
+// ReadAttr return the value of a sysfs attribute of the device (see: ReadAttr)
+func (p DevPath) ReadAttr(attr string) (string, error) {
+	return ReadAttr(string(p), attr)
+}
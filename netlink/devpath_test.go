@@ -0,0 +1,80 @@
+package netlink
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDevPath(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	usb := "/devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.2/0003:04F2:0976.0008/hidraw/hidraw4"
+	testcases := map[string]DevPath{
+		usb:                           DevPath(usb),
+		"devices/virtual/net/lo":      "/devices/virtual/net/lo",
+		"/sys/devices/virtual/net/lo": "/devices/virtual/net/lo",
+		"/devices/virtual/net/lo/":    "/devices/virtual/net/lo",
+		"/devices/virtual/net/lo///":  "/devices/virtual/net/lo",
+		"/system/foo":                 "/system/foo",
+		"/sys":                        "/",
+		"":                            "/",
+	}
+	for kObj, expected := range testcases {
+		t.FatalfIf(NewDevPath(kObj) != expected, "Wrong devpath of %q (got: %s, wanted: %s)", kObj, NewDevPath(kObj), expected)
+	}
+
+	p := UEvent{KObj: usb}.Path()
+	t.FatalfIf(p.SysPath() != "/sys"+usb, "Wrong sys path (got: %s)", p.SysPath())
+	t.FatalfIf(p.Base() != "hidraw4", "Wrong base (got: %s)", p.Base())
+	t.FatalfIf(NewDevPath("/devices/virtual/net/lo/").Base() != "lo", "Base should ignore trailing slash")
+	t.FatalfIf(p.Parent() != "/devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.2/0003:04F2:0976.0008/hidraw", "Wrong parent (got: %s)", p.Parent())
+	t.FatalfIf(!p.IsUSB() || p.IsVirtual(), "Device should be an USB hardware device")
+	t.FatalfIf(len(p.Components()) != 9 || p.Components()[3] != "usb2", "Wrong components (got: %v)", p.Components())
+
+	lo := NewDevPath("/devices/virtual/net/lo")
+	t.FatalfIf(lo.IsUSB() || !lo.IsVirtual(), "Loopback should be a virtual device")
+	t.FatalfIf(NewDevPath("/devices/virtual/misc/usbmon").IsUSB(), "Only USB buses should be detected")
+
+	// Walk up to the root
+	var parents []DevPath
+	for parent := lo.Parent(); parent != ""; parent = parent.Parent() {
+		parents = append(parents, parent)
+	}
+	t.FatalfIf(!reflect.DeepEqual(parents, []DevPath{"/devices/virtual/net", "/devices/virtual", "/devices", "/"}), "Wrong parents (got: %v)", parents)
+	t.FatalfIf(DevPath("/").Components() != nil, "Root shouldn't have components")
+}
+
+func TestDevPathSubsystem(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	root := setSysfsFixture(testing)
+	dir := filepath.Join(root, "devices/virtual/net/lo")
+	t.FatalfIf(os.MkdirAll(dir, 0755) != nil, "Unable to create fixture")
+	t.FatalfIf(os.Symlink("../../../../class/net", filepath.Join(dir, "subsystem")) != nil, "Unable to create fixture")
+
+	subsystem, err := NewDevPath("/devices/virtual/net/lo").Subsystem()
+	t.FatalfIf(err != nil || subsystem != "net", "Wrong subsystem (got: %s, err: %v)", subsystem, err)
+
+	_, err = NewDevPath("/devices/virtual/net/eth0").Subsystem()
+	t.FatalfIf(err == nil, "Missing device should return an error")
+}
+
+func TestDevPathContains(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	disk := NewDevPath("/devices/pci0000:00/0000:00:1f.2/ata1/host0/target0:0:0/0:0:0:0/block/sda")
+	testcases := map[string]bool{
+		"/devices/pci0000:00/0000:00:1f.2/ata1/host0/target0:0:0/0:0:0:0/block/sda":              true,
+		"/devices/pci0000:00/0000:00:1f.2/ata1/host0/target0:0:0/0:0:0:0/block/sda/sda1":         true,
+		"/devices/pci0000:00/0000:00:1f.2/ata1/host0/target0:0:0/0:0:0:0/block/sda/sda1/holders": true,
+		"/devices/pci0000:00/0000:00:1f.2/ata1/host0/target0:0:0/0:0:0:0/block/sdab":             false, // sibling with the same prefix
+		"/devices/pci0000:00/0000:00:1f.2/ata1/host0/target0:0:0/0:0:0:0/block/sdb":              false,
+		"/devices/pci0000:00/0000:00:1f.2/ata1/host0/target0:0:0/0:0:0:0/block":                  false, // parent
+	}
+	for p, expected := range testcases {
+		t.FatalfIf(disk.Contains(NewDevPath(p)) != expected, "Wrong containment of %s (wanted: %v)", p, expected)
+	}
+	t.FatalfIf(!NewDevPath("/").Contains(disk), "Root should contain every device")
+}
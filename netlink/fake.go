@@ -0,0 +1,98 @@
+package netlink
+
+import (
+	"io"
+	"sync"
+)
+
+// UEventReader is the interface of UEventConn used to consume uevents, so code handling uevents could be
+// tested with a FakeConn without privileges nor real hardware.
+type UEventReader interface {
+	ReadUEvent() (*UEvent, error)
+	Monitor(queue chan UEvent, errs chan error, matcher Matcher) chan struct{}
+	Close() error
+}
+
+var (
+	_ UEventReader = (*UEventConn)(nil)
+	_ UEventReader = (*FakeConn)(nil)
+)
+
+// FakeConn is an UEventReader which emits a scripted list of uevents, for unit tests of consumers.
+// Uevents are consumed once, by ReadUEvent or Monitor.
+type FakeConn struct {
+	mu      sync.Mutex
+	uevents []UEvent
+	closed  bool
+}
+
+// NewFakeConn return a FakeConn which will emit the uevents in order
+func NewFakeConn(uevents ...UEvent) *FakeConn {
+	return &FakeConn{uevents: uevents}
+}
+
+// next return the next scripted uevent, false at the end of the script or once closed
+func (f *FakeConn) next() (UEvent, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed || len(f.uevents) == 0 {
+		return UEvent{}, false
+	}
+	e := f.uevents[0]
+	f.uevents = f.uevents[1:]
+	return e, true
+}
+
+// ReadUEvent return the next scripted uevent, io.EOF at the end of the script
+func (f *FakeConn) ReadUEvent() (*UEvent, error) {
+	e, ok := f.next()
+	if !ok {
+		return nil, io.EOF
+	}
+	return &e, nil
+}
+
+// Monitor run in background a worker which send scripted uevents matched by the matcher on queue.
+// Like UEventConn.Monitor, queue and errs are closed when the worker exit: at the end of the script or on quit.
+func (f *FakeConn) Monitor(queue chan UEvent, errs chan error, matcher Matcher) chan struct{} {
+	quit := make(chan struct{}, 1)
+
+	if matcher != nil {
+		if err := matcher.Compile(); err != nil {
+			quit <- struct{}{}
+			close(queue)
+			reportAndClose(errs, err)
+			return quit
+		}
+	}
+
+	go func() {
+		defer close(errs)
+		defer close(queue)
+
+		for {
+			e, ok := f.next()
+			if !ok {
+				return
+			}
+			if matcher != nil && !matcher.Evaluate(e) {
+				continue
+			}
+
+			select {
+			case queue <- e:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return quit
+}
+
+// Close stop emitting uevents
+func (f *FakeConn) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
@@ -0,0 +1,54 @@
+package netlink
+
+import (
+	"io"
+	"testing"
+)
+
+// handleUSB is an example of consumer code tested against an UEventReader
+func handleUSB(r UEventReader) (added []string) {
+	queue, errs := make(chan UEvent), make(chan error)
+	r.Monitor(queue, errs, &RuleDefinitions{Rules: []RuleDefinition{NewSubsystemRule("usb")}})
+	for e := range queue {
+		if e.Action == ADD {
+			added = append(added, e.KObj)
+		}
+	}
+	return added
+}
+
+func TestFakeConn(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	script := []UEvent{
+		{Action: ADD, KObj: "/devices/usb1", Env: map[string]string{"SUBSYSTEM": "usb"}},
+		{Action: ADD, KObj: "/devices/sda", Env: map[string]string{"SUBSYSTEM": "block"}},
+		{Action: REMOVE, KObj: "/devices/usb1", Env: map[string]string{"SUBSYSTEM": "usb"}},
+		{Action: ADD, KObj: "/devices/usb2", Env: map[string]string{"SUBSYSTEM": "usb"}},
+	}
+
+	added := handleUSB(NewFakeConn(script...))
+	t.FatalfIf(len(added) != 2 || added[0] != "/devices/usb1" || added[1] != "/devices/usb2", "Wrong added devices, got: %v", added)
+
+	fake := NewFakeConn(script[:2]...)
+	e, err := fake.ReadUEvent()
+	t.FatalfIf(err != nil || e.KObj != "/devices/usb1", "Wrong first uevent (got: %v), err: %v", e, err)
+	t.FatalfIf(fake.Close() != nil, "Close should succeed")
+	_, err = fake.ReadUEvent()
+	t.FatalfIf(err != io.EOF, "ReadUEvent should return io.EOF once closed, got: %v", err)
+
+	// Quit before the end of the script
+	queue, errs := make(chan UEvent), make(chan error)
+	quit := NewFakeConn(script...).Monitor(queue, errs, nil)
+	<-queue
+	close(quit)
+	for range queue {
+	}
+	_, more := <-errs
+	t.FatalfIf(more, "Errs should be closed")
+
+	// Wrong matcher
+	queue, errs = make(chan UEvent), make(chan error)
+	NewFakeConn(script...).Monitor(queue, errs, &RuleDefinitions{Rules: []RuleDefinition{{Env: map[string]string{"A": "("}}}})
+	t.FatalfIf(<-errs == nil, "Wrong matcher should be reported")
+}
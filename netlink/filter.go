@@ -0,0 +1,102 @@
+package netlink
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bpfMaxInstructions is the max length of a socket filter program (BPF_MAXINSNS)
+const bpfMaxInstructions = 4096
+
+// WithFilter allow to attach a BPF program to the socket, so the kernel only delivers libudev events
+// of the given subsystems (like udev_monitor_filter_update of libudev). Kernel events are always delivered
+// because they don't provide any header to filter on. The filter is kept and attached again by next calls
+// of Connect, calling WithFilter without subsystem remove it. On a conn which isn't connected, the filter
+// is only kept to be attached by Connect.
+// DetectSeqNumGap only checks kernel events while a filter is attached, because SEQNUM of filtered udev events are missing.
+// Note: a Matcher can still be used as secondary filter in userspace.
+func (c *UEventConn) WithFilter(subsystems ...string) error {
+	c.fdMu.Lock()
+	defer c.fdMu.Unlock()
+
+	if len(subsystems) == 0 {
+		c.filter = nil
+		if !c.isConnected() {
+			return nil
+		}
+		if err := syscall.DetachLsf(c.Fd); err != nil && err != syscall.ENOENT {
+			return fmt.Errorf("Unable to detach socket filter, err: %w", err)
+		}
+		return nil
+	}
+
+	// Check the program before keeping the filter, Connect would fail otherwise
+	if _, err := subsystemFilter(subsystems); err != nil {
+		return err
+	}
+	c.filter = append([]string(nil), subsystems...)
+	if !c.isConnected() {
+		return nil
+	}
+	return attachSubsystemFilter(c.Fd, c.filter)
+}
+
+// filtered return true if a socket filter is set by WithFilter
+func (c *UEventConn) filtered() bool {
+	c.fdMu.RLock()
+	defer c.fdMu.RUnlock()
+	return len(c.filter) > 0
+}
+
+func attachSubsystemFilter(fd int, subsystems []string) error {
+	prog, err := subsystemFilter(subsystems)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.AttachLsf(fd, prog); err != nil {
+		return fmt.Errorf("Unable to attach socket filter, err: %w", err)
+	}
+	return nil
+}
+
+// subsystemFilter generate a BPF program which accept kernel events and libudev events of the given subsystems
+func subsystemFilter(subsystems []string) ([]syscall.SockFilter, error) {
+	const (
+		pass = 0xffffffff
+		drop = 0
+	)
+
+	prog := []syscall.SockFilter{
+		// Load the magic of the libudev header, pass the message if it's not a libudev event
+		{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: 8},
+		{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, K: libudevMagic, Jt: 1},
+		{Code: syscall.BPF_RET | syscall.BPF_K, K: pass},
+
+		// Load filter_subsystem_hash
+		{Code: syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS, K: 24},
+	}
+
+	seen := make(map[uint32]bool, len(subsystems))
+	for _, subsystem := range subsystems {
+		hash := stringHash32(subsystem)
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		// Pass the message if hash matches, otherwise skip to the next comparison
+		prog = append(prog,
+			syscall.SockFilter{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, K: hash, Jf: 1},
+			syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: pass},
+		)
+	}
+
+	// No subsystem matches
+	prog = append(prog, syscall.SockFilter{Code: syscall.BPF_RET | syscall.BPF_K, K: drop})
+
+	if len(prog) > bpfMaxInstructions {
+		return nil, fmt.Errorf("Unable to generate socket filter: too many subsystems (%d)", len(seen))
+	}
+	return prog, nil
+}
@@ -0,0 +1,225 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// runFilter interpret the subset of BPF instructions generated by subsystemFilter,
+// return the number of bytes to accept (0 means the packet is dropped)
+func runFilter(t *testing.T, prog []syscall.SockFilter, packet []byte) uint32 {
+	t.Helper()
+
+	var a uint32
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		switch ins.Code {
+		case syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS:
+			if int(ins.K)+4 > len(packet) {
+				return 0 // out of bounds load abort the filter
+			}
+			a = binary.BigEndian.Uint32(packet[ins.K:])
+		case syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K:
+			if a == ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case syscall.BPF_RET | syscall.BPF_K:
+			return ins.K
+		default:
+			t.Fatalf("unsupported BPF instruction: %#x", ins.Code)
+		}
+	}
+	t.Fatal("BPF program without return")
+	return 0
+}
+
+func TestSubsystemFilter(t *testing.T) {
+	usb := UEvent{Action: ADD, KObj: "/devices/usb1", Env: map[string]string{"SUBSYSTEM": "usb"}}
+	block := UEvent{Action: ADD, KObj: "/devices/sda", Env: map[string]string{"SUBSYSTEM": "block"}}
+	input := UEvent{Action: ADD, KObj: "/devices/input0", Env: map[string]string{"SUBSYSTEM": "input"}}
+	none := UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}
+
+	prog, err := subsystemFilter([]string{"usb", "block", "usb"})
+	if err != nil {
+		t.Fatal("unable to generate filter, err:", err)
+	}
+	if len(prog) != 4+2*2+1 {
+		t.Fatalf("duplicated subsystems should be ignored (got: %d instructions)", len(prog))
+	}
+
+	testCases := []struct {
+		name   string
+		packet []byte
+		pass   bool
+	}{
+		{"udev usb", usb.BytesUdev(), true},
+		{"udev block", block.BytesUdev(), true},
+		{"udev input", input.BytesUdev(), false},
+		{"udev without subsystem", none.BytesUdev(), false},
+		{"kernel usb", usb.Bytes(), true},
+		{"kernel input", input.Bytes(), true},
+	}
+
+	for _, tc := range testCases {
+		if got := runFilter(t, prog, tc.packet) != 0; got != tc.pass {
+			t.Errorf("%s: wrong filter result (got: %v, wanted: %v)", tc.name, got, tc.pass)
+		}
+	}
+
+	if _, err := subsystemFilter(make([]string, bpfMaxInstructions)); err != nil {
+		t.Error("same subsystems should be merged, err:", err)
+	}
+	many := make([]string, bpfMaxInstructions)
+	for i := range many {
+		many[i] = string(rune('a'+i%26)) + string(rune(i))
+	}
+	if _, err := subsystemFilter(many); err == nil {
+		t.Error("too long program should be rejected")
+	}
+}
+
+func TestWithFilter(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WithFilter("usb"); err != nil {
+		t.Fatal("unable to attach filter, err:", err)
+	}
+
+	block := UEvent{Action: ADD, KObj: "/devices/sda", Env: map[string]string{"SUBSYSTEM": "block"}}
+	usb := UEvent{Action: ADD, KObj: "/devices/usb1", Env: map[string]string{"SUBSYSTEM": "usb"}}
+	sendMsg(t, conn, block.BytesUdev())
+	sendMsg(t, conn, usb.BytesUdev())
+
+	uevent, err := conn.ReadUEvent()
+	if err != nil {
+		t.Fatal("unable to read uevent, err:", err)
+	}
+	if uevent.KObj != usb.KObj {
+		t.Fatalf("block uevent should be dropped by the kernel, got: %s", uevent.KObj)
+	}
+
+	// Filter is kept on reconnection
+	conn.Close()
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	sendMsg(t, conn, block.BytesUdev())
+	sendMsg(t, conn, usb.BytesUdev())
+	if uevent, err = conn.ReadUEvent(); err != nil || uevent.KObj != usb.KObj {
+		t.Fatalf("filter should be attached on Connect (got: %v, err: %v)", uevent, err)
+	}
+
+	// Remove filter
+	if err := conn.WithFilter(); err != nil {
+		t.Fatal("unable to detach filter, err:", err)
+	}
+	sendMsg(t, conn, block.BytesUdev())
+	if uevent, err = conn.ReadUEvent(); err != nil || uevent.KObj != block.KObj {
+		t.Fatalf("filter should be removed (got: %v, err: %v)", uevent, err)
+	}
+	if err := conn.WithFilter(); err != nil {
+		t.Fatal("detaching missing filter should be ignored, err:", err)
+	}
+}
+
+func TestWithFilterBeforeConnect(t *testing.T) {
+	// Fd is 0 until Connect: stdin mustn't be touched
+	conn := &UEventConn{TrustAllSenders: true}
+	if err := conn.WithFilter("usb"); err != nil {
+		t.Fatal("filter should be kept until Connect, err:", err)
+	}
+
+	tooMany := make([]string, bpfMaxInstructions)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("subsystem%d", i)
+	}
+	if err := conn.WithFilter(tooMany...); err == nil {
+		t.Fatal("too many subsystems should be rejected")
+	}
+
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	block := UEvent{Action: ADD, KObj: "/devices/sda", Env: map[string]string{"SUBSYSTEM": "block"}}
+	usb := UEvent{Action: ADD, KObj: "/devices/usb1", Env: map[string]string{"SUBSYSTEM": "usb"}}
+	sendMsg(t, conn, block.BytesUdev())
+	sendMsg(t, conn, usb.BytesUdev())
+	if uevent, err := conn.ReadUEvent(); err != nil || uevent.KObj != usb.KObj {
+		t.Fatalf("filter set before Connect should be attached (got: %v, err: %v)", uevent, err)
+	}
+
+	conn.Close()
+	if err := conn.WithFilter(); err != nil {
+		t.Fatal("filter should be removed from a closed conn, err:", err)
+	}
+	if conn.filter != nil {
+		t.Fatal("filter should be forgotten")
+	}
+}
+
+func TestWithFilterSeqNumGap(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true, DetectSeqNumGap: true}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+	if err := conn.WithFilter("usb"); err != nil {
+		t.Fatal("unable to attach filter, err:", err)
+	}
+
+	queue, errs := make(chan UEvent), make(chan error, 4)
+	quit := conn.Monitor(queue, errs, nil)
+	defer func() {
+		close(quit)
+		waitStopReason(t, conn)
+	}()
+
+	uevent := func(subsystem, seqNum string) UEvent {
+		return UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"ACTION": "add", "DEVPATH": "/devices/foo", "SUBSYSTEM": subsystem, "SEQNUM": seqNum}}
+	}
+	for _, msg := range [][]byte{
+		uevent("usb", "1").BytesUdev(),
+		uevent("block", "2").BytesUdev(), // dropped by the filter
+		uevent("usb", "3").BytesUdev(),
+		uevent("block", "4").Bytes(),
+		uevent("block", "6").Bytes(), // kernel events are still checked
+	} {
+		sendMsg(t, conn, msg)
+	}
+
+	for _, seqNum := range []uint64{1, 3, 4, 6} {
+		select {
+		case e := <-queue:
+			if e.SeqNum != seqNum {
+				t.Fatalf("wrong uevent (got seqnum: %d, wanted: %d)", e.SeqNum, seqNum)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("missing uevent", seqNum)
+		}
+	}
+
+	var gap *SeqNumGapError
+	select {
+	case err := <-errs:
+		if !errors.As(err, &gap) || gap.Expected != 5 || gap.Got != 6 {
+			t.Fatal("only the gap of kernel events should be reported, got:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("missing gap of kernel events")
+	}
+	if len(errs) != 0 {
+		t.Fatal("unexpected error:", <-errs)
+	}
+}
@@ -0,0 +1,185 @@
+package netlink
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	forwardDialTimeout  = 5 * time.Second
+	forwardWriteTimeout = 5 * time.Second
+)
+
+// Forwarder ship uevents as JSON lines (see: UEvent.MarshalJSON) to a collector listening on a TCP
+// or an Unix domain socket. Uevents are written in background from a bounded queue, so a slow or
+// unreachable collector never blocks the caller: when the queue is full, uevents are dropped and counted.
+// On failure the connection is established again with an exponential backoff, the uevent being written
+// when the connection broke is sent again on the new connection.
+type Forwarder struct {
+	dropped uint64 // first field to be 64-bit aligned for atomic operations
+	sent    uint64
+
+	network, address string
+	logger           Logger
+	queue            chan UEvent
+	stop             chan struct{} // closed by Close to interrupt the reconnection
+	done             chan struct{} // closed when the worker exit
+
+	mu     sync.Mutex // protect queue from a Send during Close
+	closed bool
+}
+
+// NewForwarder return a Forwarder to addr with a queue of up to size pending uevents.
+// addr is "unix:<path>" (or an absolute path) for an Unix domain socket, or "[tcp:]host:port" for TCP.
+// The connection is established in background, failures are logged with logger (ignored if nil).
+func NewForwarder(addr string, size int, logger Logger) (*Forwarder, error) {
+	network, address, err := parseForwardAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if size < 1 {
+		return nil, fmt.Errorf("Wrong forward queue size (got: %d, wanted: at least 1)", size)
+	}
+	if logger == nil {
+		logger = NopLogger{}
+	}
+
+	f := &Forwarder{
+		network: network,
+		address: address,
+		logger:  logger,
+		queue:   make(chan UEvent, size),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go f.run()
+	return f, nil
+}
+
+// parseForwardAddr return the network and the address to dial of a Forwarder addr
+func parseForwardAddr(addr string) (network, address string, err error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		network, address = "unix", strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//")
+	case strings.HasPrefix(addr, "/"):
+		network, address = "unix", addr
+	default:
+		network, address = "tcp", strings.TrimPrefix(strings.TrimPrefix(addr, "tcp:"), "//")
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", fmt.Errorf("Wrong forward address (got: %q, wanted: unix:<path> or host:port), err: %w", addr, err)
+		}
+	}
+	if address == "" {
+		return "", "", fmt.Errorf("Wrong forward address (got: %q, wanted: unix:<path> or host:port)", addr)
+	}
+	return network, address, nil
+}
+
+// Send queue the uevent to forward without blocking, it return false if the uevent is dropped
+// because the queue is full or the Forwarder is closed
+func (f *Forwarder) Send(e UEvent) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.closed {
+		select {
+		case f.queue <- e:
+			return true
+		default:
+		}
+	}
+	atomic.AddUint64(&f.dropped, 1)
+	return false
+}
+
+// Dropped return how many uevents were dropped: queue full, or still pending when closed without connection
+func (f *Forwarder) Dropped() uint64 {
+	return atomic.LoadUint64(&f.dropped)
+}
+
+// Sent return how many uevents were written to the collector
+func (f *Forwarder) Sent() uint64 {
+	return atomic.LoadUint64(&f.sent)
+}
+
+// Close stop the Forwarder once pending uevents are written, or dropped if the collector is unreachable.
+// It could be called many times.
+func (f *Forwarder) Close() error {
+	f.mu.Lock()
+	if !f.closed {
+		f.closed = true
+		close(f.stop)
+		close(f.queue)
+	}
+	f.mu.Unlock()
+
+	<-f.done
+	return nil
+}
+
+// run write queued uevents until the queue is closed
+func (f *Forwarder) run() {
+	defer close(f.done)
+
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	backoff := reconnectMinBackoff
+	for e := range f.queue {
+		line, err := json.Marshal(e)
+		if err != nil {
+			f.logger.Printf("netlink: unable to forward uevent %s@%s: %v", e.Action, e.KObj, err)
+			atomic.AddUint64(&f.dropped, 1)
+			continue
+		}
+		line = append(line, '\n')
+
+		for {
+			if conn == nil {
+				if conn, err = net.DialTimeout(f.network, f.address, forwardDialTimeout); err != nil {
+					conn = nil
+					f.logger.Printf("netlink: unable to connect to %s %s: %v", f.network, f.address, err)
+					if !f.wait(backoff) {
+						// Closed while the collector is unreachable: drop this uevent and pending ones
+						atomic.AddUint64(&f.dropped, uint64(1+len(f.queue)))
+						return
+					}
+					if backoff *= 2; backoff > reconnectMaxBackoff {
+						backoff = reconnectMaxBackoff
+					}
+					continue
+				}
+				backoff = reconnectMinBackoff
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(forwardWriteTimeout))
+			if _, err = conn.Write(line); err != nil {
+				f.logger.Printf("netlink: unable to forward uevent to %s %s: %v", f.network, f.address, err)
+				conn.Close()
+				conn = nil
+				continue
+			}
+			atomic.AddUint64(&f.sent, 1)
+			break
+		}
+	}
+}
+
+// wait sleep for d, it return false if the Forwarder is closed meanwhile
+func (f *Forwarder) wait(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-f.stop:
+		return false
+	}
+}
@@ -0,0 +1,119 @@
+package netlink
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseForwardAddr(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	for addr, expected := range map[string][2]string{
+		"unix:/run/collector.sock":   {"unix", "/run/collector.sock"},
+		"unix:///run/collector.sock": {"unix", "/run/collector.sock"},
+		"/run/collector.sock":        {"unix", "/run/collector.sock"},
+		"localhost:5000":             {"tcp", "localhost:5000"},
+		"tcp://127.0.0.1:5000":       {"tcp", "127.0.0.1:5000"},
+	} {
+		network, address, err := parseForwardAddr(addr)
+		t.FatalfIf(err != nil || network != expected[0] || address != expected[1], "Wrong address of %q (got: %s %s, err: %v)", addr, network, address, err)
+	}
+
+	for _, addr := range []string{"", "unix:", "localhost", "tcp:"} {
+		_, _, err := parseForwardAddr(addr)
+		t.FatalfIf(err == nil, "Wrong address should be rejected (got: %q)", addr)
+	}
+}
+
+// readForwarded decode a JSON line forwarded on conn
+func readForwarded(t testingWrapper, r *bufio.Reader) UEvent {
+	line, err := r.ReadBytes('\n')
+	t.FatalfIf(err != nil, "Unable to read forwarded uevent, err: %v", err)
+	var uevent UEvent
+	err = json.Unmarshal(line, &uevent)
+	t.FatalfIf(err != nil, "Wrong JSON line (got: %q), err: %v", line, err)
+	return uevent
+}
+
+func TestForwarderTCP(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	t.FatalfIf(err != nil, "Unable to listen, err: %v", err)
+	defer l.Close()
+
+	f, err := NewForwarder(l.Addr().String(), 8, nil)
+	t.FatalfIf(err != nil, "Unable to create forwarder, err: %v", err)
+	for _, kObj := range []string{"/devices/foo", "/devices/bar"} {
+		t.FatalfIf(!f.Send(UEvent{Action: ADD, KObj: kObj, SeqNum: 1, Env: map[string]string{"SEQNUM": "1"}}), "Uevent shouldn't be dropped")
+	}
+
+	conn, err := l.Accept()
+	t.FatalfIf(err != nil, "Unable to accept, err: %v", err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	r := bufio.NewReader(conn)
+	for _, kObj := range []string{"/devices/foo", "/devices/bar"} {
+		uevent := readForwarded(t, r)
+		t.FatalfIf(uevent.KObj != kObj || uevent.Action != ADD || uevent.SeqNum != 1, "Wrong forwarded uevent (got: %+v)", uevent)
+	}
+
+	f.Close()
+	t.FatalfIf(f.Sent() != 2 || f.Dropped() != 0, "Wrong counters (sent: %d, dropped: %d)", f.Sent(), f.Dropped())
+	t.FatalfIf(f.Send(UEvent{}), "Uevent should be dropped once closed")
+}
+
+func TestForwarderReconnect(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	path := filepath.Join(testing.TempDir(), "collector.sock")
+	l, err := net.Listen("unix", path)
+	t.FatalfIf(err != nil, "Unable to listen, err: %v", err)
+	defer l.Close()
+
+	f, err := NewForwarder("unix:"+path, 8, nil)
+	t.FatalfIf(err != nil, "Unable to create forwarder, err: %v", err)
+	defer f.Close()
+
+	// The collector closes the first connection after one uevent
+	f.Send(UEvent{Action: ADD, KObj: "/devices/first"})
+	conn, err := l.Accept()
+	t.FatalfIf(err != nil, "Unable to accept, err: %v", err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	uevent := readForwarded(t, bufio.NewReader(conn))
+	t.FatalfIf(uevent.KObj != "/devices/first", "Wrong forwarded uevent (got: %s)", uevent.KObj)
+	conn.Close()
+
+	f.Send(UEvent{Action: REMOVE, KObj: "/devices/second"})
+	conn, err = l.Accept()
+	t.FatalfIf(err != nil, "Unable to accept the reconnection, err: %v", err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	uevent = readForwarded(t, bufio.NewReader(conn))
+	t.FatalfIf(uevent.KObj != "/devices/second" || uevent.Action != REMOVE, "Uevent should be sent again after reconnection (got: %+v)", uevent)
+}
+
+func TestForwarderBackpressure(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	// Nobody listens: uevents stay in the queue
+	f, err := NewForwarder(filepath.Join(testing.TempDir(), "missing.sock"), 2, nil)
+	t.FatalfIf(err != nil, "Unable to create forwarder, err: %v", err)
+
+	rejected := 0
+	for i := 0; i < 5; i++ {
+		if !f.Send(UEvent{Action: ADD, KObj: "/devices/foo"}) {
+			rejected++
+		}
+	}
+	t.FatalfIf(rejected < 2 || f.Dropped() != uint64(rejected), "Uevents should be dropped when the queue is full (rejected: %d, dropped: %d)", rejected, f.Dropped())
+
+	start := time.Now()
+	f.Close()
+	t.FatalfIf(time.Since(start) > time.Second, "Close shouldn't wait for an unreachable collector")
+	t.FatalfIf(f.Dropped() != 5 || f.Sent() != 0, "Pending uevents should be dropped on Close (sent: %d, dropped: %d)", f.Sent(), f.Dropped())
+}
@@ -0,0 +1,81 @@
+package netlink
+
+import (
+	"bytes"
+	"testing"
+)
+
+// fuzzSeeds return captured kernel frames, udev frames and truncated libudev headers to seed the corpus
+func fuzzSeeds() [][]byte {
+	usb := UEvent{Action: ADD, KObj: "/devices/pci0000:00/0000:00:14.0/usb1/1-2", Env: map[string]string{
+		"ACTION": "add", "DEVPATH": "/devices/pci0000:00/0000:00:14.0/usb1/1-2", "SUBSYSTEM": "usb", "DEVTYPE": "usb_device",
+		"DEVNAME": "bus/usb/001/005", "MAJOR": "189", "MINOR": "4", "SEQNUM": "4342", "TAGS": ":seat:uaccess:",
+	}}
+	udev := usb.BytesUdev()
+
+	return [][]byte{
+		[]byte("change@/devices/virtual/misc/autofs\x00ACTION=change\x00DEVPATH=/devices/virtual/misc/autofs\x00SUBSYSTEM=misc\x00SYNTH_UUID=0\x00MAJOR=10\x00MINOR=235\x00DEVNAME=autofs\x00DEVMODE=0644\x00SEQNUM=670\x00"),
+		[]byte("change@/devices/virtual/net/lo\x00ACTION=change\x00DEVPATH=/devices/virtual/net/lo\x00SUBSYSTEM=net\x00SYNTH_UUID=0\x00INTERFACE=lo\x00IFINDEX=1\x00SEQNUM=671\x00"),
+		[]byte("libudev\x00\xfe\xed\xca\xfe(\x00\x00\x00(\x00\x00\x00\x2b\x00\x00\x00\x8a\xfa\x90\xc8\x00\x00\x00\x00\x02\x00\x04\x00\x10\x80\x00\x00" +
+			"ACTION=remove\x00DEVPATH=/devices/foo\x00SUBSYSTEM=tty\x00"),
+		usb.Bytes(),
+		udev,
+		udev[:udevHeaderSize],
+		[]byte("libudev\x00\xfe\xed\xca\xfe"),
+		[]byte("libudev\x00"),
+		[]byte("add@"),
+		nil,
+	}
+}
+
+// FuzzParseUEvent check that ParseUEvent never panics, and that a parsed uevent is consistent
+func FuzzParseUEvent(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(testing *testing.T, raw []byte) {
+		t := testingWrapper{testing}
+
+		e, err := ParseUEvent(raw)
+		if err != nil {
+			t.FatalfIf(e != nil, "No uevent should be returned with an error (got: %+v)", e)
+		} else {
+			_, err := ParseKObjAction(e.Action.String())
+			t.FatalfIf(err != nil || !e.ActionKnown, "Parsed uevent should have a valid action (got: %q)", e.Action)
+			t.FatalfIf(e.Env == nil, "Env of parsed uevent shouldn't be nil")
+			t.FatalfIf(!bytes.Equal(e.Raw, raw), "Raw msg should be kept (got: %q, wanted: %q)", e.Raw, raw)
+			t.FatalfIf((e.Source == UdevEvent) != (e.Header != nil), "Only udev events should have an header")
+		}
+
+		// Relaxed parsing must not panic either
+		e, _, err = ParseUEventLenient(raw)
+		t.FatalfIf(err == nil && e == nil, "Lenient parsing should return an uevent or an error")
+		ParseOptions{Lenient: true, UnknownActions: true, Normalize: true}.Parse(raw)
+		msgDevPath(raw)
+	})
+}
+
+// FuzzParseUdevEvent is FuzzParseUEvent focused on udev events: fuzzed bytes follow the libudev magic header
+func FuzzParseUdevEvent(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		if bytes.HasPrefix(seed, []byte("libudev\x00")) {
+			f.Add(seed[len("libudev\x00"):])
+		}
+	}
+
+	f.Fuzz(func(testing *testing.T, data []byte) {
+		t := testingWrapper{testing}
+
+		raw := append([]byte("libudev\x00"), data...)
+		e, _, err := parseUdevEvent(raw, ParseOptions{})
+		if err != nil {
+			return
+		}
+		_, err = ParseKObjAction(e.Action.String())
+		t.FatalfIf(err != nil, "Parsed uevent should have a valid action (got: %q)", e.Action)
+		t.FatalfIf(e.Header == nil || e.Header.Magic != libudevMagic, "Parsed udev event should have a valid header")
+		t.FatalfIf(e.Header.PropertiesOffset < udevHeaderSize || e.Header.PropertiesOffset >= uint32(len(raw)), "Properties should be inside the msg (got offset: %d)", e.Header.PropertiesOffset)
+		t.FatalfIf(e.Header.PropertiesLength > uint32(len(raw))-e.Header.PropertiesOffset, "Properties shouldn't be truncated (got length: %d)", e.Header.PropertiesLength)
+	})
+}
@@ -0,0 +1,56 @@
+package netlink
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// globToRegexp translate a shell-style glob with the syntax of path.Match into an anchored regexp:
+// '*' matches any sequence of non-'/' characters, '?' any single non-'/' character, '[...]' a character
+// class (negated with '^') and '\\' escapes the next character.
+func globToRegexp(glob string) (string, error) {
+	if _, err := path.Match(glob, ""); err != nil {
+		return "", fmt.Errorf("Wrong glob pattern %q, err: %w", glob, err)
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '\\':
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			i++
+			b.WriteString("[")
+			if glob[i] == '^' {
+				b.WriteString("^")
+				i++
+			}
+			for ; glob[i] != ']'; i++ {
+				if glob[i] == '-' {
+					b.WriteString("-") // range, validated by path.Match
+					continue
+				}
+				if glob[i] == '\\' {
+					i++
+				}
+				if strings.IndexByte(`\^]-[`, glob[i]) >= 0 {
+					b.WriteString(`\`)
+				}
+				b.WriteByte(glob[i])
+			}
+			b.WriteString("]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String(), nil
+}
@@ -0,0 +1,62 @@
+package netlink
+
+import (
+	"path"
+	"regexp"
+	"testing"
+)
+
+func TestGlobToRegexp(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	patterns := []string{"/devices/usb*", "/devices/usb*/*", "sd?", "sd[a-c]", "sd[^a-c]1", `a\*b`, "[\\]x]", "*.[ch]", "", "loop*", "usb-[0-9]-[0-9]:1.0", "a.b+c(d)"}
+	values := []string{"/devices/usb1", "/devices/usb1/1-1", "/devices/usb", "sda", "sdb1", "sdd1", "sdz", "a*b", "axb", "]", "x", "main.c", "a/b.h",
+		"", "loop0", "usb-1-2:1.0", "usb-1-2:100", "a.b+c(d)", "aabbc(d)"}
+
+	for _, pattern := range patterns {
+		expr, err := globToRegexp(pattern)
+		t.FatalfIf(err != nil, "Unable to translate %q, err: %v", pattern, err)
+		reg := regexp.MustCompile(expr)
+		for _, v := range values {
+			expected, _ := path.Match(pattern, v)
+			t.FatalfIf(reg.MatchString(v) != expected, "Glob %q (regexp: %s) should match %q like path.Match: %v", pattern, expr, v, expected)
+		}
+	}
+
+	for _, pattern := range []string{"[", "sd[a-", `a\`, "[]"} {
+		_, err := globToRegexp(pattern)
+		t.FatalfIf(err == nil, "Wrong glob %q should be rejected", pattern)
+	}
+}
+
+func TestGlobRule(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	glob := RuleDefinition{Syntax: SyntaxGlob, Env: map[string]string{"DEVPATH": "/devices/pci*/usb?/*", "DEVNAME": "sd[a-z]"}}
+	regex := RuleDefinition{Env: map[string]string{"DEVPATH": "^/devices/pci[^/]*/usb[^/]/[^/]*$", "DEVNAME": "^sd[a-z]$"}}
+	t.FatalfIf(glob.Compile() != nil || regex.Compile() != nil, "Unable to compile rules")
+
+	for _, env := range []map[string]string{
+		{"DEVPATH": "/devices/pci0000:00/usb1/1-1", "DEVNAME": "sda"},
+		{"DEVPATH": "/devices/pci0000:00/usb1/1-1/1-1:1.0", "DEVNAME": "sda"},
+		{"DEVPATH": "/devices/pci0000:00/usb1/1-1", "DEVNAME": "sda1"},
+		{"DEVPATH": "/devices/platform/usb1/1-1", "DEVNAME": "sdb"},
+	} {
+		e := UEvent{Action: ADD, Env: env}
+		t.FatalfIf(glob.Evaluate(e) != regex.Evaluate(e), "Glob and regex rules should agree on %v", env)
+	}
+	t.FatalfIf(!glob.Evaluate(UEvent{Action: ADD, Env: map[string]string{"DEVPATH": "/devices/pci0/usb2/2-1", "DEVNAME": "sdc"}}), "Glob rule should match")
+
+	// Action and ignore case
+	action := "a*"
+	ignoreCase := RuleDefinition{Syntax: SyntaxGlob, Action: &action, IgnoreCase: true, Env: map[string]string{"ID_VENDOR": "sandisk*"}}
+	t.FatalfIf(!ignoreCase.Evaluate(UEvent{Action: ADD, Env: map[string]string{"ID_VENDOR": "SanDisk_Corp"}}), "Glob should match regardless of case")
+	t.FatalfIf(ignoreCase.Evaluate(UEvent{Action: REMOVE, Env: map[string]string{"ID_VENDOR": "SanDisk_Corp"}}), "Glob action shouldn't match")
+	t.FatalfIf(ignoreCase.String() != "ruledef ( ignorecase glob action=a* env.ID_VENDOR=sandisk* )", "Wrong string, got: %s", ignoreCase.String())
+
+	// Validation
+	wrong := RuleDefinition{Syntax: SyntaxGlob, Env: map[string]string{"DEVNAME": "sd[a-"}}
+	t.FatalfIf(wrong.Compile() == nil, "Wrong glob should be rejected")
+	unknown := RuleDefinition{Syntax: "wildcard", Env: map[string]string{"DEVNAME": "sd*"}}
+	t.FatalfIf(unknown.Compile() == nil, "Unknown syntax should be rejected")
+}
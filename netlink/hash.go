@@ -0,0 +1,66 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// murmurHash2 is the hash used by udev for subsystem/devtype filters,
+// see https://github.com/systemd/systemd/blob/v239/src/basic/MurmurHash2.c
+func murmurHash2(data []byte, seed uint32) uint32 {
+	const (
+		m = 0x5bd1e995
+		r = 24
+	)
+
+	h := seed ^ uint32(len(data))
+	for len(data) >= 4 {
+		k := binary.NativeEndian.Uint32(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h *= m
+		h ^= k
+
+		data = data[4:]
+	}
+
+	switch len(data) {
+	case 3:
+		h ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// stringHash32 is the hash of a string used by udev (see: libudev-util.c)
+func stringHash32(s string) uint32 {
+	return murmurHash2([]byte(s), 0)
+}
+
+// tagsBloom64 return the bloom filter of tags used by udev (see: libudev-util.c)
+func tagsBloom64(tags string) uint64 {
+	var bits uint64
+	for _, tag := range strings.Split(tags, ":") {
+		if tag == "" {
+			continue
+		}
+		hash := stringHash32(tag)
+		bits |= 1 << (hash & 63)
+		bits |= 1 << ((hash >> 6) & 63)
+		bits |= 1 << ((hash >> 12) & 63)
+		bits |= 1 << ((hash >> 18) & 63)
+	}
+	return bits
+}
@@ -0,0 +1,26 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestMurmurHash2(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	// Reference values of MurmurHash2 with seed 0 on little-endian platform
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		testing.Skip("This test assumes little-endian architecture")
+	}
+	testcases := map[string]uint32{
+		"":           0,
+		"a":          0x92685f5e,
+		"usb":        0x0577c5e5,
+		"usb_device": 0x27f8f50c,
+		"block":      0xf0031db7,
+		"systemd":    0xa75f972a,
+	}
+	for s, expected := range testcases {
+		t.FatalfIf(stringHash32(s) != expected, "Wrong hash of %q (got: %#x, wanted: %#x)", s, stringHash32(s), expected)
+	}
+}
@@ -0,0 +1,40 @@
+package netlink
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// IdentityKeys are the identity-bearing env vars used by UEvent.Identity, by order of priority:
+// ID_SERIAL is set by udev from the serial number of the device (ie: USB or disk serial),
+// ID_PATH is the physical path of the device (ie: the USB port), which is stable across re-plug in the same port.
+// Note: both are set by udev only, so uevents of the kernel group fall back to DEVPATH.
+var IdentityKeys = []string{"ID_SERIAL", "ID_PATH"}
+
+// Identity return a stable hash identifying the device of the uevent with IdentityKeys, to correlate the
+// same physical device across add/remove cycles even when its kernel device number changes.
+func (e UEvent) Identity() string {
+	return e.IdentityWith(IdentityKeys...)
+}
+
+// IdentityWith is like Identity with custom identity-bearing env vars: the first one present in the uevent
+// is hashed with its value, otherwise DEVPATH (or KObj) is used.
+// Note: partitions share the ID_SERIAL of their disk, add ie: "ID_PART_ENTRY_UUID" first to tell them apart.
+func (e UEvent) IdentityWith(keys ...string) string {
+	key, value := "DEVPATH", e.KObj
+	if devpath, ok := e.Env["DEVPATH"]; ok {
+		value = devpath
+	}
+	for _, k := range keys {
+		if v, ok := e.Env[k]; ok && v != "" {
+			key, value = k, v
+			break
+		}
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{'='})
+	h.Write([]byte(value))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,29 @@
+package netlink
+
+import "testing"
+
+func TestIdentity(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	add := UEvent{Action: ADD, KObj: "/devices/pci0000:00/0000:00:14.0/usb1/1-1/1-1:1.0/host6/target6:0:0/6:0:0:0/block/sdb", Env: map[string]string{
+		"SUBSYSTEM": "block", "MAJOR": "8", "MINOR": "16", "ID_SERIAL": "SanDisk_Cruzer_4C530001", "ID_PATH": "pci-0000:00:14.0-usb-0:1:1.0-scsi-0:0:0:0",
+	}}
+	// Same device plugged again in another port: another devpath and device number
+	remove := UEvent{Action: REMOVE, KObj: "/devices/pci0000:00/0000:00:14.0/usb1/1-2/1-2:1.0/host7/target7:0:0/7:0:0:0/block/sdc", Env: map[string]string{
+		"SUBSYSTEM": "block", "MAJOR": "8", "MINOR": "32", "ID_SERIAL": "SanDisk_Cruzer_4C530001", "ID_PATH": "pci-0000:00:14.0-usb-0:2:1.0-scsi-0:0:0:0",
+	}}
+	other := UEvent{Action: ADD, KObj: add.KObj, Env: map[string]string{"ID_SERIAL": "Kingston_DT_0001", "ID_PATH": add.Env["ID_PATH"]}}
+
+	t.FatalfIf(add.Identity() != remove.Identity(), "Same device should have the same identity")
+	t.FatalfIf(add.Identity() == other.Identity(), "Another device should have another identity")
+	t.FatalfIf(len(add.Identity()) != 16, "Wrong identity format, got: %s", add.Identity())
+
+	// Custom keys
+	t.FatalfIf(add.IdentityWith("ID_PATH") == remove.IdentityWith("ID_PATH"), "Devices in other ports should have other identities by ID_PATH")
+	t.FatalfIf(add.IdentityWith("ID_PATH") != other.IdentityWith("ID_PATH"), "Devices in the same port should have the same identity by ID_PATH")
+
+	// Fallback on DEVPATH
+	kernel := UEvent{Action: ADD, KObj: add.KObj, Env: map[string]string{"DEVPATH": add.KObj}}
+	t.FatalfIf(kernel.Identity() != UEvent{Action: REMOVE, KObj: add.KObj}.Identity(), "Identity should fall back on DEVPATH")
+	t.FatalfIf(kernel.Identity() == add.Identity(), "Identity by DEVPATH shouldn't collide with identity by ID_SERIAL")
+}
@@ -0,0 +1,139 @@
+//go:build linux && integration
+
+package netlink
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// Integration tests provoke real uevents from the kernel and check the whole pipeline of Monitor,
+// they are only built with the integration tag and run as root with GO_UDEV_INTEGRATION=1:
+//
+//	sudo GO_UDEV_INTEGRATION=1 go test -tags integration -run Integration ./netlink
+//
+// GO_UDEV_INTEGRATION_MODULE could name a harmless kernel module (ie: "dummy") to load and unload too,
+// it's skipped if the module is already loaded.
+
+// integrationTimeout is the maximum delay to receive an uevent provoked by a test
+const integrationTimeout = 5 * time.Second
+
+// requireIntegration skip the test unless integration tests are enabled and run as root
+func requireIntegration(t *testing.T) {
+	t.Helper()
+
+	if os.Getenv("GO_UDEV_INTEGRATION") != "1" {
+		t.Skip("integration tests are disabled, set GO_UDEV_INTEGRATION=1 to enable them")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("integration tests require root to provoke uevents")
+	}
+}
+
+// monitorKernel start Monitor on kernel events matched by matcher, the monitoring is stopped at the end of the test
+func monitorKernel(testing *testing.T, matcher Matcher) chan UEvent {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{}
+	err := conn.Connect(KernelEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+
+	queue := make(chan UEvent, 16)
+	errs := make(chan error, 16)
+	quit := conn.Monitor(queue, errs, matcher)
+	testing.Cleanup(func() {
+		close(quit)
+		waitStopReason(testing, conn)
+		conn.Close()
+		for err := range errs {
+			testing.Error("unexpected error while monitoring, err:", err)
+		}
+	})
+	return queue
+}
+
+// waitUEvent return the next uevent with the action, uevents with other actions are ignored
+func waitUEvent(testing *testing.T, queue chan UEvent, action KObjAction) UEvent {
+	t := testingWrapper{testing}
+
+	deadline := time.After(integrationTimeout)
+	for {
+		select {
+		case uevent := <-queue:
+			if uevent.Action == action {
+				return uevent
+			}
+		case <-deadline:
+			t.Fatalf("Missing %s uevent", action)
+		}
+	}
+}
+
+func TestIntegrationTriggerUEvent(testing *testing.T) {
+	requireIntegration(testing)
+	t := testingWrapper{testing}
+
+	const kObj = "/devices/virtual/mem/null"
+	matcher := &RuleDefinition{Env: map[string]string{"DEVPATH": "^" + regexp.QuoteMeta(kObj) + "$"}}
+	queue := monitorKernel(testing, matcher)
+
+	var prev uint64
+	for _, action := range []KObjAction{ADD, CHANGE, REMOVE} {
+		err := TriggerUEvent(kObj, action)
+		t.FatalfIf(err != nil, "Unable to trigger uevent, err: %v", err)
+
+		uevent := waitUEvent(testing, queue, action)
+		t.FatalfIf(uevent.KObj != kObj || uevent.Env["ACTION"] != action.String(), "Wrong uevent (got: %s@%s)", uevent.Action, uevent.KObj)
+		t.FatalfIf(uevent.Env["SUBSYSTEM"] != "mem" || uevent.Env["DEVNAME"] != "null", "Wrong env (got: %v)", uevent.Env)
+		t.FatalfIf(uevent.Env["SYNTH_UUID"] != "0", "Uevent should be synthetic (got: %v)", uevent.Env)
+		major, _ := uevent.Major()
+		minor, _ := uevent.Minor()
+		t.FatalfIf(major != 1 || minor != 3, "Wrong device number (got: %d:%d)", major, minor)
+		t.FatalfIf(uevent.SeqNum <= prev, "Sequence number should increase (got: %d after %d)", uevent.SeqNum, prev)
+		t.FatalfIf(uevent.ReceivedAt.IsZero(), "Reception time should be set")
+		prev = uevent.SeqNum
+	}
+}
+
+func TestIntegrationModule(testing *testing.T) {
+	requireIntegration(testing)
+	t := testingWrapper{testing}
+
+	module := os.Getenv("GO_UDEV_INTEGRATION_MODULE")
+	if module == "" {
+		testing.Skip("no module to load, set GO_UDEV_INTEGRATION_MODULE to enable this test")
+	}
+	if _, err := os.Stat(filepath.Join("/sys/module", module)); err == nil {
+		testing.Skipf("module %s is already loaded", module)
+	}
+	if _, err := exec.LookPath("modprobe"); err != nil {
+		testing.Skip("modprobe is required, err:", err)
+	}
+
+	kObj := "/module/" + module
+	matcher := &RuleDefinition{Env: map[string]string{"DEVPATH": "^" + regexp.QuoteMeta(kObj) + "$"}}
+	queue := monitorKernel(testing, matcher)
+
+	out, err := exec.Command("modprobe", module).CombinedOutput()
+	t.FatalfIf(err != nil, "Unable to load module %s: %s, err: %v", module, out, err)
+	loaded := true
+	defer func() {
+		if loaded {
+			exec.Command("modprobe", "-r", module).Run()
+		}
+	}()
+
+	uevent := waitUEvent(testing, queue, ADD)
+	t.FatalfIf(uevent.KObj != kObj || uevent.Env["SUBSYSTEM"] != "module", "Wrong uevent (got: %s@%s, env: %v)", uevent.Action, uevent.KObj, uevent.Env)
+
+	out, err = exec.Command("modprobe", "-r", module).CombinedOutput()
+	t.FatalfIf(err != nil, "Unable to unload module %s: %s, err: %v", module, out, err)
+	loaded = false
+
+	uevent = waitUEvent(testing, queue, REMOVE)
+	t.FatalfIf(uevent.KObj != kObj, "Wrong uevent (got: %s@%s)", uevent.Action, uevent.KObj)
+}
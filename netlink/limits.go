@@ -0,0 +1,87 @@
+package netlink
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// StopReason explains why a monitoring stopped
+type StopReason int32
+
+const (
+	StopNone    StopReason = iota // monitoring is running or never started
+	StopQuit                      // stopped by quit signal, ctx cancellation or callback error
+	StopLimit                     // MatchedUEventLimit reached
+	StopTimeout                   // MatchedUEventTimeout reached
+	StopError                     // stopped by a fatal error
+	StopClosed                    // stopped by UEventConn.Close
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopNone:
+		return "none"
+	case StopQuit:
+		return "quit"
+	case StopLimit:
+		return "limit"
+	case StopTimeout:
+		return "timeout"
+	case StopError:
+		return "error"
+	case StopClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// StopReason return why the last monitoring stopped (StopNone while it's running)
+func (c *UEventConn) StopReason() StopReason {
+	return StopReason(atomic.LoadInt32(&c.stopReason))
+}
+
+func (c *UEventConn) setStopReason(r StopReason) {
+	if r != StopNone {
+		c.logger().Printf("netlink: monitoring stopped (reason: %s)", r)
+	}
+	atomic.StoreInt32(&c.stopReason, int32(r))
+}
+
+// limits track MatchedUEventLimit and MatchedUEventTimeout of a monitoring
+type limits struct {
+	count    int
+	max      int
+	deadline time.Time // zero if no timeout
+}
+
+func (c *UEventConn) newLimits() *limits {
+	l := &limits{max: c.MatchedUEventLimit}
+	if c.MatchedUEventTimeout > 0 {
+		l.deadline = time.Now().Add(c.MatchedUEventTimeout)
+	}
+	return l
+}
+
+// wait return how long to wait for the next msg, up to max (negative means forever),
+// and true if the timeout is already expired
+func (l *limits) wait(max time.Duration) (time.Duration, bool) {
+	if l.deadline.IsZero() {
+		return max, false
+	}
+
+	remaining := time.Until(l.deadline)
+	if remaining <= 0 {
+		return 0, true
+	}
+	if max >= 0 && max < remaining {
+		return max, false
+	}
+	return remaining, false
+}
+
+// matched count a matched uevent and return true if the limit is reached
+func (l *limits) matched() bool {
+	l.count++
+	return l.max > 0 && l.count >= l.max
+}
@@ -0,0 +1,87 @@
+package netlink
+
+import (
+	"testing"
+	"time"
+)
+
+// waitStopReason wait until the monitoring of conn is stopped
+func waitStopReason(t *testing.T, conn *UEventConn) StopReason {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for conn.StopReason() == StopNone {
+		if time.Now().After(deadline) {
+			t.Fatal("monitoring should be stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return conn.StopReason()
+}
+
+func TestMonitorLimits(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true, MatchedUEventLimit: 2, MatchedUEventTimeout: 200 * time.Millisecond}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to subscribe to netlink uevent, err:", err)
+	}
+	defer conn.Close()
+
+	// Timeout fires first: only one uevent
+	queue := make(chan UEvent, 2)
+	sendMsg(t, conn, UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+	start := time.Now()
+	conn.Monitor(queue, make(chan error), nil)
+	if reason := waitStopReason(t, conn); reason != StopTimeout {
+		t.Fatal("monitoring should be stopped by timeout, got:", reason)
+	}
+	if elapsed := time.Since(start); elapsed < conn.MatchedUEventTimeout || elapsed > 2*conn.MatchedUEventTimeout {
+		t.Fatal("monitoring should be stopped after timeout, elapsed:", elapsed)
+	}
+	if len(queue) != 1 {
+		t.Fatalf("wrong number of uevents (got: %d, wanted: 1)", len(queue))
+	}
+
+	// Limit fires first
+	queue = make(chan UEvent, 2)
+	sendMsg(t, conn, UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+	sendMsg(t, conn, UEvent{Action: ADD, KObj: "/devices/bar", Env: map[string]string{}}.Bytes())
+	conn.Monitor(queue, make(chan error), nil)
+	if reason := waitStopReason(t, conn); reason != StopLimit {
+		t.Fatal("monitoring should be stopped by limit, got:", reason)
+	}
+
+	// Callback is stopped by timeout too
+	err := conn.MonitorCallback(nil, func(UEvent) error {
+		return nil
+	})
+	if err != nil || conn.StopReason() != StopTimeout {
+		t.Fatalf("callback monitoring should be stopped by timeout (got: %s, err: %v)", conn.StopReason(), err)
+	}
+}
+
+func TestLimitsWait(t *testing.T) {
+	l := (&UEventConn{}).newLimits()
+	if timeout, expired := l.wait(-1); timeout != -1 || expired {
+		t.Fatal("without timeout, wait should be forever")
+	}
+
+	l = (&UEventConn{MatchedUEventTimeout: time.Hour, MatchedUEventLimit: 1}).newLimits()
+	if timeout, expired := l.wait(time.Second); timeout != time.Second || expired {
+		t.Fatal("wait should be capped by max, got:", timeout)
+	}
+	if timeout, _ := l.wait(-1); timeout <= time.Second {
+		t.Fatal("wait should be the remaining duration, got:", timeout)
+	}
+	if !l.matched() {
+		t.Fatal("limit should be reached")
+	}
+
+	l = (&UEventConn{MatchedUEventTimeout: time.Nanosecond}).newLimits()
+	time.Sleep(time.Millisecond)
+	if _, expired := l.wait(-1); !expired {
+		t.Fatal("timeout should be expired")
+	}
+	if l.matched() {
+		t.Fatal("there is no limit")
+	}
+}
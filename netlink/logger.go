@@ -0,0 +1,20 @@
+package netlink
+
+// Logger allow to route internal diagnostics of the library (ie: to zap or slog), *log.Logger satisfies it.
+// Diagnostics are informational: errors are still sent on errs or returned.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// NopLogger is the default Logger which discards everything
+type NopLogger struct{}
+
+func (NopLogger) Printf(format string, v ...interface{}) {}
+
+// logger return the Logger option or NopLogger if not set (without allocation)
+func (c *UEventConn) logger() Logger {
+	if c.Logger == nil {
+		return NopLogger{}
+	}
+	return c.Logger
+}
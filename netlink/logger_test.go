@@ -0,0 +1,63 @@
+package netlink
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// captureLogger record logged lines
+type captureLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *captureLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *captureLogger) contains(substr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLogger(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	logger := &captureLogger{}
+	client := &Client{Logger: logger}
+	client.Conn.TrustAllSenders = true
+	err := client.Open(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to open client, err: %v", err)
+	t.FatalfIf(client.Conn.Logger != logger, "Logger should be passed to the connection")
+
+	queue := client.Subscribe(nil)
+	sendMsg(testing, &client.Conn, []byte("wrong msg"))
+	sendMsg(testing, &client.Conn, UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+	select {
+	case err := <-client.Errors():
+		t.FatalfIf(err == nil, "Unparsable msg should be reported")
+	case <-time.After(time.Second):
+		t.Fatal("Unparsable msg should be reported")
+	}
+	<-queue
+	t.FatalfIf(client.Close() != nil, "Close should succeed")
+
+	for _, expected := range []string{"netlink: client opened (mode: udev)", "netlink: Unable to parse uevent", "netlink: monitoring stopped (reason: quit)"} {
+		t.FatalfIf(!logger.contains(expected), "%q should be logged, got: %q", expected, logger.lines)
+	}
+
+	// Default logger discards everything
+	var conn UEventConn
+	conn.logger().Printf("discarded")
+}
@@ -1,6 +1,7 @@
 package netlink
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 )
@@ -14,13 +15,133 @@ type Matcher interface {
 }
 
 type RuleDefinition struct {
-	Action *string           `json:"action,omitempty"`
-	Env    map[string]string `json:"env,omitempty"`
-	rule   *rule             // Action과 Env 값이 정규표현식 형태로 저장됨.(비교를 위해)
+	Action     *string           `json:"action,omitempty" yaml:"action,omitempty"`
+	Env        map[string]string `json:"env,omitempty" yaml:"env,omitempty"`                 // env var name to regexp, an empty (or null) regexp only requires the presence of the env var
+	Negate     bool              `json:"negate,omitempty" yaml:"negate,omitempty"`           // exclude uevents matched by the rule
+	IgnoreCase bool              `json:"ignore_case,omitempty" yaml:"ignore_case,omitempty"` // match action and env values regardless of case
+	Device     *DeviceType       `json:"device,omitempty" yaml:"device,omitempty"`           // exact SUBSYSTEM and DEVTYPE pair
+	Attrs      map[string]string `json:"attrs,omitempty" yaml:"attrs,omitempty"`             // sysfs attribute name to regexp (like ATTR{} of udev), read from /sys/<DEVPATH>/<attr>
+	Tag        string            `json:"tag,omitempty" yaml:"tag,omitempty"`                 // udev tag the device must carry in TAGS (like TAG== of udev, see: UEvent.HasTag)
+	Syntax     string            `json:"syntax,omitempty" yaml:"syntax,omitempty"`           // syntax of action and env patterns: "regex" (default) or "glob" (see: path.Match)
+	rule       *rule             // Action과 Env 값이 정규표현식 형태로 저장됨.(비교를 위해)
+}
+
+// NewActionRule return a rule matching exactly one of the actions
+func NewActionRule(actions ...KObjAction) RuleDefinition {
+	values := make([]string, 0, len(actions))
+	for _, a := range actions {
+		values = append(values, a.String())
+	}
+	pattern := exactPattern(values)
+	return RuleDefinition{Action: &pattern}
+}
+
+// NewEnvRule return a rule matching when the env var is exactly one of the values,
+// or when the env var exists if there is no value
+func NewEnvRule(name string, values ...string) RuleDefinition {
+	if len(values) == 0 {
+		return RuleDefinition{Env: map[string]string{name: ""}}
+	}
+	return RuleDefinition{Env: map[string]string{name: exactPattern(values)}}
+}
+
+// DeviceType is a pair of SUBSYSTEM and DEVTYPE matched exactly (regardless of case with IgnoreCase),
+// ie: {"subsystem": "usb", "devtype": "usb_device"}. It matches the same uevents than two env regexps
+// "^usb$" and "^usb_device$" but it is clearer and both values are validated.
+type DeviceType struct {
+	Subsystem string `json:"subsystem" yaml:"subsystem"`
+	DevType   string `json:"devtype" yaml:"devtype"`
+}
+
+func (d DeviceType) String() string {
+	return d.Subsystem + "/" + d.DevType
+}
+
+// NewDeviceRule return a rule matching exactly the pair of subsystem and devtype, ie: NewDeviceRule("usb", "usb_device")
+func NewDeviceRule(subsystem, devType string) RuleDefinition {
+	return RuleDefinition{Device: &DeviceType{Subsystem: subsystem, DevType: devType}}
+}
+
+// NewAttrRule return a rule matching when the sysfs attribute of the device is exactly one of the values,
+// or when the attribute exists if there is no value, ie: NewAttrRule("idVendor", "0x1234")
+func NewAttrRule(name string, values ...string) RuleDefinition {
+	if len(values) == 0 {
+		return RuleDefinition{Attrs: map[string]string{name: ""}}
+	}
+	return RuleDefinition{Attrs: map[string]string{name: exactPattern(values)}}
+}
+
+// NewTagRule return a rule matching the devices carrying the udev tag, ie: NewTagRule("systemd")
+func NewTagRule(tag string) RuleDefinition {
+	return RuleDefinition{Tag: tag}
+}
+
+// NewSubtreeRule return a rule matching the uevents of the device and of all its descendants (see: DevPath.Contains),
+// ie: NewSubtreeRule("/devices/.../block/sda") match the disk and its partitions. devpath is cleaned like NewDevPath.
+func NewSubtreeRule(devpath string) RuleDefinition {
+	p := NewDevPath(devpath)
+	if p == "/" {
+		return NewEnvRule("DEVPATH") // whole tree
+	}
+	pattern := "^" + regexp.QuoteMeta(string(p)) + "(/|$)"
+	return RuleDefinition{Env: map[string]string{"DEVPATH": pattern}}
+}
+
+// NewSubsystemRule return a rule matching exactly one of the subsystems, ie: NewSubsystemRule("block", "net")
+func NewSubsystemRule(subsystems ...string) RuleDefinition {
+	return NewEnvRule("SUBSYSTEM", subsystems...)
+}
+
+// NewDevTypeRule return a rule matching exactly one of the device types, ie: NewDevTypeRule("disk", "partition")
+func NewDevTypeRule(devTypes ...string) RuleDefinition {
+	return NewEnvRule("DEVTYPE", devTypes...)
+}
+
+// exactPattern return an anchored regexp matching exactly one of the values
+func exactPattern(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		quoted = append(quoted, regexp.QuoteMeta(v))
+	}
+	return "^(" + strings.Join(quoted, "|") + ")$"
 }
 
 // Evaluate return true if all condition match uevent and envs in rule exists in uevent
+// (or if they don't when the rule is negated)
 func (r RuleDefinition) Evaluate(e UEvent) bool {
+	return r.evaluate(e, newAttrCache(e.KObj))
+}
+
+// evaluate is like Evaluate with attributes read through the cache, shared between rules of the same uevent
+func (r RuleDefinition) evaluate(e UEvent, attrs *attrCache) bool {
+	return r.match(e, attrs) != r.Negate
+}
+
+// EvaluateAction return true if the action match
+// A negated rule return false only if it has no env condition and the action match
+func (r RuleDefinition) EvaluateAction(a KObjAction) bool {
+	if r.Negate {
+		return !(len(r.Env) == 0 && r.Device == nil && len(r.Attrs) == 0 && r.Tag == "" && r.matchAction(a))
+	}
+	return r.matchAction(a)
+}
+
+// EvaluateEnv return true if all env match and exists
+// A negated rule return false only if it has no action condition and all env match
+// Attributes are read from the device of the DEVPATH env var, they don't match without it.
+func (r RuleDefinition) EvaluateEnv(e map[string]string) bool {
+	return r.evaluateEnv(e, newAttrCache(e["DEVPATH"]))
+}
+
+func (r RuleDefinition) evaluateEnv(e map[string]string, attrs *attrCache) bool {
+	if r.Negate {
+		return !(r.Action == nil && r.matchEnv(e, attrs))
+	}
+	return r.matchEnv(e, attrs)
+}
+
+// match return true if all condition match uevent, whatever the rule is negated or not
+func (r RuleDefinition) match(e UEvent, attrs *attrCache) bool {
 	// Compile if needed
 	if r.rule == nil {
 		if err := r.Compile(); err != nil {
@@ -28,11 +149,10 @@ func (r RuleDefinition) Evaluate(e UEvent) bool {
 		}
 	}
 
-	return r.EvaluateAction(e.Action) && r.EvaluateEnv(e.Env)
+	return r.matchAction(e.Action) && r.matchEnv(e.Env, attrs)
 }
 
-// EvaluateAction return true if the action match
-func (r RuleDefinition) EvaluateAction(a KObjAction) bool {
+func (r RuleDefinition) matchAction(a KObjAction) bool {
 	// Compile if needed
 	if r.rule == nil {
 		if err := r.Compile(); err != nil {
@@ -47,46 +167,172 @@ func (r RuleDefinition) EvaluateAction(a KObjAction) bool {
 	return r.rule.Action.MatchString(a.String())
 }
 
-// EvaluateEnv return true if all env match and exists
-func (r RuleDefinition) EvaluateEnv(e map[string]string) bool {
+func (r RuleDefinition) matchEnv(e map[string]string, attrs *attrCache) bool {
 	// Compile if needed
 	if r.rule == nil {
 		if err := r.Compile(); err != nil {
 			return false
 		}
 	}
-	return r.rule.Env.Evaluate(e)
+	return r.rule.Env.Evaluate(e) && r.matchDevice(e) && r.matchTag(e) && r.matchAttrs(attrs)
+}
+
+// matchTag return true if the tag of the rule is one of the tags of TAGS env var, the colon-delimited
+// list is split (see: UEvent.Tags) so a tag never matches a part of another one
+func (r RuleDefinition) matchTag(e map[string]string) bool {
+	if r.rule.Tag == "" {
+		return true
+	}
+	for _, tag := range (UEvent{Env: e}).Tags() {
+		if tag == r.rule.Tag || r.IgnoreCase && strings.EqualFold(tag, r.rule.Tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAttrs return true if all attributes exist and their values match, attributes are read only
+// when the rule has attribute conditions (after env ones, which are cheaper)
+func (r RuleDefinition) matchAttrs(attrs *attrCache) bool {
+	for name, reg := range r.rule.Attrs {
+		v, ok := attrs.get(name)
+		if !ok {
+			return false
+		}
+		if reg != nil && !reg.MatchString(v) {
+			return false
+		}
+	}
+	return true
 }
 
-// Compile prepare rule definition to be able to Evaluate() an UEvent
+func (r RuleDefinition) matchDevice(e map[string]string) bool {
+	d := r.rule.Device
+	if d == nil {
+		return true
+	}
+	equal := func(a, b string) bool {
+		if r.IgnoreCase {
+			return strings.EqualFold(a, b)
+		}
+		return a == b
+	}
+	return equal(e["SUBSYSTEM"], d.Subsystem) && equal(e["DEVTYPE"], d.DevType)
+}
+
+// Compile prepare rule definition to be able to Evaluate() an UEvent.
+// It could be called again, ie: after a change of the definition. Patterns are compiled once per process
+// whatever the number of rules using them (see: compileRegexp).
+// On error, the rule doesn't match anything until a successful Compile.
 func (r *RuleDefinition) Compile() error {
-	r.rule = &rule{
-		Env: make(map[string]*regexp.Regexp),
+	compiled, err := r.compile()
+	if err != nil {
+		r.rule = nil
+		return err
+	}
+	r.rule = compiled
+	return nil
+}
+
+func (r *RuleDefinition) compile() (*rule, error) {
+	compiled := &rule{
+		Env:   make(map[string]*regexp.Regexp),
+		Attrs: make(map[string]*regexp.Regexp),
+	}
+
+	if r.Device != nil {
+		if r.Device.Subsystem == "" || r.Device.DevType == "" {
+			return nil, fmt.Errorf("Wrong device, both subsystem and devtype are required (got: %q)", r.Device.String())
+		}
+		device := *r.Device
+		compiled.Device = &device
+	}
+
+	if r.Tag != "" {
+		if strings.ContainsAny(r.Tag, ": \t\n") {
+			return nil, fmt.Errorf("Wrong tag, it must be a single tag without colon (got: %q)", r.Tag)
+		}
+		compiled.Tag = r.Tag
 	}
 
 	if r.Action != nil {
-		action, err := regexp.Compile(*(r.Action))
+		action, err := r.compilePattern(*(r.Action))
 		if err != nil {
-			return err
+			return nil, err
 		}
-		r.rule.Action = action
+		compiled.Action = action
 	}
 
 	for k, v := range r.Env {
-		reg, err := regexp.Compile(v)
+		if v == "" {
+			compiled.Env[k] = nil // presence only
+			continue
+		}
+
+		reg, err := r.compilePattern(v)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		r.rule.Env[k] = reg
+		compiled.Env[k] = reg
 	}
-	return nil
+
+	for k, v := range r.Attrs {
+		if err := checkAttrName(k); err != nil {
+			return nil, err
+		}
+		if v == "" {
+			compiled.Attrs[k] = nil // presence only
+			continue
+		}
+
+		reg, err := r.compilePattern(v)
+		if err != nil {
+			return nil, err
+		}
+		compiled.Attrs[k] = reg
+	}
+	return compiled, nil
+}
+
+// Pattern syntaxes of RuleDefinition.Syntax
+const (
+	SyntaxRegex = "regex"
+	SyntaxGlob  = "glob"
+)
+
+// compilePattern compile a regexp (or a glob) with the case-insensitive flag if needed
+func (r *RuleDefinition) compilePattern(pattern string) (*regexp.Regexp, error) {
+	switch r.Syntax {
+	case "", SyntaxRegex:
+	case SyntaxGlob:
+		var err error
+		if pattern, err = globToRegexp(pattern); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unknown pattern syntax (got: %q, wanted: %q or %q)", r.Syntax, SyntaxRegex, SyntaxGlob)
+	}
+
+	if r.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	return compileRegexp(pattern)
 }
 
 func (r RuleDefinition) String() string {
 	b := strings.Builder{}
 	b.WriteString("ruledef ( ")
+	if r.Negate {
+		b.WriteString("not ")
+	}
+	if r.IgnoreCase {
+		b.WriteString("ignorecase ")
+	}
+	if r.Syntax == SyntaxGlob {
+		b.WriteString("glob ")
+	}
 
-	if r.Action == nil && len(r.Env) == 0 {
+	if r.Action == nil && len(r.Env) == 0 && r.Device == nil && len(r.Attrs) == 0 && r.Tag == "" {
 		b.WriteString("empty")
 	} else {
 		if r.Action != nil {
@@ -95,11 +341,35 @@ func (r RuleDefinition) String() string {
 			b.WriteRune(' ')
 		}
 
+		if r.Device != nil {
+			b.WriteString("device=")
+			b.WriteString(r.Device.String())
+			b.WriteRune(' ')
+		}
+
+		if r.Tag != "" {
+			b.WriteString("tag=")
+			b.WriteString(r.Tag)
+			b.WriteRune(' ')
+		}
+
 		for k, v := range r.Env {
 			b.WriteString("env.")
 			b.WriteString(k)
-			b.WriteRune('=')
-			b.WriteString(v)
+			if v != "" {
+				b.WriteRune('=')
+				b.WriteString(v)
+			}
+			b.WriteRune(' ')
+		}
+
+		for k, v := range r.Attrs {
+			b.WriteString("attr.")
+			b.WriteString(k)
+			if v != "" {
+				b.WriteRune('=')
+				b.WriteString(v)
+			}
 			b.WriteRune(' ')
 		}
 	}
@@ -111,83 +381,269 @@ func (r RuleDefinition) String() string {
 type rule struct {
 	Action *regexp.Regexp
 	Env    Env
+	Device *DeviceType
+	Tag    string
+	Attrs  Env // same evaluation than env but on sysfs attributes
 }
 
+// Env is the compiled version of RuleDefinition.Env, a nil regexp only requires the presence of the env var
 type Env map[string]*regexp.Regexp
 
+// Evaluate return true if all env vars exist in env and their values match
 func (e Env) Evaluate(env map[string]string) bool {
-	foundEnv := (len(e) == 0)
 	for envName, reg := range e {
-		foundEnv = false
-		for k, v := range env {
-			if k == envName {
-				foundEnv = true
-				if !reg.MatchString(v) {
-					return false
-				}
-				break
-			}
+		v, ok := env[envName]
+		if !ok {
+			return false
 		}
-		if !foundEnv {
+		if reg != nil && !reg.MatchString(v) {
 			return false
 		}
 	}
-
-	return foundEnv
-
+	return true
 }
 
-// RuleDefinitions is like chained rule with OR operator
+// RuleDefinitions is like chained rule with OR operator, except negated rules which exclude
+// the uevents they match (ie: AND NOT operator).
+// When all rules are negated, every uevent not excluded is matched.
 type RuleDefinitions struct {
-	Rules []RuleDefinition
+	Rules []RuleDefinition `json:"rules" yaml:"rules"`
 }
 
 func (rs *RuleDefinitions) AddRule(r RuleDefinition) {
 	rs.Rules = append(rs.Rules, r)
 }
 
+// Compile compile all rules in place, so they aren't compiled again on each evaluation.
+// Like RuleDefinition.Compile, it could be called again and identical patterns are compiled once.
+// Concurrent calls are safe for distinct RuleDefinitions, but rules must be compiled before being
+// shared between goroutines.
 func (rs *RuleDefinitions) Compile() error {
-	for _, r := range rs.Rules {
-		if err := r.Compile(); err != nil {
+	for i := range rs.Rules {
+		if err := rs.Rules[i].Compile(); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// Evaluate return true if almost one rule evaluate the uevent and no negated rule excludes it,
+// each sysfs attribute is read once whatever the number of rules referencing it
 func (rs RuleDefinitions) Evaluate(e UEvent) bool {
+	attrs := newAttrCache(e.KObj)
+	return rs.evaluate(func(r RuleDefinition) bool {
+		return r.evaluate(e, attrs)
+	})
+}
+
+// EvaluateAction return true if the action match
+func (rs RuleDefinitions) EvaluateAction(a KObjAction) (match bool) {
+	return rs.evaluate(func(r RuleDefinition) bool {
+		return r.EvaluateAction(a)
+	})
+}
+
+// EvaluateEnv return true if almost one env match all regexp
+func (rs RuleDefinitions) EvaluateEnv(e map[string]string) bool {
+	attrs := newAttrCache(e["DEVPATH"])
+	return rs.evaluate(func(r RuleDefinition) bool {
+		return r.evaluateEnv(e, attrs)
+	})
+}
+
+// EvaluateMatch is like Evaluate but it return which rule decided, ie: to log "matched rule #3" or to dispatch by rule.
+// When matched, rule is the first non-negated rule matching the uevent (or nil with an index of -1 when
+// all rules are negated). When not matched, rule is the negated rule which excluded the uevent if any,
+// otherwise nil with an index of -1.
+func (rs RuleDefinitions) EvaluateMatch(e UEvent) (matched bool, ruleIndex int, rule *RuleDefinition) {
+	ruleIndex, hasInclude := -1, false
+	attrs := newAttrCache(e.KObj)
+	for i := range rs.Rules {
+		r := &rs.Rules[i]
+		ok := r.evaluate(e, attrs)
+		if r.Negate {
+			if !ok {
+				return false, i, r // excluded
+			}
+			continue
+		}
+		hasInclude = true
+		if ok && rule == nil {
+			ruleIndex, rule = i, r
+		}
+	}
+	if rule != nil {
+		return true, ruleIndex, rule
+	}
+	return !hasInclude && len(rs.Rules) > 0, -1, nil
+}
+
+// evaluate return true if almost one rule evaluate and no negated rule excludes
+func (rs RuleDefinitions) evaluate(eval func(r RuleDefinition) bool) bool {
+	matched, hasInclude := false, false
 	for _, r := range rs.Rules {
-		if r.Evaluate(e) {
+		ok := eval(r)
+		if r.Negate {
+			if !ok {
+				return false // excluded
+			}
+			continue
+		}
+		hasInclude = true
+		matched = matched || ok
+	}
+	return matched || (!hasInclude && len(rs.Rules) > 0)
+}
+
+func (rs RuleDefinitions) String() string {
+	output := ""
+	for _, v := range rs.Rules {
+		output += "- " + v.String() + "\n"
+	}
+	return output
+}
+
+// ActionMatcher match only uevents with one of the actions
+type ActionMatcher struct {
+	Actions []KObjAction
+}
+
+// NewActionMatcher return a matcher which pass only uevents with one of the actions
+func NewActionMatcher(actions ...KObjAction) *ActionMatcher {
+	return &ActionMatcher{Actions: actions}
+}
+
+// Compile check that all actions are known
+func (m *ActionMatcher) Compile() error {
+	for _, a := range m.Actions {
+		if _, err := ParseKObjAction(a.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Evaluate return true if the uevent action is one of the actions
+func (m ActionMatcher) Evaluate(e UEvent) bool {
+	return m.EvaluateAction(e.Action)
+}
+
+// EvaluateAction return true if the action is one of the actions
+func (m ActionMatcher) EvaluateAction(a KObjAction) bool {
+	for _, action := range m.Actions {
+		if action == a {
 			return true
 		}
 	}
 	return false
 }
 
-// EvaluateAction return true if the action match
-func (rs RuleDefinitions) EvaluateAction(a KObjAction) (match bool) {
-	for _, r := range rs.Rules {
-		if r.EvaluateAction(a) {
+// EvaluateEnv return always true, there is no condition on env
+func (m ActionMatcher) EvaluateEnv(e map[string]string) bool {
+	return true
+}
+
+func (m ActionMatcher) String() string {
+	actions := make([]string, 0, len(m.Actions))
+	for _, a := range m.Actions {
+		actions = append(actions, a.String())
+	}
+	return "actions ( " + strings.Join(actions, " ") + " )"
+}
+
+// AndMatcher match an uevent only if all matchers match it (an empty AndMatcher match everything)
+type AndMatcher []Matcher
+
+// Compile compile all matchers
+func (m AndMatcher) Compile() error {
+	for _, matcher := range m {
+		if err := matcher.Compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Evaluate return true if all matchers evaluate the uevent
+func (m AndMatcher) Evaluate(e UEvent) bool {
+	for _, matcher := range m {
+		if !matcher.Evaluate(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// EvaluateAction return true if all matchers evaluate the action
+func (m AndMatcher) EvaluateAction(a KObjAction) bool {
+	for _, matcher := range m {
+		if !matcher.EvaluateAction(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// EvaluateEnv return true if all matchers evaluate the env
+func (m AndMatcher) EvaluateEnv(e map[string]string) bool {
+	for _, matcher := range m {
+		if !matcher.EvaluateEnv(e) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m AndMatcher) String() string {
+	return joinMatchers("and", m)
+}
+
+// OrMatcher match an uevent if almost one matcher match it (an empty OrMatcher match nothing)
+type OrMatcher []Matcher
+
+// Compile compile all matchers
+func (m OrMatcher) Compile() error {
+	return AndMatcher(m).Compile()
+}
+
+// Evaluate return true if almost one matcher evaluate the uevent
+func (m OrMatcher) Evaluate(e UEvent) bool {
+	for _, matcher := range m {
+		if matcher.Evaluate(e) {
 			return true
 		}
 	}
 	return false
 }
 
-// EvaluateEnv return true if almost one env match all regexp
-func (rs RuleDefinitions) EvaluateEnv(e map[string]string) bool {
-	for _, r := range rs.Rules {
-		if r.EvaluateEnv(e) {
+// EvaluateAction return true if almost one matcher evaluate the action
+func (m OrMatcher) EvaluateAction(a KObjAction) bool {
+	for _, matcher := range m {
+		if matcher.EvaluateAction(a) {
 			return true
 		}
 	}
 	return false
 }
 
-func (rs RuleDefinitions) String() string {
-	output := ""
-	for _, v := range rs.Rules {
-		output += "- " + v.String() + "\n"
+// EvaluateEnv return true if almost one matcher evaluate the env
+func (m OrMatcher) EvaluateEnv(e map[string]string) bool {
+	for _, matcher := range m {
+		if matcher.EvaluateEnv(e) {
+			return true
+		}
 	}
-	return output
+	return false
+}
+
+func (m OrMatcher) String() string {
+	return joinMatchers("or", m)
+}
+
+func joinMatchers(operator string, matchers []Matcher) string {
+	parts := make([]string, 0, len(matchers))
+	for _, matcher := range matchers {
+		parts = append(parts, matcher.String())
+	}
+	return operator + " ( " + strings.Join(parts, ", ") + " )"
 }
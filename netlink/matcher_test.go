@@ -1,8 +1,13 @@
 package netlink
 
-import "testing"
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
 
-func TestRules(testing *testing.T) {
+func TestRuleDefinitions(testing *testing.T) {
 	type testcase struct {
 		object interface{}
 		valid  bool
@@ -130,3 +135,357 @@ func TestRules(testing *testing.T) {
 		t.FatalfIf((ok != tcase.valid) && !tcase.valid, "Testcase n°%d shouldn't evaluate event", k+1)
 	}
 }
+
+func TestActionMatcher(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	matcher := NewActionMatcher(ADD, REMOVE)
+	t.FatalfIf(matcher.Compile() != nil, "Known actions should compile")
+	t.FatalfIf(!matcher.Evaluate(UEvent{Action: ADD}), "Add action should match")
+	t.FatalfIf(!matcher.Evaluate(UEvent{Action: REMOVE}), "Remove action should match")
+	t.FatalfIf(matcher.Evaluate(UEvent{Action: CHANGE}), "Change action shouldn't match")
+	t.FatalfIf(!matcher.EvaluateEnv(nil), "Env should always match")
+	t.FatalfIf(NewActionMatcher().Evaluate(UEvent{Action: ADD}), "Empty action matcher shouldn't match")
+	t.FatalfIf(NewActionMatcher("plug").Compile() == nil, "Unknown action shouldn't compile")
+}
+
+func TestMonitorWithActionMatcher(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	for _, action := range []KObjAction{CHANGE, ADD, CHANGE, CHANGE, REMOVE} {
+		sendMsg(testing, conn, UEvent{Action: action, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+	}
+
+	// Filter out CHANGE noise
+	var actions []KObjAction
+	conn.MatchedUEventLimit = 2
+	err = conn.MonitorCallback(NewActionMatcher(ADD, REMOVE), func(e UEvent) error {
+		actions = append(actions, e.Action)
+		return nil
+	})
+	t.FatalfIf(err != nil, "Unable to monitor, err: %v", err)
+	t.FatalfIf(len(actions) != 2 || actions[0] != ADD || actions[1] != REMOVE, "Wrong matched actions (got: %v)", actions)
+}
+
+func TestCombinatorMatchers(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	usb := "^usb$"
+	uevent := UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb"}}
+	rules := &RuleDefinitions{Rules: []RuleDefinition{{Env: map[string]string{"SUBSYSTEM": usb}}}}
+
+	testcases := []struct {
+		matcher Matcher
+		valid   bool
+	}{
+		{matcher: AndMatcher{}, valid: true},
+		{matcher: OrMatcher{}, valid: false},
+		{matcher: AndMatcher{NewActionMatcher(ADD), rules}, valid: true},
+		{matcher: AndMatcher{NewActionMatcher(REMOVE), rules}, valid: false},
+		{matcher: OrMatcher{NewActionMatcher(REMOVE), rules}, valid: true},
+		{matcher: OrMatcher{NewActionMatcher(REMOVE), NewActionMatcher(CHANGE)}, valid: false},
+		{matcher: AndMatcher{OrMatcher{NewActionMatcher(REMOVE), rules}, NewActionMatcher(ADD)}, valid: true},
+	}
+
+	for k, tcase := range testcases {
+		err := tcase.matcher.Compile()
+		t.FatalfIf(err != nil, "Testcase n°%d should compile without error, err: %v", k+1, err)
+		t.FatalfIf(tcase.matcher.Evaluate(uevent) != tcase.valid, "Testcase n°%d: wrong evaluation of %s", k+1, tcase.matcher)
+	}
+
+	wrong := "("
+	invalid := &RuleDefinitions{Rules: []RuleDefinition{{Action: &wrong}}}
+	t.FatalfIf(AndMatcher{NewActionMatcher(ADD), invalid}.Compile() == nil, "Invalid child matcher shouldn't compile")
+	t.FatalfIf(OrMatcher{invalid}.Compile() == nil, "Invalid child matcher shouldn't compile")
+}
+
+func TestNegatedRules(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	var rules RuleDefinitions
+	err := json.Unmarshal([]byte(`{"rules": [
+		{"env": {"SUBSYSTEM": "^(usb|block)$"}},
+		{"env": {"DEVTYPE": "^usb_interface$"}, "negate": true},
+		{"action": "^remove$", "negate": true}
+	]}`), &rules)
+	t.FatalfIf(err != nil, "Unable to parse rules, err: %v", err)
+	t.FatalfIf(rules.Rules[0].Negate || !rules.Rules[1].Negate, "Negate field should be optional")
+	t.FatalfIf(rules.Compile() != nil, "Rules should compile")
+
+	testcases := []struct {
+		uevent UEvent
+		valid  bool
+	}{
+		{uevent: UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb", "DEVTYPE": "usb_device"}}, valid: true},
+		{uevent: UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb", "DEVTYPE": "usb_interface"}}, valid: false},
+		{uevent: UEvent{Action: REMOVE, Env: map[string]string{"SUBSYSTEM": "block"}}, valid: false},
+		{uevent: UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "block"}}, valid: true},
+		{uevent: UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "net"}}, valid: false},
+	}
+	for k, tcase := range testcases {
+		t.FatalfIf(rules.Evaluate(tcase.uevent) != tcase.valid, "Testcase n°%d: wrong evaluation", k+1)
+	}
+
+	// Only exclude rules: everything except USB
+	notUSB := RuleDefinitions{Rules: []RuleDefinition{{Env: map[string]string{"SUBSYSTEM": "^usb"}, Negate: true}}}
+	t.FatalfIf(notUSB.Evaluate(UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb"}}), "USB uevent should be excluded")
+	t.FatalfIf(!notUSB.Evaluate(UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "net"}}), "Non USB uevent should match")
+	t.FatalfIf(notUSB.EvaluateEnv(map[string]string{"SUBSYSTEM": "usb"}), "USB env should be excluded")
+	t.FatalfIf(!notUSB.EvaluateAction(ADD), "Action can't be excluded by a rule with env condition")
+	t.FatalfIf((&RuleDefinitions{}).Evaluate(UEvent{Action: ADD}), "Empty rules shouldn't match")
+}
+
+func TestIgnoreCaseRule(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	var rules RuleDefinitions
+	err := json.Unmarshal([]byte(`{"rules": [
+		{"action": "^ADD$", "env": {"SUBSYSTEM": "^USB$"}, "ignore_case": true},
+		{"env": {"SUBSYSTEM": "^BLOCK$"}}
+	]}`), &rules)
+	t.FatalfIf(err != nil, "Unable to parse rules, err: %v", err)
+	t.FatalfIf(rules.Compile() != nil, "Rules should compile")
+
+	t.FatalfIf(!rules.Rules[0].Evaluate(UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb"}}), "USB rule should match usb subsystem regardless of case")
+	t.FatalfIf(rules.Rules[1].Evaluate(UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "block"}}), "Rule should be case-sensitive by default")
+	t.FatalfIf(!rules.Evaluate(UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "Usb"}}), "Rules should match usb subsystem regardless of case")
+}
+
+func TestRuleConstructors(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	rules := RuleDefinitions{}
+	rules.AddRule(NewSubsystemRule("block", "net"))
+	t.FatalfIf(rules.Compile() != nil, "Subsystem rule should compile")
+	t.FatalfIf(!rules.Evaluate(UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "block"}}), "Block subsystem should match")
+	t.FatalfIf(!rules.Evaluate(UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "net"}}), "Net subsystem should match")
+	t.FatalfIf(rules.Evaluate(UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "blocks"}}), "Subsystem should be matched exactly")
+	t.FatalfIf(rules.Evaluate(UEvent{Action: ADD, Env: map[string]string{}}), "Missing subsystem shouldn't match")
+
+	action := NewActionRule(ADD)
+	t.FatalfIf(action.Compile() != nil, "Action rule should compile")
+	t.FatalfIf(!action.Evaluate(UEvent{Action: ADD}), "Add action should match")
+	t.FatalfIf(action.Evaluate(UEvent{Action: "readd"}), "Action should be matched exactly")
+
+	devType := NewDevTypeRule("usb_device")
+	t.FatalfIf(!devType.Evaluate(UEvent{Env: map[string]string{"DEVTYPE": "usb_device"}}), "Device type should match")
+
+	// Values are quoted
+	env := NewEnvRule("DEVNAME", "sd.1")
+	t.FatalfIf(env.Compile() != nil, "Env rule should compile")
+	t.FatalfIf(env.Evaluate(UEvent{Env: map[string]string{"DEVNAME": "sda1"}}), "Regexp meta characters should be quoted")
+	t.FatalfIf(!env.Evaluate(UEvent{Env: map[string]string{"DEVNAME": "sd.1"}}), "Literal value should match")
+}
+
+func TestEnvPresenceRule(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	var rules RuleDefinitions
+	err := json.Unmarshal([]byte(`{"rules": [
+		{"env": {"ID_SERIAL": "", "SUBSYSTEM": "^block$"}},
+		{"env": {"ID_MODEL": null}}
+	]}`), &rules)
+	t.FatalfIf(err != nil, "Unable to parse rules, err: %v", err)
+	t.FatalfIf(rules.Compile() != nil, "Rules should compile")
+
+	serial := rules.Rules[0]
+	t.FatalfIf(!serial.Evaluate(UEvent{Env: map[string]string{"ID_SERIAL": "foo", "SUBSYSTEM": "block"}}), "Rule should match any value of ID_SERIAL")
+	t.FatalfIf(!serial.Evaluate(UEvent{Env: map[string]string{"ID_SERIAL": "", "SUBSYSTEM": "block"}}), "Rule should match empty value of ID_SERIAL")
+	t.FatalfIf(serial.Evaluate(UEvent{Env: map[string]string{"SUBSYSTEM": "block"}}), "Rule shouldn't match without ID_SERIAL")
+	t.FatalfIf(serial.Evaluate(UEvent{Env: map[string]string{"ID_SERIAL": "foo", "SUBSYSTEM": "net"}}), "Value regexp should still be applied")
+
+	t.FatalfIf(!rules.Evaluate(UEvent{Env: map[string]string{"ID_MODEL": "bar"}}), "Null value should only require the presence of ID_MODEL")
+	t.FatalfIf(rules.Evaluate(UEvent{Env: map[string]string{"ID_VENDOR": "bar"}}), "Rules shouldn't match without ID_SERIAL or ID_MODEL")
+
+	presence := NewEnvRule("ID_SERIAL")
+	t.FatalfIf(!presence.Evaluate(UEvent{Env: map[string]string{"ID_SERIAL": "foo"}}), "Env rule without value should only require the presence")
+	t.FatalfIf(presence.Evaluate(UEvent{Env: map[string]string{}}), "Env rule without value shouldn't match missing env var")
+	t.FatalfIf(presence.String() != "ruledef ( env.ID_SERIAL )", "Wrong presence rule string (got: %s)", presence.String())
+}
+
+func TestEvaluateMatch(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	usb := UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb", "DEVTYPE": "usb_device"}}
+	block := UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "block"}}
+	remove := UEvent{Action: REMOVE, Env: map[string]string{"SUBSYSTEM": "usb"}}
+
+	rules := RuleDefinitions{}
+	matched, index, rule := rules.EvaluateMatch(usb)
+	t.FatalfIf(matched || index != -1 || rule != nil, "Empty rules shouldn't match")
+
+	rules.AddRule(NewSubsystemRule("block"))
+	rules.AddRule(NewSubsystemRule("usb"))
+	rules.AddRule(NewDevTypeRule("usb_device"))
+	excluded := NewActionRule(REMOVE)
+	excluded.Negate = true
+	rules.AddRule(excluded)
+
+	for _, tc := range []struct {
+		uevent  UEvent
+		matched bool
+		index   int
+	}{
+		{usb, true, 1}, // first matching rule
+		{block, true, 0},
+		{remove, false, 3}, // excluded
+		{UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "net"}}, false, -1},
+	} {
+		matched, index, rule := rules.EvaluateMatch(tc.uevent)
+		t.FatalfIf(matched != tc.matched || index != tc.index, "Wrong match for %s (got: %v #%d, expected: %v #%d)", tc.uevent.Env, matched, index, tc.matched, tc.index)
+		t.FatalfIf(matched != rules.Evaluate(tc.uevent), "EvaluateMatch and Evaluate should agree for %s", tc.uevent.Env)
+		if index >= 0 {
+			t.FatalfIf(rule != &rules.Rules[index], "Wrong rule returned for %s", tc.uevent.Env)
+		}
+	}
+
+	onlyNegated := RuleDefinitions{Rules: []RuleDefinition{excluded}}
+	matched, index, rule = onlyNegated.EvaluateMatch(block)
+	t.FatalfIf(!matched || index != -1 || rule != nil, "Only negated rules should match without rule")
+}
+
+func TestDeviceRule(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	usbDevice := UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb", "DEVTYPE": "usb_device"}}
+	usbInterface := UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb", "DEVTYPE": "usb_interface"}}
+	disk := UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "block", "DEVTYPE": "disk"}}
+	noDevType := UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb"}}
+
+	var rule RuleDefinition
+	err := json.Unmarshal([]byte(`{"device": {"subsystem": "usb", "devtype": "usb_device"}}`), &rule)
+	t.FatalfIf(err != nil, "Unable to unmarshal rule, err: %v", err)
+	t.FatalfIf(rule.Compile() != nil, "Unable to compile rule")
+	t.FatalfIf(rule.String() != "ruledef ( device=usb/usb_device )", "Wrong rule string, got: %s", rule.String())
+
+	// Within one uevent, the pair is equivalent to two independent anchored env regexps
+	independent := RuleDefinition{Env: map[string]string{"SUBSYSTEM": "^usb$", "DEVTYPE": "^usb_device$"}}
+	for _, e := range []UEvent{usbDevice, usbInterface, disk, noDevType} {
+		t.FatalfIf(rule.Evaluate(e) != independent.Evaluate(e), "Pair and independent env rules should agree on %v", e.Env)
+	}
+	t.FatalfIf(!rule.Evaluate(usbDevice), "Rule should match usb_device")
+	t.FatalfIf(rule.Evaluate(usbInterface) || rule.Evaluate(disk) || rule.Evaluate(noDevType), "Rule should only match usb_device")
+
+	// Unlike regexps, values are matched exactly
+	t.FatalfIf(NewDeviceRule("usb", "usb").Evaluate(usbDevice), "Devtype should be matched exactly")
+	ignoreCase := NewDeviceRule("USB", "USB_Device")
+	ignoreCase.IgnoreCase = true
+	t.FatalfIf(!ignoreCase.Evaluate(usbDevice), "Pair should match regardless of case")
+
+	negated := NewDeviceRule("usb", "usb_device")
+	negated.Negate = true
+	t.FatalfIf(!negated.EvaluateAction(ADD), "Negated device rule shouldn't exclude by action only")
+	t.FatalfIf(negated.EvaluateEnv(usbDevice.Env) || !negated.EvaluateEnv(disk.Env), "Negated device rule should exclude usb_device only")
+
+	// Both values are required
+	for _, d := range []DeviceType{{Subsystem: "usb"}, {DevType: "disk"}} {
+		r := RuleDefinition{Device: &d}
+		t.FatalfIf(r.Compile() == nil, "Incomplete device %v should be rejected", d)
+	}
+}
+
+func TestTagRule(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	systemd := UEvent{Action: ADD, Env: map[string]string{"TAGS": ":systemd:seat:"}}
+	seatd := UEvent{Action: ADD, Env: map[string]string{"TAGS": ":seatd:"}}
+	untagged := UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb"}}
+
+	var rule RuleDefinition
+	err := json.Unmarshal([]byte(`{"tag": "seat"}`), &rule)
+	t.FatalfIf(err != nil, "Unable to unmarshal rule, err: %v", err)
+	t.FatalfIf(rule.Compile() != nil, "Unable to compile rule")
+	t.FatalfIf(rule.String() != "ruledef ( tag=seat )", "Wrong rule string, got: %s", rule.String())
+	t.FatalfIf(!rule.Evaluate(systemd), "Rule should match %q", systemd.Env["TAGS"])
+	t.FatalfIf(rule.Evaluate(seatd), "Tag shouldn't match a part of another tag (got: %q)", seatd.Env["TAGS"])
+	t.FatalfIf(rule.Evaluate(untagged), "Rule shouldn't match without TAGS")
+
+	t.FatalfIf(!NewTagRule("systemd").Evaluate(systemd), "Rule should match %q", systemd.Env["TAGS"])
+	ignoreCase := NewTagRule("SystemD")
+	ignoreCase.IgnoreCase = true
+	t.FatalfIf(!ignoreCase.Evaluate(systemd), "Tag should match regardless of case")
+
+	negated := NewTagRule("seat")
+	negated.Negate = true
+	t.FatalfIf(!negated.EvaluateAction(ADD), "Negated tag rule shouldn't exclude by action only")
+	t.FatalfIf(negated.EvaluateEnv(systemd.Env) || !negated.EvaluateEnv(seatd.Env), "Negated tag rule should exclude tagged devices only")
+
+	for _, tag := range []string{":systemd:", "systemd:seat", "sys temd"} {
+		r := NewTagRule(tag)
+		t.FatalfIf(r.Compile() == nil, "Wrong tag %q should be rejected", tag)
+	}
+}
+
+func TestAttrRule(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	root := setSysfsFixture(testing)
+	kObj := "/devices/pci0000:00/0000:00:14.0/usb1/1-1"
+	dir := filepath.Join(root, kObj)
+	t.FatalfIf(os.MkdirAll(dir, 0755) != nil, "Unable to create fixture")
+	t.FatalfIf(os.WriteFile(filepath.Join(dir, "idVendor"), []byte("058f\n"), 0644) != nil, "Unable to create fixture")
+	t.FatalfIf(os.WriteFile(filepath.Join(dir, "product"), []byte("Mass Storage\n"), 0644) != nil, "Unable to create fixture")
+
+	usb := UEvent{Action: ADD, KObj: kObj, Env: map[string]string{"SUBSYSTEM": "usb", "DEVPATH": kObj}}
+	other := UEvent{Action: ADD, KObj: "/devices/virtual/mem/null", Env: map[string]string{"SUBSYSTEM": "mem"}}
+
+	vendor := NewAttrRule("idVendor", "058f")
+	t.FatalfIf(vendor.Compile() != nil, "Attribute rule should compile")
+	t.FatalfIf(!vendor.Evaluate(usb), "Attribute rule should match the sysfs attribute of the device")
+	t.FatalfIf(!vendor.EvaluateEnv(usb.Env), "Attribute rule should read attributes from DEVPATH env var")
+	t.FatalfIf(vendor.Evaluate(other), "Missing attribute shouldn't match")
+	t.FatalfIf(NewAttrRule("idVendor", "1234").Evaluate(usb), "Wrong attribute value shouldn't match")
+	t.FatalfIf(!NewAttrRule("product").Evaluate(usb), "Attribute rule without value should only require the presence")
+	t.FatalfIf(vendor.String() != "ruledef ( attr.idVendor=^(058f)$ )", "Wrong attribute rule string (got: %s)", vendor.String())
+
+	var rules RuleDefinitions
+	t.FatalfIf(json.Unmarshal([]byte(`{"rules": [{"env": {"SUBSYSTEM": "usb"}, "attrs": {"product": "(?i)^mass"}}]}`), &rules) != nil, "Unable to unmarshal rules")
+	t.FatalfIf(rules.Compile() != nil, "Rules with attributes should compile")
+	t.FatalfIf(!rules.Evaluate(usb), "Rules should match the product attribute")
+
+	negated := NewAttrRule("idVendor", "058f")
+	negated.Negate = true
+	t.FatalfIf(negated.Evaluate(usb), "Negated attribute rule should exclude the device")
+	t.FatalfIf(!negated.EvaluateAction(ADD), "Negated attribute rule shouldn't exclude on the action only")
+
+	for _, name := range []string{"", "..", "../1-2/idVendor", "/etc/passwd"} {
+		r := NewAttrRule(name, "foo")
+		t.FatalfIf(r.Compile() == nil, "Attribute outside of the device should be rejected (with: %q)", name)
+	}
+
+	// Attributes are read once per uevent
+	cache := newAttrCache(kObj)
+	value, ok := cache.get("idVendor")
+	t.FatalfIf(!ok || value != "058f", "Wrong cached attribute (got: %q)", value)
+	t.FatalfIf(os.Remove(filepath.Join(dir, "idVendor")) != nil, "Unable to remove fixture")
+	value, ok = cache.get("idVendor")
+	t.FatalfIf(!ok || value != "058f", "Attribute should be read from the cache (got: %q)", value)
+	t.FatalfIf(vendor.Evaluate(usb), "Removed attribute shouldn't match a new uevent")
+}
+
+func TestSubtreeRule(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	disk := "/devices/virtual/block/loop0"
+	uevent := func(devpath string) UEvent {
+		return UEvent{Action: ADD, KObj: devpath, Env: map[string]string{"DEVPATH": devpath}}
+	}
+
+	rule := NewSubtreeRule("/sys" + disk + "/")
+	t.FatalfIf(rule.Compile() != nil, "Subtree rule should compile")
+	t.FatalfIf(!rule.Evaluate(uevent(disk)), "Subtree rule should match the device itself")
+	t.FatalfIf(!rule.Evaluate(uevent(disk+"/loop0p1")), "Subtree rule should match a child")
+	t.FatalfIf(rule.Evaluate(uevent(disk+"0")), "Subtree rule shouldn't match a sibling with the same prefix")
+	t.FatalfIf(rule.Evaluate(uevent("/devices/virtual/block")), "Subtree rule shouldn't match the parent")
+
+	dotted := NewSubtreeRule("/devices/pci0000:00/0000:00:14.0")
+	t.FatalfIf(dotted.Evaluate(uevent("/devices/pci0000:00/0000:00:14x0")), "Path should be matched literally")
+
+	root := NewSubtreeRule("/")
+	t.FatalfIf(!root.Evaluate(uevent(disk)), "Root subtree should match everything")
+}
@@ -0,0 +1,29 @@
+package netlink
+
+// Metrics allow to observe the monitoring of an UEventConn (ie: to export Prometheus counters).
+// Each msg read from the socket is counted as received, then either as matched (delivered) or as dropped
+// (unparsable, filtered by Actions, the matcher or Dedup). Errors count overflows, read failures and
+// non-fatal errors sent on errs. Methods are called from the monitoring goroutine,
+// or concurrently by the parser workers of Monitor with UEventConn.ParseWorkers.
+type Metrics interface {
+	IncReceived()
+	IncMatched()
+	IncDropped()
+	IncError()
+}
+
+// NopMetrics is the default Metrics which ignores everything
+type NopMetrics struct{}
+
+func (NopMetrics) IncReceived() {}
+func (NopMetrics) IncMatched()  {}
+func (NopMetrics) IncDropped()  {}
+func (NopMetrics) IncError()    {}
+
+// metrics return the Metrics option or NopMetrics if not set (without allocation)
+func (c *UEventConn) metrics() Metrics {
+	if c.Metrics == nil {
+		return NopMetrics{}
+	}
+	return c.Metrics
+}
@@ -0,0 +1,67 @@
+package netlink
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+type fakeMetrics struct {
+	received, matched, dropped, errors int
+}
+
+func (m *fakeMetrics) IncReceived() { m.received++ }
+func (m *fakeMetrics) IncMatched()  { m.matched++ }
+func (m *fakeMetrics) IncDropped()  { m.dropped++ }
+func (m *fakeMetrics) IncError()    { m.errors++ }
+
+func TestMetrics(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	metrics := &fakeMetrics{}
+	conn := &UEventConn{TrustAllSenders: true, Metrics: metrics, Actions: []KObjAction{ADD, CHANGE}, Dedup: time.Minute}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	sendMsg(testing, conn, []byte("add@/devices/foo\x00ACTION=add\x00DEVPATH=/devices/foo\x00SUBSYSTEM=usb\x00"))       // matched
+	sendMsg(testing, conn, []byte("wrong uevent"))                                                                      // error and dropped
+	sendMsg(testing, conn, []byte("remove@/devices/foo\x00ACTION=remove\x00DEVPATH=/devices/foo\x00"))                  // dropped by actions
+	sendMsg(testing, conn, []byte("change@/devices/foo\x00ACTION=change\x00DEVPATH=/devices/foo\x00SUBSYSTEM=net\x00")) // dropped by matcher
+	sendMsg(testing, conn, []byte("add@/devices/foo\x00ACTION=add\x00DEVPATH=/devices/foo\x00SUBSYSTEM=usb\x00"))       // dropped by dedup
+	sendMsg(testing, conn, []byte("add@/devices/bar\x00ACTION=add\x00DEVPATH=/devices/bar\x00SUBSYSTEM=usb\x00"))       // matched
+
+	matched, matcher := 0, NewEnvRule("SUBSYSTEM", "usb")
+	for _, err := range conn.Events(&matcher) {
+		if err == nil {
+			if matched++; matched == 2 {
+				break
+			}
+		}
+	}
+	t.FatalfIf(metrics.received != 6, "Wrong received counter (got: %d, wanted: 6)", metrics.received)
+	t.FatalfIf(metrics.matched != 2, "Wrong matched counter (got: %d, wanted: 2)", metrics.matched)
+	t.FatalfIf(metrics.dropped != 4, "Wrong dropped counter (got: %d, wanted: 4)", metrics.dropped)
+	t.FatalfIf(metrics.errors != 1, "Wrong errors counter (got: %d, wanted: 1)", metrics.errors)
+
+	// Overflow and fatal read error
+	conn.isOverflow(syscall.ENOBUFS)
+	conn.SetReadDeadline(time.Now())
+	for range conn.Events(nil) {
+	}
+	t.FatalfIf(metrics.errors != 3, "Wrong errors counter (got: %d, wanted: 3)", metrics.errors)
+}
+
+func TestNopMetricsAllocs(t *testing.T) {
+	conn := &UEventConn{TrustAllSenders: true}
+	allocs := testing.AllocsPerRun(100, func() {
+		metrics := conn.metrics()
+		metrics.IncReceived()
+		metrics.IncMatched()
+		metrics.IncDropped()
+		metrics.IncError()
+	})
+	if allocs != 0 {
+		t.Fatalf("default metrics shouldn't allocate (got: %v)", allocs)
+	}
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+package netlink
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// NetNS identify a network namespace to create the netlink socket in (see: UEventConn.NetNS),
+// either by Path (ie: /var/run/netns/foo, /proc/<pid>/ns/net) or by an open file descriptor Fd if Path is empty.
+// Note: entering another network namespace requires CAP_SYS_ADMIN.
+type NetNS struct {
+	Path string
+	Fd   int
+}
+
+// open return a file descriptor of the namespace and a function to release it
+func (ns *NetNS) open() (int, func(), error) {
+	if ns.Path == "" {
+		return ns.Fd, func() {}, nil
+	}
+
+	f, err := os.Open(ns.Path)
+	if err != nil {
+		return -1, nil, fmt.Errorf("Unable to open network namespace, err: %w", err)
+	}
+	return int(f.Fd()), func() { f.Close() }, nil
+}
+
+func setns(fd int) error {
+	if _, _, errno := syscall.Syscall(sysSetns, uintptr(fd), syscall.CLONE_NEWNET, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// withNetNS call fn inside the network namespace ns, then restore the namespace of the current thread.
+// Resources created by fn (ie: sockets) stay attached to ns.
+func withNetNS(ns *NetNS, fn func() error) error {
+	target, release, err := ns.open()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Namespace is an attribute of the thread: goroutine must not be moved to another one meanwhile
+	runtime.LockOSThread()
+
+	current, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("Unable to open current network namespace, err: %w", err)
+	}
+	defer current.Close()
+
+	if err := setns(target); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("Unable to enter network namespace, err: %w", err)
+	}
+
+	fnErr := fn()
+
+	if err := setns(int(current.Fd())); err != nil {
+		// Keep the thread locked: it's terminated with the goroutine instead of being reused in a wrong namespace
+		return fmt.Errorf("Unable to restore network namespace, err: %w", err)
+	}
+	runtime.UnlockOSThread()
+
+	return fnErr
+}
@@ -0,0 +1,90 @@
+//go:build linux
+
+package netlink
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newNetNS create a new network namespace without entering it and return a file descriptor on it
+func newNetNS(t *testing.T) int {
+	t.Helper()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	current, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()))
+	if err != nil {
+		t.Fatal("unable to open current network namespace, err:", err)
+	}
+	defer current.Close()
+
+	if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+		t.Skip("unable to create network namespace, err:", err)
+	}
+	fd, err := syscall.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()), syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal("unable to open new network namespace, err:", err)
+	}
+	if err := setns(int(current.Fd())); err != nil {
+		t.Fatal("unable to restore network namespace, err:", err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	return fd
+}
+
+func TestConnectNetNS(t *testing.T) {
+	initial, err := os.Readlink("/proc/self/ns/net")
+	if err != nil {
+		t.Fatal("unable to read network namespace, err:", err)
+	}
+
+	// Current namespace by path
+	conn := &UEventConn{TrustAllSenders: true, NetNS: &NetNS{Path: "/proc/self/ns/net"}}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to connect inside current network namespace, err:", err)
+	}
+	sendMsg(t, conn, UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}.Bytes())
+	if uevent, err := conn.ReadUEvent(); err != nil || uevent.KObj != "/devices/foo" {
+		t.Fatalf("unable to read uevent (got: %v, err: %v)", uevent, err)
+	}
+	conn.Close()
+
+	// Other namespace by fd: the socket isn't reachable from the initial namespace
+	conn = &UEventConn{NetNS: &NetNS{Fd: newNetNS(t)}}
+	if err := conn.Connect(UdevEvent); err != nil {
+		t.Fatal("unable to connect inside other network namespace, err:", err)
+	}
+	defer conn.Close()
+
+	addr, err := syscall.Getsockname(conn.Fd)
+	if err != nil {
+		t.Fatal("unable to get netlink socket address, err:", err)
+	}
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		t.Fatal("unable to open netlink socket, err:", err)
+	}
+	defer syscall.Close(fd)
+	syscall.Sendto(fd, []byte("add@/devices/foo\x00"), 0, addr) // could be delivered to another socket with same port id
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.ReadMsg(); err != os.ErrDeadlineExceeded {
+		t.Fatal("socket should be created inside the other network namespace, got:", err)
+	}
+
+	if current, _ := os.Readlink("/proc/self/ns/net"); current != initial {
+		t.Fatalf("network namespace should be restored (got: %s, wanted: %s)", current, initial)
+	}
+
+	// Wrong namespace
+	conn = &UEventConn{NetNS: &NetNS{Path: "/nonexistent"}}
+	if err := conn.Connect(UdevEvent); err == nil {
+		conn.Close()
+		t.Fatal("connect should fail with unknown network namespace")
+	}
+}
@@ -0,0 +1,180 @@
+package netlink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// parseWorkersQueueSize is the number of msgs waiting for each parser worker
+const parseWorkersQueueSize = 64
+
+// compileMonitor is like compile but options only supported by Monitor are validated too
+func (c *UEventConn) compileMonitor(matcher Matcher) error {
+	if c.ParseWorkers > 1 && (c.RateLimit > 0 || c.DetectSeqNumGap) {
+		return errors.New("Wrong options, ParseWorkers can't be combined with RateLimit or DetectSeqNumGap")
+	}
+	return c.compile(matcher)
+}
+
+// parseJob is a msg read from the socket waiting to be handled by a parser worker
+type parseJob struct {
+	buf    *[]byte // pooled buffer, given back by the worker
+	sender *Sender
+}
+
+// monitorLoopParallel is like monitorLoop but msgs are handled (see: handleMsg) by ParseWorkers goroutines.
+// Msgs are dispatched by DEVPATH, so uevents of a device are always handled by the same worker, in order.
+// On quit or when the limit is reached, uevents being handled are dropped, otherwise they are delivered before exit.
+func (c *UEventConn) monitorLoopParallel(quit chan struct{}, closing <-chan struct{}, queue chan UEvent, errs chan error, matcher Matcher, limits *limits) (StopReason, error) {
+	var (
+		once sync.Once
+		stop = make(chan struct{}) // closed on quit, Close or when the limit is reached, to drop pending uevents
+	)
+	halt := func() {
+		once.Do(func() { close(stop) })
+	}
+
+	// Parser workers, one queue per worker to keep the order of each device
+	jobs := make([]chan parseJob, c.ParseWorkers)
+	results := make(chan UEvent, c.ParseWorkers)
+	wg := sync.WaitGroup{}
+	for i := range jobs {
+		jobs[i] = make(chan parseJob, parseWorkersQueueSize)
+		wg.Add(1)
+		go func(in chan parseJob) {
+			defer wg.Done()
+			for job := range in {
+				uevent := c.handleMsg(*job.buf, job.sender, matcher, errs)
+				c.putBuffer(job.buf)
+				if uevent == nil {
+					continue
+				}
+				select {
+				case results <- *uevent:
+				case <-stop:
+				}
+			}
+		}(jobs[i])
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Delivery in the order of results
+	limitReached := false
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		for uevent := range results {
+			select {
+			case <-stop:
+				continue // drain results until workers exit
+			default:
+			}
+			select {
+			case queue <- uevent:
+			case <-stop:
+				continue
+			}
+			if limits.matched() {
+				limitReached = true
+				halt()
+			}
+		}
+	}()
+
+	reason, err := c.dispatchMsgs(quit, closing, stop, jobs, errs, limits)
+	if reason == StopQuit || reason == StopClosed {
+		halt()
+	}
+	for _, j := range jobs {
+		close(j)
+	}
+	<-delivered
+
+	if limitReached {
+		return StopLimit, nil
+	}
+	return reason, err
+}
+
+// dispatchMsgs read msgs and send them to the worker of their device until quit, closing, stop, limits or a fatal error
+func (c *UEventConn) dispatchMsgs(quit chan struct{}, closing <-chan struct{}, stop chan struct{}, jobs []chan parseJob, errs chan error, limits *limits) (StopReason, error) {
+	for {
+		select {
+		case <-quit:
+			return StopQuit, nil
+		case <-closing:
+			return StopClosed, nil
+		case <-stop:
+			return StopLimit, nil
+		default:
+		}
+
+		timeout, expired := limits.wait(monitorPollTimeout)
+		if expired {
+			return StopTimeout, nil
+		}
+
+		ready, err := waitReadable(c.Fd, c.closeWakeFd(), timeout)
+		if err != nil {
+			return StopError, fmt.Errorf("Unable to check available uevent, err: %w", err)
+		}
+		if !ready {
+			continue // timeout reached, check quit again
+		}
+
+		buf, sender, err := c.readPooledMsg()
+		if c.isOverflow(err) {
+			errs <- ErrUEventOverflow
+			continue // kernel dropped uevents but the socket is still usable
+		}
+		if err != nil {
+			return StopError, c.readError(err)
+		}
+
+		worker := jobs[murmurHash2(msgDevPath(*buf), 0)%uint32(len(jobs))]
+		select {
+		case worker <- parseJob{buf: buf, sender: sender}:
+		case <-quit:
+			c.putBuffer(buf)
+			return StopQuit, nil
+		case <-closing:
+			c.putBuffer(buf)
+			return StopClosed, nil
+		case <-stop:
+			c.putBuffer(buf)
+			return StopLimit, nil
+		}
+	}
+}
+
+// msgDevPath return the devpath of a raw msg without parsing it, from the DEVPATH property of an udev event
+// or from the header of a kernel event ("action@devpath"), nil if not found. It's only used to dispatch msgs.
+func msgDevPath(msg []byte) []byte {
+	if !bytes.HasPrefix(msg, []byte("libudev\x00")) {
+		header, _, _ := bytes.Cut(msg, []byte{0})
+		_, devpath, _ := bytes.Cut(header, []byte("@"))
+		return devpath
+	}
+
+	if len(msg) < udevHeaderSize {
+		return nil
+	}
+	offset := binary.NativeEndian.Uint32(msg[16:])
+	if offset < udevHeaderSize || offset >= uint32(len(msg)) {
+		return nil
+	}
+	for properties := msg[offset:]; len(properties) > 0; {
+		var property []byte
+		property, properties, _ = bytes.Cut(properties, []byte{0})
+		if devpath, ok := bytes.CutPrefix(property, []byte("DEVPATH=")); ok {
+			return devpath
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package netlink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMsgDevPath(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	uevent := UEvent{Action: ADD, KObj: "/devices/virtual/mem/null", Env: map[string]string{"DEVPATH": "/devices/virtual/mem/null", "DEVPATH_OLD": "/devices/old"}}
+	for _, msg := range [][]byte{uevent.Bytes(), uevent.BytesUdev()} {
+		devpath := msgDevPath(msg)
+		t.FatalfIf(string(devpath) != uevent.KObj, "Wrong devpath (got: %q, wanted: %q)", devpath, uevent.KObj)
+	}
+
+	for _, msg := range [][]byte{nil, []byte("garbage"), []byte("libudev\x00truncated"), UEvent{Action: ADD, Env: map[string]string{"ID_DEVPATH": "x"}}.BytesUdev()[:udevHeaderSize+1]} {
+		t.FatalfIf(len(msgDevPath(msg)) != 0, "Devpath shouldn't be found in %q", msg)
+	}
+}
+
+func TestMonitorParseWorkers(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true, ParseWorkers: 4}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	const devices, events = 8, 10
+	queue := make(chan UEvent)
+	quit := conn.Monitor(queue, make(chan error, 1), NewActionMatcher(CHANGE))
+	defer func() {
+		close(quit)
+		waitStopReason(testing, conn)
+	}()
+
+	for i := 0; i < events; i++ {
+		for d := 0; d < devices; d++ {
+			kObj := fmt.Sprintf("/devices/virtual/mem/dev%d", d)
+			sample := UEvent{Action: CHANGE, KObj: kObj, Env: map[string]string{"DEVPATH": kObj, "N": strconv.Itoa(i)}}
+			sendMsg(testing, conn, sample.Bytes())
+		}
+	}
+	sendMsg(testing, conn, UEvent{Action: ADD, KObj: "/devices/filtered", Env: map[string]string{}}.Bytes())
+
+	last := make(map[string]int)
+	for n := 0; n < devices*events; n++ {
+		select {
+		case uevent := <-queue:
+			i, _ := strconv.Atoi(uevent.Env["N"])
+			prev, ok := last[uevent.KObj]
+			t.FatalfIf(ok && i != prev+1 || !ok && i != 0, "Uevents of %s should be ordered (got: %d after %d)", uevent.KObj, i, prev)
+			last[uevent.KObj] = i
+		case <-time.After(time.Second):
+			t.Fatalf("Missing uevents (got: %d, wanted: %d)", n, devices*events)
+		}
+	}
+	select {
+	case uevent := <-queue:
+		t.Fatalf("Unexpected uevent %s@%s", uevent.Action, uevent.KObj)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMonitorParseWorkersLimit(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true, ParseWorkers: 2, MatchedUEventLimit: 2}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	for d := 0; d < 4; d++ {
+		kObj := fmt.Sprintf("/devices/virtual/mem/dev%d", d)
+		sendMsg(testing, conn, UEvent{Action: ADD, KObj: kObj, Env: map[string]string{"DEVPATH": kObj}}.Bytes())
+	}
+
+	queue := make(chan UEvent, 4)
+	conn.Monitor(queue, make(chan error, 1), nil)
+	reason := waitStopReason(testing, conn)
+	t.FatalfIf(reason != StopLimit, "Monitoring should be stopped by limit (got: %s)", reason)
+	count := 0
+	for range queue {
+		count++
+	}
+	t.FatalfIf(count != 2, "Wrong number of uevents (got: %d, wanted: 2)", count)
+
+	conn.RateLimit = 10
+	errs := make(chan error, 1)
+	conn.Monitor(make(chan UEvent), errs, nil)
+	t.FatalfIf(<-errs == nil, "ParseWorkers shouldn't be combined with RateLimit")
+}
+
+// BenchmarkMonitorParseWorkers compare the throughput of Monitor with and without ParseWorkers,
+// with a matcher reading a sysfs attribute of each device
+func BenchmarkMonitorParseWorkers(b *testing.B) {
+	const devices = 64
+
+	root := b.TempDir()
+	defer func(path string) { sysfsPath = path }(sysfsPath)
+	sysfsPath = root
+
+	msgs := make([][]byte, devices)
+	for d := range msgs {
+		kObj := fmt.Sprintf("/devices/virtual/mem/dev%d", d)
+		if err := os.MkdirAll(filepath.Join(root, kObj), 0755); err != nil {
+			b.Fatal("unable to create fixture, err:", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, kObj, "vendor"), []byte("0x8086\n"), 0644); err != nil {
+			b.Fatal("unable to create fixture, err:", err)
+		}
+		msgs[d] = UEvent{Action: CHANGE, KObj: kObj, Env: map[string]string{"DEVPATH": kObj, "SUBSYSTEM": "mem"}}.BytesUdev()
+	}
+	matcher := NewAttrRule("vendor", "0x8086")
+
+	for _, workers := range []int{0, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			conn := &UEventConn{TrustAllSenders: true, ParseWorkers: workers, ReceiveBufferSize: 4 << 20}
+			if err := conn.Connect(UdevEvent); err != nil {
+				b.Fatal("unable to subscribe to netlink uevent, err:", err)
+			}
+			defer conn.Close()
+
+			addr, err := syscall.Getsockname(conn.Fd)
+			if err != nil {
+				b.Fatal("unable to get netlink socket address, err:", err)
+			}
+			fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_KOBJECT_UEVENT)
+			if err != nil {
+				b.Fatal("unable to open netlink socket, err:", err)
+			}
+			defer syscall.Close(fd)
+
+			queue := make(chan UEvent, 64)
+			quit := conn.Monitor(queue, make(chan error, 16), &matcher)
+			defer func() {
+				close(quit)
+				for conn.StopReason() == StopNone {
+					time.Sleep(time.Millisecond)
+				}
+			}()
+
+			b.ResetTimer()
+			go func() {
+				for i := 0; i < b.N; i++ {
+					syscall.Sendto(fd, msgs[i%devices], 0, addr) // blocking until the receive buffer has room
+				}
+			}()
+			for i := 0; i < b.N; i++ {
+				select {
+				case <-queue:
+				case <-time.After(5 * time.Second):
+					b.Fatalf("Missing uevents (got: %d, wanted: %d)", i, b.N)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package netlink
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Events of poll(2), not defined by the syscall package
+const (
+	pollIn   = 0x1
+	pollErr  = 0x8
+	pollHup  = 0x10
+	pollNval = 0x20
+)
+
+// pollFd is struct pollfd of poll(2)
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+// waitReadable block until fd is readable, wakeFd is readable (ignored if negative)
+// or timeout is reached (no timeout if negative).
+// It return true only if fd is readable, or in error (the next read return the error).
+// It uses ppoll(2), unlike select(2) it isn't limited to fds below FD_SETSIZE (1024).
+func waitReadable(fd, wakeFd int, timeout time.Duration) (bool, error) {
+	fds := []pollFd{{fd: int32(fd), events: pollIn}}
+	if wakeFd >= 0 {
+		fds = append(fds, pollFd{fd: int32(wakeFd), events: pollIn})
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var ts *syscall.Timespec
+		if timeout >= 0 {
+			remaining := time.Until(deadline)
+			if remaining < 0 {
+				remaining = 0
+			}
+			t := syscall.NsecToTimespec(remaining.Nanoseconds())
+			ts = &t
+		}
+
+		for i := range fds {
+			fds[i].revents = 0
+		}
+		n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&fds[0])), uintptr(len(fds)), uintptr(unsafe.Pointer(ts)), 0, 0, 0)
+		if errno == syscall.EINTR {
+			continue // ppoll(2) is never restarted after a signal handler
+		}
+		if errno != 0 {
+			return false, errno
+		}
+		if n == 0 {
+			return false, nil // timeout
+		}
+		if fds[0].revents&pollNval != 0 {
+			return false, syscall.EBADF
+		}
+		return fds[0].revents&(pollIn|pollErr|pollHup) != 0, nil
+	}
+}
+
+// waker allow to interrupt a waitReadable call from another goroutine (self-pipe trick)
+type waker struct {
+	r, w int
+}
+
+func newWaker() (*waker, error) {
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		return nil, err
+	}
+	return &waker{r: p[0], w: p[1]}, nil
+}
+
+// Wake make the read end of the pipe readable
+func (w *waker) Wake() {
+	syscall.Write(w.w, []byte{0})
+}
+
+func (w *waker) Close() {
+	syscall.Close(w.r)
+	syscall.Close(w.w)
+}
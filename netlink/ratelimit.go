@@ -0,0 +1,134 @@
+package netlink
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// rateLimitPendingSize is the max number of uevents waiting for a token, once full excess uevents are dropped
+const rateLimitPendingSize = 256
+
+type coalesceKey struct {
+	action KObjAction
+	kobj   string
+}
+
+// rateLimiter is a token bucket pacing the delivery of uevents (see: UEventConn.RateLimited for the policy).
+// A nil rateLimiter delivers everything at once.
+type rateLimiter struct {
+	rate    float64 // tokens per second
+	burst   float64
+	tokens  float64
+	last    time.Time
+	pending []UEvent
+	index   map[coalesceKey]int // position in pending
+
+	dropped, coalesced *uint64
+}
+
+// newRateLimiter return the rate limiter of the RateLimit option, nil if disabled
+func (c *UEventConn) newRateLimiter() *rateLimiter {
+	if c.RateLimit <= 0 {
+		return nil
+	}
+	burst := float64(c.RateBurst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:      c.RateLimit,
+		burst:     burst,
+		tokens:    burst,
+		last:      time.Now(),
+		index:     make(map[coalesceKey]int),
+		dropped:   &c.rateDropped,
+		coalesced: &c.rateCoalesced,
+	}
+}
+
+// refill add tokens earned since the last refill, up to burst
+func (r *rateLimiter) refill(now time.Time) {
+	if elapsed := now.Sub(r.last); elapsed > 0 {
+		r.tokens += elapsed.Seconds() * r.rate
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+	}
+	r.last = now
+}
+
+// add return true if e could be delivered now, otherwise e is pending (or coalesced, or dropped)
+func (r *rateLimiter) add(e UEvent, now time.Time) bool {
+	if r == nil {
+		return true
+	}
+
+	r.refill(now)
+	if len(r.pending) == 0 && r.tokens >= 1 {
+		r.tokens--
+		return true
+	}
+
+	key := coalesceKey{action: e.Action, kobj: e.KObj}
+	if i, ok := r.index[key]; ok {
+		r.pending[i] = e
+		atomic.AddUint64(r.coalesced, 1)
+		return false
+	}
+	if len(r.pending) >= rateLimitPendingSize {
+		atomic.AddUint64(r.dropped, 1)
+		return false
+	}
+	r.index[key] = len(r.pending)
+	r.pending = append(r.pending, e)
+	return false
+}
+
+// next return the next pending uevent if a token is available
+func (r *rateLimiter) next(now time.Time) (UEvent, bool) {
+	if r == nil || len(r.pending) == 0 {
+		return UEvent{}, false
+	}
+
+	r.refill(now)
+	if r.tokens < 1 {
+		return UEvent{}, false
+	}
+	r.tokens--
+
+	e := r.pending[0]
+	r.pending = r.pending[1:]
+	delete(r.index, coalesceKey{action: e.Action, kobj: e.KObj})
+	for key, i := range r.index {
+		r.index[key] = i - 1
+	}
+	return e, true
+}
+
+// wait return how long to wait for the next msg, up to max (negative means forever),
+// bounded by the time until the next token when uevents are pending
+func (r *rateLimiter) wait(max time.Duration, now time.Time) time.Duration {
+	if r == nil || len(r.pending) == 0 {
+		return max
+	}
+
+	r.refill(now)
+	untilToken := time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
+	if untilToken < 0 {
+		untilToken = 0
+	}
+	if max >= 0 && max < untilToken {
+		return max
+	}
+	return untilToken
+}
+
+// RateLimited return how many uevents were dropped and coalesced by the RateLimit option.
+// RateLimit is a token bucket of RateBurst tokens refilled at RateLimit tokens per second, each uevent delivered
+// by Monitor, MonitorWithReconnect or MonitorContext consumes a token. When the bucket is empty, the uevent waits in
+// a pending list delivered in order as soon as tokens are refilled: a pending uevent with the same action and kobj
+// is replaced by the new one (coalesced, the consumer gets the latest one only), otherwise the uevent is appended,
+// or dropped if 256 uevents are already pending. Pending uevents are lost when the monitoring stops.
+func (c *UEventConn) RateLimited() (dropped, coalesced uint64) {
+	return atomic.LoadUint64(&c.rateDropped), atomic.LoadUint64(&c.rateCoalesced)
+}
@@ -0,0 +1,111 @@
+package netlink
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefill(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{RateLimit: 10, RateBurst: 2} // a token every 100ms
+	rl := conn.newRateLimiter()
+	now := rl.last
+
+	uevent := func(i int) UEvent {
+		return UEvent{Action: ADD, KObj: fmt.Sprintf("/devices/%d", i)}
+	}
+
+	// Burst
+	t.FatalfIf(!rl.add(uevent(1), now) || !rl.add(uevent(2), now), "Burst should be delivered at once")
+	t.FatalfIf(rl.add(uevent(3), now), "Bucket should be empty after the burst")
+	_, ok := rl.next(now)
+	t.FatalfIf(ok, "No token should be available")
+	t.FatalfIf(rl.wait(time.Second, now) != 100*time.Millisecond, "Wrong wait, got: %s", rl.wait(time.Second, now))
+	t.FatalfIf(rl.wait(10*time.Millisecond, now) != 10*time.Millisecond, "Wait should be bounded by max")
+
+	// Refill
+	now = now.Add(50 * time.Millisecond)
+	_, ok = rl.next(now)
+	t.FatalfIf(ok, "Half a token isn't enough")
+	now = now.Add(50 * time.Millisecond)
+	e, ok := rl.next(now)
+	t.FatalfIf(!ok || e.KObj != "/devices/3", "Pending uevent should be delivered once refilled (got: %s)", e.KObj)
+
+	// New uevents wait behind pending ones, to keep the order
+	t.FatalfIf(rl.add(uevent(4), now), "Bucket should be empty")
+	now = now.Add(time.Hour)
+	t.FatalfIf(rl.add(uevent(5), now), "Uevent should wait behind pending one")
+	for _, expected := range []string{"/devices/4", "/devices/5"} {
+		e, ok := rl.next(now)
+		t.FatalfIf(!ok || e.KObj != expected, "Wrong pending uevent (got: %s, expected: %s)", e.KObj, expected)
+	}
+	// Tokens are capped by burst
+	_, ok = rl.next(now)
+	t.FatalfIf(ok, "No pending uevent")
+	t.FatalfIf(rl.tokens != 0, "Burst tokens should be consumed, got: %f", rl.tokens)
+
+	t.FatalfIf((*rateLimiter)(nil).add(uevent(1), now) != true, "Nil rate limiter should deliver everything")
+	t.FatalfIf((&UEventConn{}).newRateLimiter() != nil, "Rate limit should be disabled by default")
+}
+
+func TestRateLimiterCoalesce(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{RateLimit: 1}
+	rl := conn.newRateLimiter()
+	now := rl.last
+
+	rl.add(UEvent{Action: ADD, KObj: "/devices/foo"}, now)
+	for i := 0; i < 3; i++ {
+		rl.add(UEvent{Action: CHANGE, KObj: "/devices/foo", Env: map[string]string{"N": fmt.Sprint(i)}}, now)
+	}
+	rl.add(UEvent{Action: REMOVE, KObj: "/devices/foo"}, now)
+	for i := 0; i < rateLimitPendingSize; i++ {
+		rl.add(UEvent{Action: ADD, KObj: fmt.Sprintf("/devices/%d", i)}, now)
+	}
+
+	dropped, coalesced := conn.RateLimited()
+	t.FatalfIf(coalesced != 2 || dropped != 2, "Wrong counters (dropped: %d, coalesced: %d)", dropped, coalesced)
+
+	now = now.Add(time.Second)
+	e, _ := rl.next(now)
+	t.FatalfIf(e.Action != CHANGE || e.Env["N"] != "2", "Latest coalesced uevent should be delivered, got: %s %v", e.Action, e.Env)
+	now = now.Add(time.Second)
+	e, _ = rl.next(now)
+	t.FatalfIf(e.Action != REMOVE, "Order should be kept, got: %s", e.Action)
+}
+
+func TestMonitorRateLimit(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true, RateLimit: 20, RateBurst: 2} // a token every 50ms
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	for i := 0; i < 5; i++ {
+		sendMsg(testing, conn, UEvent{Action: ADD, KObj: fmt.Sprintf("/devices/%d", i), Env: map[string]string{}}.Bytes())
+	}
+
+	queue := make(chan UEvent)
+	quit := conn.Monitor(queue, make(chan error, 1), nil)
+	defer func() {
+		close(quit)
+		waitStopReason(testing, conn)
+	}()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		select {
+		case e := <-queue:
+			t.FatalfIf(e.KObj != fmt.Sprintf("/devices/%d", i), "Wrong order, got: %s", e.KObj)
+		case <-time.After(time.Second):
+			t.Fatal("Uevents should be delivered")
+		}
+	}
+	// 2 at once then 3 paced
+	elapsed := time.Since(start)
+	t.FatalfIf(elapsed < 140*time.Millisecond, "Delivery should be paced (elapsed: %s)", elapsed)
+}
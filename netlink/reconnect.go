@@ -0,0 +1,117 @@
+package netlink
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+const (
+	reconnectMinBackoff = 100 * time.Millisecond
+	reconnectMaxBackoff = 30 * time.Second // default of UEventConn.ReconnectMaxBackoff
+)
+
+// ReconnectError is an informational error sent on errs by MonitorWithReconnect on each reconnection attempt
+type ReconnectError struct {
+	Attempt int   // number of the attempt since the failure
+	Cause   error // fatal error which broke the connection
+	Err     error // error of the reconnection, nil if succeeded
+}
+
+func (e *ReconnectError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Unable to reconnect (attempt: %d), err: %v, cause: %v", e.Attempt, e.Err, e.Cause)
+	}
+	return fmt.Sprintf("Reconnected (attempt: %d), cause: %v", e.Attempt, e.Cause)
+}
+
+func (e *ReconnectError) Unwrap() error {
+	return e.Cause
+}
+
+// MonitorWithReconnect is like Monitor but on a fatal error, the socket is closed and connected again
+// with the same mode, then the monitoring resumes. Reconnection attempts are delayed with an
+// exponential backoff capped by ReconnectMaxBackoff, and each one is reported with a *ReconnectError on errs.
+// The matcher is compiled only once and limits are shared by all connections.
+// Like Monitor, queue and errs are closed when the worker exit.
+func (c *UEventConn) MonitorWithReconnect(queue chan UEvent, errs chan error, matcher Matcher) chan struct{} {
+	quit := make(chan struct{}, 1)
+	c.setStopReason(StopNone)
+
+	if err := c.compileMonitor(matcher); err != nil {
+		c.setStopReason(StopError)
+		quit <- struct{}{}
+		close(queue)
+		reportAndClose(errs, err)
+		return quit
+	}
+	closing, ok := c.acquireFd()
+	if !ok {
+		c.setStopReason(StopClosed)
+		quit <- struct{}{}
+		close(queue)
+		reportAndClose(errs, ErrConnClosed)
+		return quit
+	}
+
+	maxBackoff := c.ReconnectMaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = reconnectMaxBackoff
+	}
+
+	go func() {
+		defer close(errs)
+		defer close(queue)
+		defer c.releaseFd()
+
+		mode := Mode(c.Addr.Groups)
+		limits := c.newLimits()
+		for {
+			reason, cause := c.monitorLoop(quit, closing, queue, errs, matcher, limits)
+			if reason != StopError {
+				c.setStopReason(reason)
+				return
+			}
+
+			// The socket is replaced while the loop is still registered (see: acquireFd), so Close waits for it.
+			// Fd is written under fdMu because IsConnected, WithFilter and Close could read it meanwhile.
+			c.fdMu.Lock()
+			syscall.Close(c.Fd)
+			c.Fd = -1
+			c.fdMu.Unlock()
+			backoff := reconnectMinBackoff
+			for attempt := 1; ; attempt++ {
+				select {
+				case <-time.After(backoff):
+				case <-quit:
+					c.setStopReason(StopQuit)
+					return
+				case <-closing:
+					c.setStopReason(StopClosed)
+					return
+				}
+
+				err := c.connect(mode) // Fd is -1 on failure
+				reconnectErr := &ReconnectError{Attempt: attempt, Cause: cause, Err: err}
+				c.logger().Printf("netlink: %v", reconnectErr)
+				select {
+				case errs <- reconnectErr:
+				case <-quit:
+					c.setStopReason(StopQuit)
+					return
+				case <-closing:
+					c.setStopReason(StopClosed)
+					return
+				}
+				if err == nil {
+					break
+				}
+
+				if backoff *= 2; backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+			}
+		}
+	}()
+	return quit
+}
@@ -0,0 +1,191 @@
+package netlink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Record file format:
+// - header: "GOUDEV" followed by the format version byte
+// - records: timestamp (int64 unix nano) + length (uint32) + raw msg, integers are big endian
+const (
+	recordMagic   = "GOUDEV"
+	RecordVersion = 1
+)
+
+// maxRecordMsgSize is the max length of a recorded msg, uevents are far smaller (kernel ones are at most 2048 bytes,
+// see UEVENT_BUFFER_SIZE) so a bigger length comes from a corrupted file and mustn't be allocated
+const maxRecordMsgSize = 1 << 20
+
+// ErrRecording is sent on errs by Recorder.Monitor when another Recorder is already monitoring the conn
+var ErrRecording = errors.New("netlink connection already recorded")
+
+// Recorder write raw msg read by Monitor into a record file, to replay them later with a Replayer
+type Recorder struct {
+	conn *UEventConn
+	w    io.Writer
+	mu   sync.Mutex
+}
+
+// NewRecorder write the record file header into w and return a Recorder of the uevents read on conn
+func NewRecorder(conn *UEventConn, w io.Writer) (*Recorder, error) {
+	if _, err := w.Write(append([]byte(recordMagic), RecordVersion)); err != nil {
+		return nil, fmt.Errorf("Unable to write record header, err: %w", err)
+	}
+	return &Recorder{conn: conn, w: w}, nil
+}
+
+// Monitor run conn.Monitor and record each raw msg read before parsing and matching,
+// errors of writing are sent on errs without stopping the monitoring. Like conn.Monitor, queue and errs are closed at the end.
+// Only this run is recorded: next monitorings of conn aren't. ErrRecording is sent on errs if conn is already recorded.
+func (r *Recorder) Monitor(queue chan UEvent, errs chan error, matcher Matcher) chan struct{} {
+	onMsg := func(msg []byte) error {
+		return r.WriteMsg(time.Now(), msg)
+	}
+	if !r.conn.setOnMsg(onMsg) {
+		quit := make(chan struct{}, 1)
+		quit <- struct{}{}
+		close(queue)
+		reportAndClose(errs, ErrRecording)
+		return quit
+	}
+	quit := r.conn.Monitor(queue, errs, matcher)
+	if r.conn.StopReason() != StopNone {
+		r.conn.setOnMsg(nil) // not started (ie: wrong matcher)
+	}
+	return quit
+}
+
+// WriteMsg write a raw msg received at t as a record
+func (r *Recorder) WriteMsg(t time.Time, msg []byte) error {
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint64(header, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint32(header[8:], uint32(len(msg)))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.w.Write(append(header, msg...)); err != nil {
+		return fmt.Errorf("Unable to write record, err: %w", err)
+	}
+	return nil
+}
+
+// Replayer read a record file written by a Recorder
+type Replayer struct {
+	r io.Reader
+}
+
+// NewReplayer read and check the record file header from r
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	header := make([]byte, len(recordMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("Unable to read record header, err: %w", err)
+	}
+
+	if !bytes.Equal(header[:len(recordMagic)], []byte(recordMagic)) {
+		return nil, errors.New("Wrong record file format")
+	}
+
+	if version := header[len(recordMagic)]; version != RecordVersion {
+		return nil, fmt.Errorf("Unsupported record version (got: %d, wanted: %d)", version, RecordVersion)
+	}
+	return &Replayer{r: r}, nil
+}
+
+// ReadMsg return the next recorded raw msg and its receive time, io.EOF is returned at the end of the records
+func (p *Replayer) ReadMsg() (time.Time, []byte, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return time.Time{}, nil, fmt.Errorf("Truncated record header, err: %w", err)
+		}
+		return time.Time{}, nil, err
+	}
+
+	t := time.Unix(0, int64(binary.BigEndian.Uint64(header)))
+	length := binary.BigEndian.Uint32(header[8:])
+	if length > maxRecordMsgSize {
+		return t, nil, fmt.Errorf("Wrong record length (got: %d, wanted at most: %d)", length, maxRecordMsgSize)
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(p.r, msg); err != nil {
+		return t, nil, fmt.Errorf("Truncated record, err: %w", err)
+	}
+	return t, msg, nil
+}
+
+// Replay run in background a worker which parse recorded msg and send uevents matched by the matcher on queue,
+// either with the original timing between msg or as fast as possible.
+// Like Monitor, queue and errs are closed when the worker exit: at the end of the records, or when quit is closed.
+func (p *Replayer) Replay(queue chan UEvent, errs chan error, matcher Matcher, originalTiming bool) chan struct{} {
+	quit := make(chan struct{}, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(queue)
+
+		// report send err on errs, it return false if quit is closed meanwhile
+		report := func(err error) bool {
+			select {
+			case errs <- err:
+				return true
+			case <-quit:
+				return false
+			}
+		}
+
+		if matcher != nil {
+			if err := matcher.Compile(); err != nil {
+				report(fmt.Errorf("Wrong matcher, err: %w", err))
+				return
+			}
+		}
+
+		var previous time.Time
+		for {
+			t, msg, err := p.ReadMsg()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				report(err)
+				return
+			}
+
+			// Wait the same duration than between the original msg
+			if originalTiming && !previous.IsZero() && t.After(previous) {
+				select {
+				case <-time.After(t.Sub(previous)):
+				case <-quit:
+					return
+				}
+			}
+			previous = t
+
+			uevent, err := ParseUEvent(msg)
+			if err != nil {
+				if !report(fmt.Errorf("Unable to parse uevent, err: %w", err)) {
+					return
+				}
+				continue // Drop uevent if not known
+			}
+			uevent.ReceivedAt = t // original receive time
+
+			if matcher != nil && !matcher.Evaluate(*uevent) {
+				continue
+			}
+
+			select {
+			case queue <- *uevent:
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return quit
+}
@@ -0,0 +1,159 @@
+package netlink
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	samples := []UEvent{
+		{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"SUBSYSTEM": "usb"}},
+		{Action: CHANGE, KObj: "/devices/foo", Env: map[string]string{"SUBSYSTEM": "usb"}},
+		{Action: REMOVE, KObj: "/devices/foo", Env: map[string]string{"SUBSYSTEM": "usb"}},
+	}
+
+	var file bytes.Buffer
+	recorder, err := NewRecorder(nil, &file)
+	t.FatalfIf(err != nil, "Unable to create recorder, err: %v", err)
+
+	start := time.Now()
+	for i, s := range samples {
+		// Mix kernel and libudev frames
+		raw := s.Bytes()
+		if i%2 == 1 {
+			raw = s.BytesUdev()
+		}
+		err := recorder.WriteMsg(start.Add(time.Duration(i)*50*time.Millisecond), raw)
+		t.FatalfIf(err != nil, "Unable to record msg, err: %v", err)
+	}
+	record := file.Bytes()
+
+	for _, originalTiming := range []bool{false, true} {
+		replayer, err := NewReplayer(bytes.NewReader(record))
+		t.FatalfIf(err != nil, "Unable to create replayer, err: %v", err)
+
+		queue := make(chan UEvent)
+		begin := time.Now()
+		replayer.Replay(queue, make(chan error), NewActionMatcher(ADD, REMOVE), originalTiming)
+
+		var got []KObjAction
+		for uevent := range queue {
+			got = append(got, uevent.Action)
+			if uevent.Action == REMOVE {
+				t.FatalfIf(!uevent.ReceivedAt.Equal(start.Add(100*time.Millisecond)), "ReceivedAt should be the recorded time, got: %s", uevent.ReceivedAt)
+			}
+		}
+		t.FatalfIf(len(got) != 2 || got[0] != ADD || got[1] != REMOVE, "Wrong replayed uevents (got: %v)", got)
+
+		elapsed := time.Since(begin)
+		t.FatalfIf(originalTiming && elapsed < 100*time.Millisecond, "Original timing should be respected (elapsed: %s)", elapsed)
+		t.FatalfIf(!originalTiming && elapsed > 50*time.Millisecond, "Replay should be as fast as possible (elapsed: %s)", elapsed)
+	}
+
+	_, err = NewReplayer(bytes.NewReader([]byte("GOUDEV\x02")))
+	t.FatalfIf(err == nil, "Unknown record version should be rejected")
+	_, err = NewReplayer(bytes.NewReader([]byte("foobar\x01")))
+	t.FatalfIf(err == nil, "Wrong record file format should be rejected")
+
+	replayer, _ := NewReplayer(bytes.NewReader(record[:len(record)-3]))
+	errs := make(chan error, 1)
+	queue := make(chan UEvent, 3)
+	replayer.Replay(queue, errs, nil, false)
+	for range queue {
+	}
+	t.FatalfIf(len(errs) != 1, "Truncated record should be reported")
+	<-errs
+	_, more := <-errs
+	t.FatalfIf(more, "Errs should be closed at the end of the records")
+}
+
+func TestReplayerWrongLength(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	// Header claiming a 4 GiB msg
+	record := append([]byte("GOUDEV\x01"), 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff)
+	replayer, err := NewReplayer(bytes.NewReader(record))
+	t.FatalfIf(err != nil, "Unable to create replayer, err: %v", err)
+	_, msg, err := replayer.ReadMsg()
+	t.FatalfIf(err == nil || msg != nil || !strings.Contains(err.Error(), "Wrong record length"), "Too long record should be rejected (got: %d bytes), err: %v", len(msg), err)
+}
+
+func TestReplayQuit(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	// Unparsable msgs are reported on errs, which isn't read
+	var file bytes.Buffer
+	recorder, err := NewRecorder(nil, &file)
+	t.FatalfIf(err != nil, "Unable to create recorder, err: %v", err)
+	for i := 0; i < 3; i++ {
+		t.FatalfIf(recorder.WriteMsg(time.Now(), []byte("garbage")) != nil, "Unable to record msg")
+	}
+
+	replayer, err := NewReplayer(&file)
+	t.FatalfIf(err != nil, "Unable to create replayer, err: %v", err)
+	queue, errs := make(chan UEvent), make(chan error)
+	quit := replayer.Replay(queue, errs, nil, false)
+	close(quit)
+
+	select {
+	case _, more := <-queue:
+		t.FatalfIf(more, "No uevent should be replayed")
+	case <-time.After(time.Second):
+		t.Fatalf("Replay should stop on quit while reporting an error")
+	}
+	for range errs {
+	}
+}
+
+func TestRecorderMonitor(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	var file bytes.Buffer
+	recorder, err := NewRecorder(conn, &file)
+	t.FatalfIf(err != nil, "Unable to create recorder, err: %v", err)
+
+	sample := UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"ACTION": "add"}}
+	sendMsg(testing, conn, sample.Bytes())
+
+	queue, errs := make(chan UEvent), make(chan error)
+	quit := recorder.Monitor(queue, errs, nil)
+	<-queue
+
+	// Another recorder of the same conn is rejected
+	other, err := NewRecorder(conn, io.Discard)
+	t.FatalfIf(err != nil, "Unable to create recorder, err: %v", err)
+	otherQueue, otherErrs := make(chan UEvent), make(chan error)
+	other.Monitor(otherQueue, otherErrs, nil)
+	t.FatalfIf(<-otherErrs != ErrRecording, "Conn already recorded should be reported")
+
+	close(quit)
+	for range errs {
+	}
+	waitStopReason(testing, conn) // the worker shouldn't read the socket reusing the fd once closed
+	recorded := file.Len()
+
+	// Next monitorings aren't recorded
+	queue, errs = make(chan UEvent), make(chan error)
+	quit = conn.Monitor(queue, errs, nil)
+	sendMsg(testing, conn, sample.Bytes())
+	<-queue
+	close(quit)
+	for range errs {
+	}
+	t.FatalfIf(file.Len() != recorded, "Monitor after a recorded run shouldn't record (got: %d bytes, wanted: %d)", file.Len(), recorded)
+
+	replayer, err := NewReplayer(&file)
+	t.FatalfIf(err != nil, "Unable to read record, err: %v", err)
+	_, msg, err := replayer.ReadMsg()
+	t.FatalfIf(err != nil || !bytes.Equal(msg, sample.Bytes()), "Wrong recorded msg (got: %q), err: %v", msg, err)
+}
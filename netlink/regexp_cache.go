@@ -0,0 +1,39 @@
+package netlink
+
+import (
+	"regexp"
+	"sync"
+)
+
+// maxCachedRegexps bound the memory of the cache, ie: when rules are often reloaded with new patterns
+const maxCachedRegexps = 4096
+
+// regexpCache share compiled regexps between rules, a *regexp.Regexp being safe for concurrent use
+var regexpCache = struct {
+	sync.Mutex
+	regexps map[string]*regexp.Regexp
+}{regexps: make(map[string]*regexp.Regexp)}
+
+// compileRegexp is regexp.Compile with a cache keyed by the pattern, so identical patterns are compiled once
+// (ie: many rules on the same subsystem, or rules compiled again on reload). Errors aren't cached.
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	regexpCache.Lock()
+	reg, ok := regexpCache.regexps[pattern]
+	regexpCache.Unlock()
+	if ok {
+		return reg, nil
+	}
+
+	reg, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	regexpCache.Lock()
+	defer regexpCache.Unlock()
+	if len(regexpCache.regexps) >= maxCachedRegexps {
+		regexpCache.regexps = make(map[string]*regexp.Regexp) // start again rather than tracking usage
+	}
+	regexpCache.regexps[pattern] = reg
+	return reg, nil
+}
@@ -0,0 +1,88 @@
+package netlink
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestCompileRegexpCache(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	reg1, err := compileRegexp("^usb[0-9]+$")
+	t.FatalfIf(err != nil, "Unable to compile regexp, err: %v", err)
+	reg2, _ := compileRegexp("^usb[0-9]+$")
+	t.FatalfIf(reg1 != reg2, "Identical patterns should be compiled once")
+
+	_, err = compileRegexp("^usb(")
+	t.FatalfIf(err == nil, "Wrong pattern should return an error")
+}
+
+func TestRuleDefinitionsCompileInPlace(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	rules := RuleDefinitions{}
+	rules.AddRule(NewSubsystemRule("usb"))
+	rules.AddRule(NewSubsystemRule("usb"))
+	t.FatalfIf(rules.Compile() != nil, "Rules should compile")
+	t.FatalfIf(rules.Rules[0].rule == nil || rules.Rules[1].rule == nil, "Rules should be compiled in place")
+	t.FatalfIf(rules.Rules[0].rule.Env["SUBSYSTEM"] != rules.Rules[1].rule.Env["SUBSYSTEM"], "Identical patterns should share the compiled regexp")
+
+	// Compile again after a change
+	t.FatalfIf(rules.Compile() != nil, "Compile should be idempotent")
+	rules.Rules[1].Env["SUBSYSTEM"] = "^block$"
+	t.FatalfIf(rules.Compile() != nil, "Rules should compile again")
+	t.FatalfIf(!rules.Evaluate(UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "block"}}), "Changed rule should be evaluated")
+
+	// A wrong rule doesn't match anything
+	rules.Rules[1].Env["SUBSYSTEM"] = "^block("
+	t.FatalfIf(rules.Compile() == nil, "Wrong rule should return an error")
+	t.FatalfIf(rules.Rules[1].rule != nil, "Wrong rule shouldn't stay compiled")
+}
+
+func TestCompileConcurrently(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rules := newLargeRuleDefinitions(50)
+			errs <- rules.Compile()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.FatalfIf(err != nil, "Rules should compile concurrently, err: %v", err)
+	}
+}
+
+// newLargeRuleDefinitions return n rules, with patterns repeated like in a real rule file
+func newLargeRuleDefinitions(n int) RuleDefinitions {
+	rules := RuleDefinitions{}
+	for i := 0; i < n; i++ {
+		action := "add|remove"
+		rules.AddRule(RuleDefinition{
+			Action: &action,
+			Env: map[string]string{
+				"SUBSYSTEM": "^(usb|block|net)$",
+				"DEVPATH":   fmt.Sprintf("^/devices/pci0000:00/.*/usb%d/", i%10),
+			},
+		})
+	}
+	return rules
+}
+
+func BenchmarkRuleDefinitionsCompile(b *testing.B) {
+	rules := newLargeRuleDefinitions(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := rules.Compile(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
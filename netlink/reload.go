@@ -0,0 +1,86 @@
+package netlink
+
+import (
+	"sync/atomic"
+)
+
+// ReloadableMatcher is a Matcher whose rules could be replaced while a monitoring is running, ie: on SIGHUP:
+//
+//	matcher := netlink.NewReloadableMatcher(rules)
+//	quit := conn.Monitor(queue, errs, matcher)
+//	...
+//	rules, err := netlink.LoadRules(path)
+//	if err == nil {
+//		err = matcher.Reload(rules)
+//	}
+//
+// Each evaluation uses either the previous or the next matcher, never a partially compiled one.
+// A nil matcher match everything, like a monitoring without matcher.
+type ReloadableMatcher struct {
+	current atomic.Pointer[matcherHolder]
+}
+
+// matcherHolder allow to store a nil Matcher in an atomic.Pointer
+type matcherHolder struct {
+	matcher Matcher
+}
+
+// NewReloadableMatcher return a ReloadableMatcher evaluating matcher until the next Reload
+func NewReloadableMatcher(matcher Matcher) *ReloadableMatcher {
+	m := &ReloadableMatcher{}
+	m.current.Store(&matcherHolder{matcher: matcher})
+	return m
+}
+
+// Reload compile matcher then use it for next evaluations. On error the current matcher is kept.
+// The previous matcher mustn't be modified after Reload, as an evaluation could still be running with it.
+func (m *ReloadableMatcher) Reload(matcher Matcher) error {
+	if matcher != nil {
+		if err := matcher.Compile(); err != nil {
+			return err
+		}
+	}
+	m.current.Store(&matcherHolder{matcher: matcher})
+	return nil
+}
+
+// Matcher return the current matcher
+func (m *ReloadableMatcher) Matcher() Matcher {
+	if h := m.current.Load(); h != nil {
+		return h.matcher
+	}
+	return nil
+}
+
+// Compile compile the current matcher
+func (m *ReloadableMatcher) Compile() error {
+	if matcher := m.Matcher(); matcher != nil {
+		return matcher.Compile()
+	}
+	return nil
+}
+
+// Evaluate return true if the current matcher evaluate the uevent
+func (m *ReloadableMatcher) Evaluate(e UEvent) bool {
+	matcher := m.Matcher()
+	return matcher == nil || matcher.Evaluate(e)
+}
+
+// EvaluateAction return true if the current matcher evaluate the action
+func (m *ReloadableMatcher) EvaluateAction(a KObjAction) bool {
+	matcher := m.Matcher()
+	return matcher == nil || matcher.EvaluateAction(a)
+}
+
+// EvaluateEnv return true if the current matcher evaluate the env
+func (m *ReloadableMatcher) EvaluateEnv(e map[string]string) bool {
+	matcher := m.Matcher()
+	return matcher == nil || matcher.EvaluateEnv(e)
+}
+
+func (m *ReloadableMatcher) String() string {
+	if matcher := m.Matcher(); matcher != nil {
+		return "reloadable ( " + matcher.String() + " )"
+	}
+	return "reloadable ( any )"
+}
@@ -0,0 +1,96 @@
+package netlink
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReloadableMatcher(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	usb := UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb"}}
+	block := UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "block"}}
+
+	usbRule := NewSubsystemRule("usb")
+	matcher := NewReloadableMatcher(&usbRule)
+	t.FatalfIf(matcher.Compile() != nil, "Matcher should compile")
+	t.FatalfIf(!matcher.Evaluate(usb) || matcher.Evaluate(block), "Initial matcher should be evaluated")
+
+	blockRule := NewSubsystemRule("block")
+	t.FatalfIf(matcher.Reload(&blockRule) != nil, "Matcher should be reloaded")
+	t.FatalfIf(matcher.Evaluate(usb) || !matcher.Evaluate(block), "Reloaded matcher should be evaluated")
+
+	wrong := RuleDefinition{Env: map[string]string{"SUBSYSTEM": "("}}
+	t.FatalfIf(matcher.Reload(&wrong) == nil, "Wrong matcher should be rejected")
+	t.FatalfIf(!matcher.Evaluate(block), "Current matcher should be kept on error")
+
+	t.FatalfIf(matcher.Reload(nil) != nil || !matcher.Evaluate(usb) || !matcher.EvaluateEnv(nil), "Nil matcher should match everything")
+	t.FatalfIf(matcher.String() != "reloadable ( any )", "Wrong string (got: %s)", matcher.String())
+}
+
+func TestReloadWhileEvaluating(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	usb := UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb"}}
+	matcher := NewReloadableMatcher(nil)
+
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					matcher.Evaluate(usb)
+					matcher.EvaluateAction(ADD)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		rules := &RuleDefinitions{}
+		rules.AddRule(NewSubsystemRule("usb", "block"))
+		rules.AddRule(NewActionRule(ADD, REMOVE))
+		err := matcher.Reload(rules)
+		t.FatalfIf(err != nil, "Unable to reload, err: %v", err)
+	}
+	close(stop)
+	wg.Wait()
+	t.FatalfIf(!matcher.Evaluate(usb), "Last matcher should be evaluated")
+}
+
+func TestClientReload(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	client := new(Client)
+	client.Conn.TrustAllSenders = true
+	t.FatalfIf(client.Reload(nil) == nil, "Reload should fail before Subscribe")
+
+	err := client.Open(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to open client, err: %v", err)
+	defer client.Close()
+
+	usbRule := NewSubsystemRule("usb")
+	queue := client.Subscribe(&usbRule)
+
+	blockRule := NewSubsystemRule("block")
+	t.FatalfIf(client.Reload(&blockRule) != nil, "Matcher should be reloaded")
+	t.FatalfIf(client.Reload(&RuleDefinition{Env: map[string]string{"SUBSYSTEM": "("}}) == nil, "Wrong matcher should be rejected")
+
+	sendMsg(testing, &client.Conn, UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"SUBSYSTEM": "usb"}}.Bytes())
+	sendMsg(testing, &client.Conn, UEvent{Action: ADD, KObj: "/devices/bar", Env: map[string]string{"SUBSYSTEM": "block"}}.Bytes())
+	select {
+	case uevent := <-queue:
+		t.FatalfIf(uevent.KObj != "/devices/bar", "Reloaded matcher should be used by the monitoring (got: %s)", uevent.KObj)
+	case err := <-client.Errors():
+		t.Fatal("Unexpected error:", err)
+	case <-time.After(time.Second):
+		t.Fatal("Uevent should be received")
+	}
+}
@@ -0,0 +1,125 @@
+package netlink
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// RoutePolicy determines what a Router does when the channel of a route is full
+type RoutePolicy int
+
+const (
+	// RouteDrop drop the uevent for the full route only, so a slow consumer doesn't block the others (default).
+	// Dropped uevents are counted by Router.Dropped.
+	RouteDrop RoutePolicy = iota
+	// RouteBlock wait for the consumer of the full route, so no uevent is lost but a slow consumer
+	// delays every route (and the reading of the socket, which could overflow).
+	RouteBlock
+)
+
+const defaultRouteBufferSize = 16
+
+type route struct {
+	matcher Matcher
+	queue   chan UEvent
+	dropped uint64
+}
+
+// Router fan out uevents read once from a single UEventConn to a channel per matcher, ie: USB uevents on one
+// channel and block uevents on another, without opening many sockets. An uevent is sent to every route whose
+// matcher pass (a nil matcher pass everything), so its Env map is shared between routes and shouldn't be modified.
+// BufferSize and Policy should be set before Monitor.
+type Router struct {
+	BufferSize int         // capacity of each route channel (default: 16)
+	Policy     RoutePolicy // behavior when a route channel is full (default: RouteDrop)
+
+	conn   *UEventConn
+	routes map[string]*route
+}
+
+// NewRouter return a Router of the uevents read on conn to a route per named matcher
+func NewRouter(conn *UEventConn, matchers map[string]Matcher) *Router {
+	routes := make(map[string]*route, len(matchers))
+	for name, matcher := range matchers {
+		routes[name] = &route{matcher: matcher}
+	}
+	return &Router{conn: conn, routes: routes}
+}
+
+// Route return the channel of the named route, nil if the route is unknown or if Monitor isn't called yet.
+// The channel is closed when the monitoring stops.
+func (r *Router) Route(name string) <-chan UEvent {
+	if rt, ok := r.routes[name]; ok {
+		return rt.queue
+	}
+	return nil
+}
+
+// Dropped return how many uevents were dropped for the named route because its channel was full
+func (r *Router) Dropped(name string) uint64 {
+	if rt, ok := r.routes[name]; ok {
+		return atomic.LoadUint64(&rt.dropped)
+	}
+	return 0
+}
+
+// Monitor run conn.Monitor in background and dispatch uevents to the routes, use Route to get their channel.
+// Options of conn apply to every uevent read (ie: MatchedUEventLimit counts uevents before routing).
+// Like conn.Monitor, errs and the route channels are closed when the worker exit, close the returned chan to stop it.
+func (r *Router) Monitor(errs chan error) chan struct{} {
+	size := r.BufferSize
+	if size <= 0 {
+		size = defaultRouteBufferSize
+	}
+	for _, rt := range r.routes {
+		rt.queue = make(chan UEvent, size)
+	}
+
+	for name, rt := range r.routes {
+		if rt.matcher == nil {
+			continue
+		}
+		if err := rt.matcher.Compile(); err != nil {
+			quit := make(chan struct{}, 1)
+			r.conn.setStopReason(StopError)
+			quit <- struct{}{}
+			r.closeRoutes()
+			reportAndClose(errs, fmt.Errorf("Wrong matcher of route %s, err: %w", name, err))
+			return quit
+		}
+	}
+
+	queue := make(chan UEvent)
+	quit := r.conn.Monitor(queue, errs, nil)
+	go func() {
+		defer r.closeRoutes()
+		for uevent := range queue {
+			r.dispatch(uevent)
+		}
+	}()
+	return quit
+}
+
+func (r *Router) dispatch(uevent UEvent) {
+	for _, rt := range r.routes {
+		if rt.matcher != nil && !rt.matcher.Evaluate(uevent) {
+			continue
+		}
+
+		if r.Policy == RouteBlock {
+			rt.queue <- uevent
+			continue
+		}
+		select {
+		case rt.queue <- uevent:
+		default:
+			atomic.AddUint64(&rt.dropped, 1)
+		}
+	}
+}
+
+func (r *Router) closeRoutes() {
+	for _, rt := range r.routes {
+		close(rt.queue)
+	}
+}
@@ -0,0 +1,80 @@
+package netlink
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRouter(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to connect, err: %v", err)
+	defer conn.Close()
+
+	usb, block := NewSubsystemRule("usb"), NewSubsystemRule("block")
+	router := NewRouter(conn, map[string]Matcher{
+		"usb":   &RuleDefinitions{Rules: []RuleDefinition{usb}},
+		"block": &RuleDefinitions{Rules: []RuleDefinition{block}},
+		"slow":  nil, // never consumed
+	})
+	router.BufferSize = 2
+	t.FatalfIf(router.Route("usb") != nil, "Route shouldn't exist before Monitor")
+
+	errs := make(chan error, 10)
+	quit := router.Monitor(errs)
+
+	for _, subsystem := range []string{"usb", "block", "usb", "block"} {
+		sendMsg(testing, conn, UEvent{Action: ADD, KObj: "/devices/" + subsystem, Env: map[string]string{"SUBSYSTEM": subsystem}}.Bytes())
+	}
+
+	receive := func(name string) UEvent {
+		select {
+		case uevent := <-router.Route(name):
+			return uevent
+		case err := <-errs:
+			t.Fatal("Unexpected error:", err)
+		case <-time.After(time.Second):
+			t.Fatalf("Route %s should receive an uevent (dropped: %d, stop: %s)", name, router.Dropped(name), conn.StopReason())
+		}
+		return UEvent{}
+	}
+
+	// A full route ("slow") doesn't block the others
+	for i := 0; i < 2; i++ {
+		for _, name := range []string{"usb", "block"} {
+			uevent := receive(name)
+			t.FatalfIf(uevent.Subsystem() != name, "Wrong uevent on %s route, got: %s", name, uevent.Subsystem())
+		}
+	}
+
+	close(quit)
+	for _, name := range []string{"usb", "block"} {
+		select {
+		case _, more := <-router.Route(name):
+			t.FatalfIf(more, "Route %s should be empty and closed", name)
+		case <-time.After(time.Second):
+			t.Fatalf("Route %s should be closed", name)
+		}
+	}
+	waitStopReason(testing, conn)
+
+	// Routes are closed once every uevent is dispatched
+	t.FatalfIf(router.Dropped("slow") != 2, "2 uevents should be dropped on slow route, got: %d", router.Dropped("slow"))
+	t.FatalfIf(router.Dropped("usb") != 0, "No uevent should be dropped on usb route, got: %d", router.Dropped("usb"))
+}
+
+func TestRouterWrongMatcher(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true}
+	router := NewRouter(conn, map[string]Matcher{"wrong": &RuleDefinitions{Rules: []RuleDefinition{{Env: map[string]string{"A": "("}}}}})
+
+	errs := make(chan error, 1)
+	router.Monitor(errs)
+	t.FatalfIf(<-errs == nil, "Wrong matcher should be reported")
+	_, more := <-router.Route("wrong")
+	t.FatalfIf(more, "Route should be closed")
+	t.FatalfIf(conn.StopReason() != StopError, "Wrong stop reason, got: %s", conn.StopReason())
+}
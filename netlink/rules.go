@@ -0,0 +1,223 @@
+package netlink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadRules allow to load matcher rules from a JSON or YAML file (see: "matcher.sample" and "matcher.sample.yaml").
+// The format is detected by the extension of the file (".json", ".yaml" or ".yml"),
+// otherwise by its content (a JSON document starts with '{').
+// References to environment variables in patterns (ie: "${HOST_BUS}") are expanded (see: RuleDefinitions.Expand).
+func LoadRules(path string) (*RuleDefinitions, error) {
+	stream, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read rules from \"%s\", err: %w", path, err)
+	}
+
+	if len(bytes.TrimSpace(stream)) == 0 {
+		return nil, fmt.Errorf("Empty, no rules provided in \"%s\"", path)
+	}
+
+	rules, err := ParseRules(stream, isYAMLRules(path, stream))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load rules from \"%s\", err: %w", path, err)
+	}
+	if err := rules.Expand(os.LookupEnv); err != nil {
+		return nil, fmt.Errorf("Unable to load rules from \"%s\", err: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Expand replace references to variables in patterns of the rules (action, env and attribute values, device and tag)
+// by their value returned by lookup (ie: os.LookupEnv), so a rule file could be shared between hosts.
+// A reference is "${NAME}", an undefined variable is an error. "$$" is an escaped "$" (ie: "$${NAME}" is a literal)
+// and any other "$" is kept as is, so regexps anchors don't need escaping. Values are inserted as is, they aren't
+// quoted for regexps.
+// It must be called before Compile.
+func (rs *RuleDefinitions) Expand(lookup func(name string) (string, bool)) error {
+	for i := range rs.Rules {
+		if err := rs.Rules[i].expand(lookup); err != nil {
+			return fmt.Errorf("Unable to expand rule #%d, err: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// expand replace references to variables in the patterns of the rule (see: RuleDefinitions.Expand)
+func (r *RuleDefinition) expand(lookup func(name string) (string, bool)) error {
+	var err error
+	expand := func(s string) string {
+		if err != nil {
+			return s
+		}
+		var expanded string
+		expanded, err = expandVars(s, lookup)
+		return expanded
+	}
+
+	if r.Action != nil {
+		action := expand(*r.Action)
+		r.Action = &action
+	}
+	r.Env = expandValues(r.Env, expand)
+	r.Attrs = expandValues(r.Attrs, expand)
+	if r.Device != nil {
+		r.Device = &DeviceType{Subsystem: expand(r.Device.Subsystem), DevType: expand(r.Device.DevType)}
+	}
+	r.Tag = expand(r.Tag)
+	return err
+}
+
+// expandValues return a copy of the map with expanded values, nil if m is nil
+func expandValues(m map[string]string, expand func(string) string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	expanded := make(map[string]string, len(m))
+	for k, v := range m {
+		expanded[k] = expand(v)
+	}
+	return expanded
+}
+
+// expandVars replace "${NAME}" references in s by the value of lookup. "$$" is an escaped "$" wherever it is
+// (so "$${NAME}" is a literal "${NAME}"), any other "$" (ie: regexp anchors) is kept as is.
+func expandVars(s string, lookup func(name string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+
+		switch {
+		case strings.HasPrefix(s, "$$"):
+			b.WriteByte('$')
+			s = s[2:]
+		case strings.HasPrefix(s, "${"):
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				return "", fmt.Errorf("Wrong variable reference, missing '}' (got: %q)", s)
+			}
+			name := s[2:end]
+			if !isVarName(name) {
+				return "", fmt.Errorf("Wrong variable name (got: %q)", name)
+			}
+			value, ok := lookup(name)
+			if !ok {
+				return "", fmt.Errorf("Undefined environment variable %s (escape it with \"$${%s}\" for a literal)", name, name)
+			}
+			b.WriteString(value)
+			s = s[end+1:]
+		default:
+			b.WriteByte('$')
+			s = s[1:]
+		}
+	}
+}
+
+// isVarName return true if name is a valid variable name: letters, digits and '_' not starting with a digit
+func isVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ParseRules unmarshal matcher rules from YAML or JSON.
+// In YAML, scalars of string fields are kept as written (ie: "MAJOR: 8" or "ID_VENDOR_ID: 0781"),
+// and YAML 1.1 booleans are accepted for bool fields (ie: "negate: yes").
+func ParseRules(stream []byte, isYAML bool) (*RuleDefinitions, error) {
+	var rules RuleDefinitions
+	if isYAML {
+		if err := yaml.Unmarshal(stream, &rules); err != nil {
+			return nil, fmt.Errorf("Wrong rule syntax, err: %w", err)
+		}
+		return &rules, nil
+	}
+
+	if err := json.Unmarshal(stream, &rules); err != nil {
+		return nil, fmt.Errorf("Wrong rule syntax, err: %w", err)
+	}
+	return &rules, nil
+}
+
+func isYAMLRules(path string, stream []byte) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	case ".json":
+		return false
+	}
+	return !bytes.HasPrefix(bytes.TrimSpace(stream), []byte("{"))
+}
+
+// TestRules allow to check if rules would match an uevent (ie: a captured one) without monitoring,
+// an error is returned if the rules can't be compiled.
+func TestRules(rules *RuleDefinitions, e UEvent) (bool, error) {
+	if rules == nil {
+		return false, fmt.Errorf("No rules to test")
+	}
+	if err := rules.Compile(); err != nil {
+		return false, fmt.Errorf("Wrong matcher, err: %w", err)
+	}
+	return rules.Evaluate(e), nil
+}
+
+// WriteTo implements io.WriterTo, rules are written as canonical JSON (indented with tabs, sorted env keys and
+// no HTML escaping of regexps) so diffs of rule files are stable. Only patterns are written, not compiled regexps.
+// The order of rules is kept as it matters (see: EvaluateMatch).
+func (rs RuleDefinitions) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+	if err := encoder.Encode(rs); err != nil {
+		return 0, fmt.Errorf("Unable to encode rules, err: %w", err)
+	}
+
+	n, err := w.Write(buf.Bytes())
+	if err != nil {
+		return int64(n), fmt.Errorf("Unable to write rules, err: %w", err)
+	}
+	return int64(n), nil
+}
+
+// ReadFrom implements io.ReaderFrom, rules are read from JSON (ie: written by WriteTo) and replace the current ones.
+// Rules have to be compiled again before evaluation.
+func (rs *RuleDefinitions) ReadFrom(r io.Reader) (int64, error) {
+	stream, err := io.ReadAll(r)
+	if err != nil {
+		return int64(len(stream)), fmt.Errorf("Unable to read rules, err: %w", err)
+	}
+
+	rules, err := ParseRules(stream, false)
+	if err != nil {
+		return int64(len(stream)), err
+	}
+	*rs = *rules
+	return int64(len(stream)), nil
+}
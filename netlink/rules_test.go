@@ -0,0 +1,260 @@
+package netlink
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadRules(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	dir := testing.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(content), 0o644)
+		t.FatalfIf(err != nil, "Unable to write %s, err: %v", name, err)
+		return path
+	}
+
+	jsonRules := `{
+	"rules": [
+		{"action": "^(add|remove)$", "env": {"SUBSYSTEM": "^usb.*", "ID_SERIAL": null}},
+		{"env": {"DEVTYPE": "usb_interface"}, "ignore_case": true},
+		{"action": "unbind", "negate": true}
+	]
+}`
+	yamlRules := `# Same rules in YAML
+rules:
+- action: '^(add|remove)$'
+  env:
+    SUBSYSTEM: "^usb.*" # comment
+    ID_SERIAL:
+- env: {DEVTYPE: usb_interface}
+  ignore_case: true
+
+- action: unbind
+  negate: true
+`
+
+	paths := []string{
+		write("rules.json", jsonRules),
+		write("rules.yaml", yamlRules),
+		write("rules.yml", yamlRules),
+		write("rules-json", jsonRules), // detected by content
+		write("rules-yaml", yamlRules),
+	}
+
+	var expected *RuleDefinitions
+	for _, path := range paths {
+		rules, err := LoadRules(path)
+		t.FatalfIf(err != nil, "Unable to load %s, err: %v", path, err)
+		t.FatalfIf(len(rules.Rules) != 3, "Wrong number of rules in %s, got: %d", path, len(rules.Rules))
+		t.FatalfIf(rules.Compile() != nil, "Unable to compile rules of %s", path)
+		if expected == nil {
+			expected = rules
+			continue
+		}
+		t.FatalfIf(!reflect.DeepEqual(expected, rules), "Rules of %s should be identical, got:\n%s\nexpected:\n%s", path, rules, expected)
+	}
+
+	uevent := UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb", "ID_SERIAL": "foo"}}
+	t.FatalfIf(!expected.Evaluate(uevent), "Rules should match %+v", uevent)
+
+	// Samples
+	sample, err := LoadRules("../matcher.sample")
+	t.FatalfIf(err != nil, "Unable to load sample, err: %v", err)
+	sampleYAML, err := LoadRules("../matcher.sample.yaml")
+	t.FatalfIf(err != nil, "Unable to load YAML sample, err: %v", err)
+	t.FatalfIf(!reflect.DeepEqual(sample, sampleYAML), "Samples should be identical")
+
+	// Errors
+	for name, content := range map[string]string{
+		"empty.json":  " \n",
+		"wrong.json":  `{"rules": [`,
+		"wrong.yaml":  "rules:\n  - action: add\n   env: {}\n",
+		"regexp.yaml": "rules:\n  - action: \"(\"\n",
+	} {
+		rules, err := LoadRules(write(name, content))
+		if err == nil {
+			err = rules.Compile()
+		}
+		t.FatalfIf(err == nil, "Loading %s should fail", name)
+	}
+	_, err = LoadRules(filepath.Join(dir, "missing.json"))
+	t.FatalfIf(err == nil, "Loading a missing file should fail")
+}
+
+func TestParseRulesYAML(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	rules, err := ParseRules([]byte(`rules:
+- env: {MAJOR: 8, MINOR: 0x10, ID_VENDOR_ID: 0781, ID_MODEL: 1.50, ID_SERIAL: ~, DEVNAME: 'it''s'}
+  negate: yes
+- attrs:
+    idVendor: 058f
+    removable: true
+  device: {subsystem: usb, devtype: usb_device}
+  ignore_case: on
+- &anchor
+  action: add
+- *anchor
+`), true)
+	t.FatalfIf(err != nil, "Unable to parse rules, err: %v", err)
+	t.FatalfIf(len(rules.Rules) != 4, "Wrong number of rules, got: %d", len(rules.Rules))
+
+	env := rules.Rules[0].Env
+	for k, v := range map[string]string{"MAJOR": "8", "MINOR": "0x10", "ID_VENDOR_ID": "0781", "ID_MODEL": "1.50", "ID_SERIAL": "", "DEVNAME": "it's"} {
+		got, ok := env[k]
+		t.FatalfIf(!ok || got != v, "Scalar of %s should be kept as written (got: %q, wanted: %q)", k, got, v)
+	}
+	t.FatalfIf(!rules.Rules[0].Negate, "YAML 1.1 boolean should be accepted")
+	t.FatalfIf(rules.Rules[1].Attrs["idVendor"] != "058f" || rules.Rules[1].Attrs["removable"] != "true", "Wrong attrs, got: %v", rules.Rules[1].Attrs)
+	t.FatalfIf(!rules.Rules[1].IgnoreCase || rules.Rules[1].Device.String() != "usb/usb_device", "Wrong rule, got: %s", rules.Rules[1])
+	t.FatalfIf(rules.Rules[3].Action == nil || *rules.Rules[3].Action != "add", "Alias should be resolved, got: %s", rules.Rules[3])
+	t.FatalfIf(rules.Compile() != nil, "Unable to compile rules")
+	numeric := RuleDefinition{Env: map[string]string{"MAJOR": env["MAJOR"]}}
+	t.FatalfIf(!numeric.Evaluate(UEvent{Action: ADD, Env: map[string]string{"MAJOR": "8"}}), "Numeric env should match")
+
+	for _, yaml := range []string{"rules:\n- env: {A: 1}\n  env: {A: 2}", "rules:\n\t- action: add", "rules: [", "rules:\n- negate: maybe", "rules: {}"} {
+		_, err := ParseRules([]byte(yaml), true)
+		t.FatalfIf(err == nil, "Parsing %q should fail", yaml)
+	}
+}
+
+func TestExpandVars(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	lookup := func(name string) (string, bool) {
+		v, ok := map[string]string{"HOST_BUS": "pci0000:00", "EMPTY": "", "A1": "a"}[name]
+		return v, ok
+	}
+
+	for s, expected := range map[string]string{
+		"":                          "",
+		"^usb$":                     "^usb$",
+		"/devices/${HOST_BUS}/usb1": "/devices/pci0000:00/usb1",
+		"${A1}${A1}-${EMPTY}$":      "aa-$",
+		"^/devices/$${HOST_BUS}$":   "^/devices/${HOST_BUS}$",
+		"${A1}$${A1}":               "a${A1}",
+		"cost: $5 {not a variable}": "cost: $5 {not a variable}",
+		"$":                         "$",
+		"$$":                        "$",
+		"$$$":                       "$$",
+		"^usb$$":                    "^usb$",
+		"$$${A1}":                   "$a",
+		"$$$${A1}":                  "$${A1}",
+		"${A1}$":                    "a$",
+	} {
+		got, err := expandVars(s, lookup)
+		t.FatalfIf(err != nil || got != expected, "Wrong expansion of %q (got: %q, wanted: %q, err: %v)", s, got, expected, err)
+	}
+
+	for _, s := range []string{"${UNDEFINED}", "/devices/${HOST_BUS", "${}", "${1A}", "${HOST-BUS}"} {
+		_, err := expandVars(s, lookup)
+		t.FatalfIf(err == nil, "Expansion of %q should fail", s)
+	}
+}
+
+func TestLoadRulesExpand(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	testing.Setenv("GO_UDEV_TEST_BUS", "pci0000:00")
+	testing.Setenv("GO_UDEV_TEST_TAG", "seat")
+	path := filepath.Join(testing.TempDir(), "rules.yaml")
+	err := os.WriteFile(path, []byte(`rules:
+- env: {DEVPATH: '^/devices/${GO_UDEV_TEST_BUS}/', DEVNAME: '^$${literal}$'}
+  tag: ${GO_UDEV_TEST_TAG}
+`), 0o644)
+	t.FatalfIf(err != nil, "Unable to write rules, err: %v", err)
+
+	rules, err := LoadRules(path)
+	t.FatalfIf(err != nil, "Unable to load rules, err: %v", err)
+	rule := rules.Rules[0]
+	t.FatalfIf(rule.Env["DEVPATH"] != "^/devices/pci0000:00/" || rule.Env["DEVNAME"] != "^${literal}$" || rule.Tag != "seat", "Wrong expanded rule (got: %s)", rule)
+	t.FatalfIf(rules.Compile() != nil, "Unable to compile expanded rules")
+
+	err = os.WriteFile(path, []byte("rules:\n- env: {DEVPATH: '^/devices/${GO_UDEV_TEST_UNDEFINED}/'}\n"), 0o644)
+	t.FatalfIf(err != nil, "Unable to write rules, err: %v", err)
+	_, err = LoadRules(path)
+	t.FatalfIf(err == nil || !strings.Contains(err.Error(), "GO_UDEV_TEST_UNDEFINED") || !strings.Contains(err.Error(), "rule #1"), "Undefined variable should be reported clearly (got: %v)", err)
+}
+
+func TestTestRules(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	rules, err := ParseRules([]byte("rules:\n  - action: add\n    env: {SUBSYSTEM: usb}\n"), true)
+	t.FatalfIf(err != nil, "Unable to parse rules, err: %v", err)
+
+	raw := []byte("add@/devices/usb1\x00ACTION=add\x00DEVPATH=/devices/usb1\x00SUBSYSTEM=usb\x00SEQNUM=1\x00")
+	uevent, err := ParseUEvent(raw)
+	t.FatalfIf(err != nil, "Unable to parse uevent, err: %v", err)
+
+	matched, err := TestRules(rules, *uevent)
+	t.FatalfIf(err != nil || !matched, "Rules should match (err: %v)", err)
+
+	uevent.Env["SUBSYSTEM"] = "block"
+	matched, err = TestRules(rules, *uevent)
+	t.FatalfIf(err != nil || matched, "Rules shouldn't match (err: %v)", err)
+
+	_, err = TestRules(&RuleDefinitions{Rules: []RuleDefinition{NewEnvRule("SUBSYSTEM", "usb"), {Env: map[string]string{"A": "("}}}}, *uevent)
+	t.FatalfIf(err == nil, "Wrong rules should fail")
+	_, err = TestRules(nil, *uevent)
+	t.FatalfIf(err == nil, "Nil rules should fail")
+}
+
+func TestRulesWriteReadFrom(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	action := "^(add|remove)$"
+	rules := RuleDefinitions{Rules: []RuleDefinition{
+		{Action: &action, Env: map[string]string{"SUBSYSTEM": "^usb.*", "ID_SERIAL": "", "DEVTYPE": "<&>"}},
+		{Device: &DeviceType{Subsystem: "block", DevType: "disk"}, Negate: true, IgnoreCase: true},
+	}}
+	t.FatalfIf(rules.Compile() != nil, "Unable to compile rules")
+
+	var first bytes.Buffer
+	n, err := rules.WriteTo(&first)
+	t.FatalfIf(err != nil || n != int64(first.Len()), "Unable to write rules (n: %d), err: %v", n, err)
+
+	expected := `{
+	"rules": [
+		{
+			"action": "^(add|remove)$",
+			"env": {
+				"DEVTYPE": "<&>",
+				"ID_SERIAL": "",
+				"SUBSYSTEM": "^usb.*"
+			}
+		},
+		{
+			"negate": true,
+			"ignore_case": true,
+			"device": {
+				"subsystem": "block",
+				"devtype": "disk"
+			}
+		}
+	]
+}
+`
+	t.FatalfIf(first.String() != expected, "Wrong canonical JSON, got:\n%s", first.String())
+
+	// load-save-load cycle is idempotent
+	var loaded RuleDefinitions
+	n, err = loaded.ReadFrom(bytes.NewReader(first.Bytes()))
+	t.FatalfIf(err != nil || n != int64(first.Len()), "Unable to read rules (n: %d), err: %v", n, err)
+	var second bytes.Buffer
+	_, err = loaded.WriteTo(&second)
+	t.FatalfIf(err != nil || second.String() != first.String(), "Rules should be written identically, got:\n%s", second.String())
+
+	t.FatalfIf(loaded.Compile() != nil, "Unable to compile loaded rules")
+	uevent := UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb", "ID_SERIAL": "x", "DEVTYPE": "<&>"}}
+	t.FatalfIf(!loaded.Evaluate(uevent), "Loaded rules should match")
+
+	_, err = loaded.ReadFrom(bytes.NewReader([]byte(`{"rules": [`)))
+	t.FatalfIf(err == nil, "Wrong JSON should be rejected")
+}
@@ -0,0 +1,6 @@
+//go:build linux && 386
+
+package netlink
+
+// sysSetns is the number of the setns syscall, missing from syscall package on this architecture
+const sysSetns = 346
@@ -0,0 +1,59 @@
+package netlink
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// LogAttrs return the attributes describing the uevent in structured logs: action, kobj, subsystem, devname and seqnum
+// (devname is omitted when empty, seqnum when unknown)
+func (e UEvent) LogAttrs() []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("action", e.Action.String()),
+		slog.String("kobj", e.KObj),
+		slog.String("subsystem", e.Subsystem()),
+	}
+	if devname := e.DevName(); devname != "" {
+		attrs = append(attrs, slog.String("devname", devname))
+	}
+	if e.SeqNum != 0 {
+		attrs = append(attrs, slog.Uint64("seqnum", e.SeqNum))
+	}
+	return attrs
+}
+
+// LogUEvent log the uevent with its attributes (see: LogAttrs) at the level
+func LogUEvent(ctx context.Context, logger *slog.Logger, level slog.Level, e UEvent) {
+	logger.LogAttrs(ctx, level, "uevent", e.LogAttrs()...)
+}
+
+// SlogLogger is a Logger which route internal diagnostics to slog at the level
+type SlogLogger struct {
+	Logger *slog.Logger
+	Level  slog.Level
+}
+
+func (l SlogLogger) Printf(format string, v ...interface{}) {
+	l.Logger.Log(context.Background(), l.Level, fmt.Sprintf(format, v...))
+}
+
+// SetSlogHandler allow to log each uevent delivered by Subscribe with its attributes at the level,
+// and internal diagnostics at debug level, through the slog handler. It should be called before Open.
+func (c *Client) SetSlogHandler(h slog.Handler, level slog.Level) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.slog = slog.New(h)
+	c.slogLevel = level
+	c.Logger = SlogLogger{Logger: c.slog, Level: slog.LevelDebug}
+}
+
+// logUEvents forward uevents from queue to the client queue and log them, the client queue is closed at the end
+func (c *Client) logUEvents(queue chan UEvent) {
+	defer close(c.queue)
+	for e := range queue {
+		LogUEvent(context.Background(), c.slog, c.slogLevel, e)
+		c.queue <- e
+	}
+}
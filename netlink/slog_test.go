@@ -0,0 +1,80 @@
+package netlink
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingHandler keep slog records to assert them
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordingHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r.Clone())
+	return nil
+}
+
+// find return the attributes of the first record with the message and level
+func (h *recordingHandler) find(msg string, level slog.Level) (map[string]string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range h.records {
+		if r.Message != msg || r.Level != level {
+			continue
+		}
+		attrs := make(map[string]string)
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value.String()
+			return true
+		})
+		return attrs, true
+	}
+	return nil, false
+}
+
+func TestSlog(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	handler := &recordingHandler{}
+	client := new(Client)
+	client.Conn.TrustAllSenders = true
+	client.SetSlogHandler(handler, slog.LevelInfo)
+	err := client.Open(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to open client, err: %v", err)
+
+	queue := client.Subscribe(nil)
+	sample := UEvent{Action: ADD, KObj: "/devices/sda", Env: map[string]string{"SUBSYSTEM": "block", "DEVNAME": "sda", "SEQNUM": "42"}}
+	sendMsg(testing, &client.Conn, sample.BytesUdev())
+	select {
+	case uevent := <-queue:
+		t.FatalfIf(uevent.KObj != sample.KObj, "Wrong uevent, got: %s", uevent.KObj)
+	case <-time.After(time.Second):
+		t.Fatal("Uevent should be delivered")
+	}
+	t.FatalfIf(client.Close() != nil, "Close should succeed")
+
+	attrs, ok := handler.find("uevent", slog.LevelInfo)
+	t.FatalfIf(!ok, "Uevent should be logged")
+	expected := map[string]string{"action": "add", "kobj": "/devices/sda", "subsystem": "block", "devname": "sda", "seqnum": "42"}
+	for k, v := range expected {
+		t.FatalfIf(attrs[k] != v, "Wrong attribute %s (got: %q, expected: %q)", k, attrs[k], v)
+	}
+
+	_, ok = handler.find("netlink: client opened (mode: udev)", slog.LevelDebug)
+	t.FatalfIf(!ok, "Diagnostics should be logged at debug level")
+
+	// Optional attributes
+	attrsOf := UEvent{Action: REMOVE}.LogAttrs()
+	t.FatalfIf(len(attrsOf) != 3, "Devname and seqnum should be omitted, got: %v", attrsOf)
+}
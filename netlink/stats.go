@@ -0,0 +1,91 @@
+package netlink
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Stats aggregate counts of uevents per action and per SUBSYSTEM, ie: for a quick histogram of the uevents flowing.
+// The zero value is ready to use and it is safe for concurrent use.
+type Stats struct {
+	mu         sync.Mutex
+	total      uint64
+	actions    map[KObjAction]uint64
+	subsystems map[string]uint64
+}
+
+// StatsSnapshot is a copy of the counts of Stats at a point in time.
+// Uevents without SUBSYSTEM env are counted with an empty subsystem.
+type StatsSnapshot struct {
+	Total      uint64
+	Actions    map[KObjAction]uint64
+	Subsystems map[string]uint64
+}
+
+// Add count the uevent
+func (s *Stats) Add(e UEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.actions == nil {
+		s.actions = make(map[KObjAction]uint64)
+		s.subsystems = make(map[string]uint64)
+	}
+	s.total++
+	s.actions[e.Action]++
+	s.subsystems[e.Env["SUBSYSTEM"]]++
+}
+
+// Snapshot return a copy of the counts, which isn't updated by next Add
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := StatsSnapshot{
+		Total:      s.total,
+		Actions:    make(map[KObjAction]uint64, len(s.actions)),
+		Subsystems: make(map[string]uint64, len(s.subsystems)),
+	}
+	for a, n := range s.actions {
+		snapshot.Actions[a] = n
+	}
+	for subsystem, n := range s.subsystems {
+		snapshot.Subsystems[subsystem] = n
+	}
+	return snapshot
+}
+
+// String return a summary sorted by count, ie: "3 uevents, actions: add=2 remove=1, subsystems: usb=2 block=1"
+func (s StatsSnapshot) String() string {
+	actions := make(map[string]uint64, len(s.Actions))
+	for a, n := range s.Actions {
+		actions[a.String()] = n
+	}
+	return fmt.Sprintf("%d uevents, actions: %s, subsystems: %s", s.Total, sortedCounts(actions), sortedCounts(s.Subsystems))
+}
+
+// sortedCounts format counts by decreasing count then by name
+func sortedCounts(counts map[string]uint64) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		if name == "" {
+			parts = append(parts, fmt.Sprintf("(none)=%d", counts[name]))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%d", name, counts[name]))
+	}
+	return strings.Join(parts, " ")
+}
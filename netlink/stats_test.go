@@ -0,0 +1,38 @@
+package netlink
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStats(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	var stats Stats
+	t.FatalfIf(stats.Snapshot().Total != 0, "Empty stats should have no uevent")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stats.Add(UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb"}})
+			stats.Add(UEvent{Action: REMOVE, Env: map[string]string{"SUBSYSTEM": "usb"}})
+			stats.Add(UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "block"}})
+		}()
+	}
+	wg.Wait()
+	stats.Add(UEvent{Action: CHANGE, Env: map[string]string{}})
+
+	snapshot := stats.Snapshot()
+	t.FatalfIf(snapshot.Total != 31, "Wrong total, got: %d", snapshot.Total)
+	t.FatalfIf(snapshot.Actions[ADD] != 20 || snapshot.Actions[REMOVE] != 10 || snapshot.Actions[CHANGE] != 1, "Wrong actions, got: %v", snapshot.Actions)
+	t.FatalfIf(snapshot.Subsystems["usb"] != 20 || snapshot.Subsystems["block"] != 10 || snapshot.Subsystems[""] != 1, "Wrong subsystems, got: %v", snapshot.Subsystems)
+
+	expected := "31 uevents, actions: add=20 remove=10 change=1, subsystems: usb=20 block=10 (none)=1"
+	t.FatalfIf(snapshot.String() != expected, "Wrong summary, got: %s", snapshot.String())
+
+	// Snapshot is a copy
+	stats.Add(UEvent{Action: ADD, Env: map[string]string{"SUBSYSTEM": "usb"}})
+	t.FatalfIf(snapshot.Subsystems["usb"] != 20, "Snapshot shouldn't be updated")
+}
@@ -0,0 +1,93 @@
+package netlink
+
+import (
+	"regexp"
+)
+
+// classSubsystems are class directories of sysfs: a class device is a child of its parent device inside
+// a directory named after its subsystem, ie: ".../0000:00:19.0/net/eth0" is in the "net" subsystem
+var classSubsystems = map[string]bool{
+	"bdi": true, "block": true, "bluetooth": true, "bsg": true, "drm": true, "graphics": true, "hidraw": true,
+	"hwmon": true, "ieee80211": true, "input": true, "leds": true, "mmc_host": true, "mtd": true, "net": true,
+	"nvme": true, "power_supply": true, "rfkill": true, "rtc": true, "scsi_device": true, "scsi_disk": true,
+	"scsi_generic": true, "scsi_host": true, "sound": true, "thermal": true, "tty": true, "usbmisc": true,
+	"video4linux": true, "watchdog": true,
+}
+
+// nestedClassSubsystems are classes whose devices could have children in the same subsystem,
+// ie: ".../block/sda/sda1" or ".../input/input3/event3"
+var nestedClassSubsystems = map[string]bool{"block": true, "input": true}
+
+// busDevicePatterns recognize names of bus devices, which aren't inside a class directory
+var busDevicePatterns = []struct {
+	subsystem string
+	pattern   *regexp.Regexp
+}{
+	{"pci", regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)},             // ie: 0000:00:14.0
+	{"usb", regexp.MustCompile(`^(usb[0-9]+|[0-9]+-[0-9]+(\.[0-9]+)*(:[0-9]+\.[0-9]+)?)$`)}, // ie: usb1, 1-1.2, 1-1:1.0
+	{"scsi", regexp.MustCompile(`^(host[0-9]+|target[0-9]+:[0-9]+:[0-9]+|[0-9]+:[0-9]+:[0-9]+:[0-9]+)$`)},
+}
+
+// InferSubsystem guess the subsystem of the device from its path only, without reading sysfs
+// (see Subsystem to read the "subsystem" link of a plugged device). The heuristic, in order:
+//   - "/module/<name>" is in the "module" subsystem
+//   - "/devices/virtual/<subsystem>/<name>/..." is in <subsystem> (ie: "/devices/virtual/net/lo")
+//   - a device inside a known class directory is in this class (ie: ".../net/eth0", ".../block/sda"),
+//     a partition is in the subsystem of its disk (ie: ".../block/sda/sda1", same for input devices)
+//   - a PCI, USB or SCSI device is recognized by its name (ie: "0000:00:14.0", "1-1:1.0", "4:0:0:0")
+//
+// It return false when the subsystem can't be guessed.
+func (p DevPath) InferSubsystem() (string, bool) {
+	c := p.Components()
+	n := len(c)
+	if n < 2 {
+		return "", false
+	}
+
+	if c[0] == "module" && n == 2 {
+		return "module", true
+	}
+	if c[0] == "devices" && n >= 4 && c[1] == "virtual" {
+		return c[2], true
+	}
+
+	if classSubsystems[c[n-2]] {
+		return c[n-2], true
+	}
+	if n >= 3 && nestedClassSubsystems[c[n-3]] {
+		return c[n-3], true // ie: partition of a disk
+	}
+
+	for _, bus := range busDevicePatterns {
+		if bus.pattern.MatchString(c[n-1]) {
+			return bus.subsystem, true
+		}
+	}
+	return "", false
+}
+
+// InferSubsystem return the SUBSYSTEM env value if any, otherwise the subsystem guessed from KObj
+// (see: DevPath.InferSubsystem)
+func (e UEvent) InferSubsystem() (string, bool) {
+	if s, ok := e.Env["SUBSYSTEM"]; ok && s != "" {
+		return s, true
+	}
+	return e.Path().InferSubsystem()
+}
+
+// BackfillSubsystem set the SUBSYSTEM env var guessed from KObj when it is missing, it return true if it was set.
+// Env is created if needed.
+func (e *UEvent) BackfillSubsystem() bool {
+	if s, ok := e.Env["SUBSYSTEM"]; ok && s != "" {
+		return false
+	}
+	subsystem, ok := e.Path().InferSubsystem()
+	if !ok {
+		return false
+	}
+	if e.Env == nil {
+		e.Env = make(map[string]string)
+	}
+	e.Env["SUBSYSTEM"] = subsystem
+	return true
+}
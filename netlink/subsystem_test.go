@@ -0,0 +1,57 @@
+package netlink
+
+import (
+	"testing"
+)
+
+func TestInferSubsystem(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	testcases := map[string]string{
+		"/devices/pci0000:00/0000:00:19.0/net/eth0": "net",
+		"/devices/virtual/net/lo":                   "net",
+		"/devices/virtual/block/loop0":              "block",
+		"/devices/virtual/tty/tty1":                 "tty",
+		"/devices/pci0000:00/0000:00:14.0/usb1/1-1/1-1:1.0/host4/target4:0:0/4:0:0:0/block/sdb":      "block",
+		"/devices/pci0000:00/0000:00:14.0/usb1/1-1/1-1:1.0/host4/target4:0:0/4:0:0:0/block/sdb/sdb1": "block",
+		"/devices/pci0000:00/0000:00:14.0/usb1/1-1/1-1:1.0/host4/target4:0:0/4:0:0:0":                "scsi",
+		"/devices/pci0000:00/0000:00:14.0/usb1/1-1/1-1:1.0/host4":                                    "scsi",
+		"/devices/pci0000:00/0000:00:14.0/usb1/1-1/1-1:1.0":                                          "usb",
+		"/devices/pci0000:00/0000:00:14.0/usb1/1-1.2":                                                "usb",
+		"/devices/pci0000:00/0000:00:14.0/usb1":                                                      "usb",
+		"/devices/pci0000:00/0000:00:14.0":                                                           "pci",
+		"/devices/platform/i8042/serio0/input/input3/event3":                                         "input",
+		"/devices/pci0000:00/0000:00:14.0/usb1/1-4/1-4:1.0/0003:046D:C52B.0001/hidraw/hidraw0":       "hidraw",
+		"/sys/devices/pci0000:00/0000:00:1f.3/sound/card0":                                           "sound",
+		"/module/usb_storage": "module",
+	}
+	for devpath, expected := range testcases {
+		subsystem, ok := NewDevPath(devpath).InferSubsystem()
+		t.FatalfIf(!ok || subsystem != expected, "Wrong subsystem of %s (got: %q, wanted: %q)", devpath, subsystem, expected)
+	}
+
+	for _, devpath := range []string{"", "/", "/devices", "/devices/platform/i8042", "/kernel/slab/foo"} {
+		subsystem, ok := NewDevPath(devpath).InferSubsystem()
+		t.FatalfIf(ok, "Subsystem of %s shouldn't be guessed (got: %q)", devpath, subsystem)
+	}
+}
+
+func TestBackfillSubsystem(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	uevent := UEvent{Action: ADD, KObj: "/devices/pci0000:00/0000:00:19.0/net/eth0"}
+	subsystem, ok := uevent.InferSubsystem()
+	t.FatalfIf(!ok || subsystem != "net", "Wrong inferred subsystem (got: %q)", subsystem)
+	t.FatalfIf(uevent.Subsystem() != "", "InferSubsystem shouldn't modify env")
+
+	t.FatalfIf(!uevent.BackfillSubsystem(), "Missing subsystem should be set")
+	t.FatalfIf(uevent.Subsystem() != "net", "Wrong backfilled subsystem (got: %q)", uevent.Subsystem())
+
+	uevent = UEvent{Action: ADD, KObj: "/devices/virtual/net/lo", Env: map[string]string{"SUBSYSTEM": "queues"}}
+	t.FatalfIf(uevent.BackfillSubsystem() || uevent.Subsystem() != "queues", "Existing subsystem shouldn't be replaced")
+	subsystem, _ = uevent.InferSubsystem()
+	t.FatalfIf(subsystem != "queues", "SUBSYSTEM env var should be preferred (got: %q)", subsystem)
+
+	uevent = UEvent{Action: ADD, KObj: "/devices/platform/i8042", Env: map[string]string{}}
+	t.FatalfIf(uevent.BackfillSubsystem() || len(uevent.Env) != 0, "Unknown subsystem shouldn't be set")
+}
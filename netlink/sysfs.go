@@ -0,0 +1,100 @@
+package netlink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sysfsPath is the mount point of sysfs (overridden in tests)
+var sysfsPath = "/sys"
+
+// sysPath return the absolute path in sysfs of a devpath, which could be
+// relative to sysfs (ie: KObj of an UEvent) or already absolute (ie: KObj of a crawled device)
+func sysPath(devpath string) string {
+	devpath = filepath.Clean("/" + devpath)
+	if devpath == sysfsPath || strings.HasPrefix(devpath, sysfsPath+"/") {
+		return devpath
+	}
+	return filepath.Join(sysfsPath, devpath)
+}
+
+// TriggerUEvent ask the kernel to emit an uevent with the action for the device, by writing
+// the action into its uevent file (ie: "echo add > /sys/devices/.../uevent").
+// It allow to force a rescan of a device or to provoke real uevents in integration tests (root is required).
+func TriggerUEvent(devpath string, action KObjAction) error {
+	if _, err := ParseKObjAction(action.String()); err != nil {
+		return err
+	}
+
+	path := filepath.Join(sysPath(devpath), "uevent")
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("Unable to trigger uevent, err: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(action.String()); err != nil {
+		return fmt.Errorf("Unable to trigger %s uevent on %s, err: %w", action, devpath, err)
+	}
+	return nil
+}
+
+// ReadAttr return the value of a sysfs attribute of a device without leading and trailing whitespaces
+// (like udev_device_get_sysattr_value), ie: ReadAttr("/devices/virtual/block/loop0", "size").
+// attr could be in a sub-directory of the device (ie: "power/control") but not outside of it.
+func ReadAttr(devpath, attr string) (string, error) {
+	if err := checkAttrName(attr); err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(filepath.Join(sysPath(devpath), filepath.Clean(attr)))
+	if err != nil {
+		return "", fmt.Errorf("Unable to read attribute %s of %s, err: %w", attr, devpath, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// checkAttrName return an error if the attribute name is outside of the device directory
+func checkAttrName(attr string) error {
+	cleaned := filepath.Clean(attr)
+	if attr == "" || filepath.IsAbs(attr) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("Wrong attribute name (got: %q)", attr)
+	}
+	return nil
+}
+
+// attrCache read sysfs attributes of a device at most once, ie: during the evaluation of rules for an uevent
+type attrCache struct {
+	devpath string
+	values  map[string]*string // nil value when the attribute is missing or unreadable
+}
+
+func newAttrCache(devpath string) *attrCache {
+	return &attrCache{devpath: devpath}
+}
+
+// get return the value of the attribute, false if there is no device or if it can't be read
+func (c *attrCache) get(attr string) (string, bool) {
+	if c == nil || c.devpath == "" {
+		return "", false
+	}
+	if v, ok := c.values[attr]; ok {
+		if v == nil {
+			return "", false
+		}
+		return *v, true
+	}
+
+	if c.values == nil {
+		c.values = make(map[string]*string)
+	}
+	value, err := ReadAttr(c.devpath, attr)
+	if err != nil {
+		c.values[attr] = nil
+		return "", false
+	}
+	c.values[attr] = &value
+	return value, true
+}
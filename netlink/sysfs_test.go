@@ -0,0 +1,89 @@
+package netlink
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setSysfsFixture make a temporary directory the sysfs mount point during the test
+func setSysfsFixture(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	previous := sysfsPath
+	sysfsPath = root
+	t.Cleanup(func() {
+		sysfsPath = previous
+	})
+	return root
+}
+
+func TestSysPath(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	testcases := map[string]string{
+		"/devices/virtual/mem/null":     "/sys/devices/virtual/mem/null",
+		"devices/virtual/mem/null":      "/sys/devices/virtual/mem/null",
+		"/sys/devices/virtual/mem/null": "/sys/devices/virtual/mem/null",
+		"/devices/virtual/mem/null/":    "/sys/devices/virtual/mem/null",
+		"/devices/../../etc/passwd":     "/sys/etc/passwd",
+		"/system/foo":                   "/sys/system/foo",
+	}
+	for devpath, expected := range testcases {
+		t.FatalfIf(sysPath(devpath) != expected, "Wrong sys path of %s (got: %s, wanted: %s)", devpath, sysPath(devpath), expected)
+	}
+}
+
+func TestTriggerUEvent(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	root := setSysfsFixture(testing)
+	dir := filepath.Join(root, "devices/virtual/mem/null")
+	t.FatalfIf(os.MkdirAll(dir, 0755) != nil, "Unable to create fixture")
+	t.FatalfIf(os.WriteFile(filepath.Join(dir, "uevent"), nil, 0644) != nil, "Unable to create fixture")
+
+	err := TriggerUEvent("/devices/virtual/mem/null", CHANGE)
+	t.FatalfIf(err != nil, "Unable to trigger uevent, err: %v", err)
+	data, _ := os.ReadFile(filepath.Join(dir, "uevent"))
+	t.FatalfIf(string(data) != "change", "Wrong action written (got: %s)", data)
+
+	t.FatalfIf(TriggerUEvent("/devices/virtual/mem/null", "plug") == nil, "Unknown action should be rejected")
+	t.FatalfIf(TriggerUEvent("/devices/virtual/mem/zero", ADD) == nil, "Unknown device should be rejected")
+}
+
+func TestReadAttr(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	root := setSysfsFixture(testing)
+	dir := filepath.Join(root, "devices/virtual/block/loop0")
+	t.FatalfIf(os.MkdirAll(filepath.Join(dir, "queue"), 0755) != nil, "Unable to create fixture")
+	t.FatalfIf(os.WriteFile(filepath.Join(dir, "size"), []byte("2048\n"), 0644) != nil, "Unable to create fixture")
+	t.FatalfIf(os.WriteFile(filepath.Join(dir, "queue/scheduler"), []byte(" [none] mq-deadline \n"), 0644) != nil, "Unable to create fixture")
+	t.FatalfIf(os.WriteFile(filepath.Join(root, "devices/virtual/block/secret"), []byte("secret"), 0644) != nil, "Unable to create fixture")
+	t.FatalfIf(os.WriteFile(filepath.Join(dir, "unreadable"), []byte("foo"), 0200) != nil, "Unable to create fixture")
+
+	value, err := ReadAttr("/devices/virtual/block/loop0", "size")
+	t.FatalfIf(err != nil || value != "2048", "Wrong attribute value (got: %q, err: %v)", value, err)
+
+	value, err = NewDevPath(dir + "/").ReadAttr("queue/scheduler")
+	t.FatalfIf(err != nil || value != "[none] mq-deadline", "Wrong attribute value in sub-directory (got: %q, err: %v)", value, err)
+
+	_, err = ReadAttr("/devices/virtual/block/loop0", "state")
+	t.FatalfIf(!errors.Is(err, fs.ErrNotExist), "Missing attribute should return a not exist error, got: %v", err)
+
+	_, err = ReadAttr("/devices/virtual/block/loop0", "queue")
+	t.FatalfIf(err == nil, "Directory shouldn't be read as attribute")
+
+	if os.Geteuid() != 0 { // root bypasses permissions
+		_, err = ReadAttr("/devices/virtual/block/loop0", "unreadable")
+		t.FatalfIf(!errors.Is(err, fs.ErrPermission), "Unreadable attribute should return a permission error, got: %v", err)
+	}
+
+	for _, attr := range []string{"", ".", "..", "../secret", "queue/../../secret", "/etc/passwd"} {
+		_, err = ReadAttr("/devices/virtual/block/loop0", attr)
+		t.FatalfIf(err == nil, "Attribute outside of the device should be rejected (with: %q)", attr)
+	}
+}
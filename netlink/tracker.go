@@ -0,0 +1,105 @@
+package netlink
+
+import (
+	"container/list"
+)
+
+// DeviceTracker remember the env of devices from their add, change, bind and move uevents to enrich their remove
+// uevents, which often carry only a few env vars (ie: without ID_VENDOR or ID_SERIAL, not sent again by the kernel).
+// At most Size devices are remembered: when full, the least recently seen device is evicted, so its remove uevent
+// won't be enriched. A device is forgotten once its remove uevent is handled.
+// A DeviceTracker isn't safe for concurrent use.
+type DeviceTracker struct {
+	size    int
+	entries *list.List // of *trackedDevice, most recently seen first
+	index   map[string]*list.Element
+}
+
+type trackedDevice struct {
+	kObj string
+	env  map[string]string
+}
+
+// NewDeviceTracker return a DeviceTracker remembering at most size devices
+func NewDeviceTracker(size int) *DeviceTracker {
+	return &DeviceTracker{
+		size:    size,
+		entries: list.New(),
+		index:   make(map[string]*list.Element),
+	}
+}
+
+// Len return how many devices are remembered
+func (t *DeviceTracker) Len() int {
+	return t.entries.Len()
+}
+
+// Track remember the env of the uevent, or enrich a remove uevent with the remembered env vars which are missing
+// from it (existing env vars are never replaced). It return true if the uevent was enriched.
+func (t *DeviceTracker) Track(e *UEvent) bool {
+	if t.size <= 0 || e.KObj == "" {
+		return false
+	}
+
+	switch e.Action {
+	case REMOVE:
+		elem, ok := t.index[e.KObj]
+		if !ok {
+			return false
+		}
+		t.forget(elem)
+
+		enriched := false
+		if e.Env == nil {
+			e.Env = make(map[string]string)
+		}
+		for k, v := range elem.Value.(*trackedDevice).env {
+			if _, exists := e.Env[k]; !exists {
+				e.Env[k] = v
+				enriched = true
+			}
+		}
+		return enriched
+	case MOVE:
+		// Keep what is known about the device under its new path
+		if elem, ok := t.index[e.Env["DEVPATH_OLD"]]; ok {
+			t.forget(elem)
+			t.remember(e.KObj, elem.Value.(*trackedDevice).env, false)
+		}
+	}
+
+	if e.Action == ADD || e.Action == CHANGE || e.Action == MOVE || e.Action.IsBinding() {
+		t.remember(e.KObj, e.Env, e.Action == ADD) // a new device could reuse the path of a forgotten one
+	}
+	return false
+}
+
+// remember merge env into the remembered env of the device (or replace it), which become the most recently seen
+func (t *DeviceTracker) remember(kObj string, env map[string]string, replace bool) {
+	if elem, ok := t.index[kObj]; ok {
+		device := elem.Value.(*trackedDevice)
+		if replace {
+			device.env = make(map[string]string, len(env))
+		}
+		for k, v := range env {
+			device.env[k] = v
+		}
+		t.entries.MoveToFront(elem)
+		return
+	}
+
+	device := &trackedDevice{kObj: kObj, env: make(map[string]string, len(env))}
+	for k, v := range env {
+		device.env[k] = v // copy, env is delivered to the caller
+	}
+	t.index[kObj] = t.entries.PushFront(device)
+
+	if t.entries.Len() > t.size {
+		t.forget(t.entries.Back())
+	}
+}
+
+func (t *DeviceTracker) forget(elem *list.Element) {
+	t.entries.Remove(elem)
+	delete(t.index, elem.Value.(*trackedDevice).kObj)
+}
@@ -0,0 +1,74 @@
+package netlink
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDeviceTracker(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	tracker := NewDeviceTracker(2)
+	kObj := "/devices/pci0000:00/0000:00:14.0/usb1/1-1"
+
+	add := UEvent{Action: ADD, KObj: kObj, Env: map[string]string{"SUBSYSTEM": "usb", "ID_VENDOR": "Generic", "SEQNUM": "1"}}
+	t.FatalfIf(tracker.Track(&add), "Add uevent shouldn't be enriched")
+	add.Env["ID_VENDOR"] = "modified" // env is copied
+	change := UEvent{Action: CHANGE, KObj: kObj, Env: map[string]string{"ID_SERIAL": "Generic_1234", "SEQNUM": "2"}}
+	tracker.Track(&change)
+
+	remove := UEvent{Action: REMOVE, KObj: kObj, Env: map[string]string{"SUBSYSTEM": "usb", "SEQNUM": "3"}}
+	t.FatalfIf(!tracker.Track(&remove), "Remove uevent should be enriched")
+	t.FatalfIf(remove.Env["ID_VENDOR"] != "Generic" || remove.Env["ID_SERIAL"] != "Generic_1234", "Remembered env should be added (got: %v)", remove.Env)
+	t.FatalfIf(remove.Env["SEQNUM"] != "3", "Existing env vars shouldn't be replaced (got: %s)", remove.Env["SEQNUM"])
+	t.FatalfIf(tracker.Len() != 0, "Removed device should be forgotten")
+
+	remove = UEvent{Action: REMOVE, KObj: kObj, Env: map[string]string{}}
+	t.FatalfIf(tracker.Track(&remove), "Unknown device shouldn't be enriched")
+
+	// Eviction of the least recently seen device
+	for i := 0; i < 3; i++ {
+		tracker.Track(&UEvent{Action: ADD, KObj: fmt.Sprintf("/devices/%d", i), Env: map[string]string{"N": fmt.Sprint(i)}})
+	}
+	t.FatalfIf(tracker.Len() != 2, "Tracker should be bounded (got: %d)", tracker.Len())
+	remove = UEvent{Action: REMOVE, KObj: "/devices/0", Env: map[string]string{}}
+	t.FatalfIf(tracker.Track(&remove), "Evicted device shouldn't be enriched")
+	remove = UEvent{Action: REMOVE, KObj: "/devices/2", Env: map[string]string{}}
+	t.FatalfIf(!tracker.Track(&remove) || remove.Env["N"] != "2", "Recent device should be enriched")
+
+	// Renamed device
+	tracker.Track(&UEvent{Action: ADD, KObj: "/devices/virtual/net/eth0", Env: map[string]string{"ID_NET_DRIVER": "e1000"}})
+	tracker.Track(&UEvent{Action: MOVE, KObj: "/devices/virtual/net/enp0s3", Env: map[string]string{"DEVPATH_OLD": "/devices/virtual/net/eth0"}})
+	remove = UEvent{Action: REMOVE, KObj: "/devices/virtual/net/enp0s3", Env: map[string]string{}}
+	t.FatalfIf(!tracker.Track(&remove) || remove.Env["ID_NET_DRIVER"] != "e1000", "Moved device should be enriched (got: %v)", remove.Env)
+}
+
+func TestTrackDevicesOption(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	conn := &UEventConn{TrustAllSenders: true, TrackDevices: 16}
+	err := conn.Connect(UdevEvent)
+	t.FatalfIf(err != nil, "Unable to subscribe to netlink uevent, err: %v", err)
+	defer conn.Close()
+
+	// Remove uevents are matched on the enriched env
+	queue := make(chan UEvent)
+	quit := conn.Monitor(queue, make(chan error, 1), &RuleDefinition{Env: map[string]string{"ID_VENDOR": "^Generic$"}})
+	defer func() {
+		close(quit)
+		waitStopReason(testing, conn)
+	}()
+
+	kObj := "/devices/pci0000:00/0000:00:14.0/usb1/1-1"
+	sendMsg(testing, conn, UEvent{Action: ADD, KObj: kObj, Env: map[string]string{"ID_VENDOR": "Generic"}}.Bytes())
+	sendMsg(testing, conn, UEvent{Action: REMOVE, KObj: kObj, Env: map[string]string{}}.Bytes())
+	for _, expected := range []KObjAction{ADD, REMOVE} {
+		select {
+		case uevent := <-queue:
+			t.FatalfIf(uevent.Action != expected || uevent.Env["ID_VENDOR"] != "Generic", "Wrong uevent (got: %s %v)", uevent.Action, uevent.Env)
+		case <-time.After(time.Second):
+			t.Fatalf("%s uevent should be received", expected)
+		}
+	}
+}
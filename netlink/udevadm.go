@@ -0,0 +1,139 @@
+package netlink
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// udevadmHeader match the header line of an event printed by udevadm monitor, ie:
+// "KERNEL[2514.128510] add      /devices/pci0000:00/0000:00:14.0/usb1/1-1 (usb)"
+var udevadmHeader = regexp.MustCompile(`^(KERNEL|UDEV)\s*\[([0-9.]+)\]\s+(\S+)\s+(\S+)(?:\s+\((\S*)\))?\s*$`)
+
+// udevadmPreambles are the first lines printed by udevadm monitor, before any event
+var udevadmPreambles = []string{"monitor will print the received events for:", "KERNEL - ", "UDEV - "}
+
+// ParseUdevadmMonitor parse the text output of "udevadm monitor --property" (or --kernel/--udev), ie: to feed
+// logs of a monitoring into matchers. Each event is a block of "KEY=value" lines separated by blank lines,
+// optionally preceded by a "KERNEL[timestamp] action devpath (subsystem)" or "UDEV [...]" header.
+// Action and KObj are read from the header, or from ACTION and DEVPATH env vars without header.
+// Without --property, events are only headers: their env is filled from the header.
+// Source is set from the header, timestamps are ignored (they are relative to the boot of the captured host).
+func ParseUdevadmMonitor(r io.Reader) ([]UEvent, error) {
+	var (
+		uevents []UEvent
+		current *udevadmEvent
+	)
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+		uevent, err := current.uevent()
+		if err != nil {
+			return err
+		}
+		uevents = append(uevents, uevent)
+		current = nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+	for num := 1; scanner.Scan(); num++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if m := udevadmHeader.FindStringSubmatch(line); m != nil {
+			if err := flush(); err != nil { // header without blank line before
+				return nil, err
+			}
+			current = &udevadmEvent{line: num, env: make(map[string]string), source: KernelEvent}
+			if m[1] == "UDEV" {
+				current.source = UdevEvent
+			}
+			current.action, current.kObj, current.subsystem = m[3], m[4], m[5]
+			continue
+		}
+
+		if current == nil && isUdevadmPreamble(line) {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Wrong udevadm monitor output at line %d (got: %q)", num, line)
+		}
+		if current == nil {
+			current = &udevadmEvent{line: num, env: make(map[string]string)}
+		}
+		current.env[kv[0]] = kv[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read udevadm monitor output, err: %w", err)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return uevents, nil
+}
+
+func isUdevadmPreamble(line string) bool {
+	for _, preamble := range udevadmPreambles {
+		if strings.HasPrefix(line, preamble) {
+			return true
+		}
+	}
+	return false
+}
+
+// udevadmEvent is an event being parsed by ParseUdevadmMonitor
+type udevadmEvent struct {
+	line                    int // first line, for errors
+	source                  Mode
+	action, kObj, subsystem string // from the header
+	env                     map[string]string
+}
+
+func (e *udevadmEvent) uevent() (UEvent, error) {
+	action, kObj := e.action, e.kObj
+	if action == "" {
+		action = e.env["ACTION"]
+	}
+	if kObj == "" {
+		kObj = e.env["DEVPATH"]
+	}
+	if kObj == "" {
+		return UEvent{}, fmt.Errorf("Wrong udevadm monitor event at line %d: missing devpath", e.line)
+	}
+	a, err := ParseKObjAction(action)
+	if err != nil {
+		return UEvent{}, fmt.Errorf("Wrong udevadm monitor event at line %d, err: %w", e.line, err)
+	}
+
+	// Fill from the header what --property would print
+	if _, ok := e.env["ACTION"]; !ok {
+		e.env["ACTION"] = action
+	}
+	if _, ok := e.env["DEVPATH"]; !ok {
+		e.env["DEVPATH"] = kObj
+	}
+	if _, ok := e.env["SUBSYSTEM"]; !ok && e.subsystem != "" {
+		e.env["SUBSYSTEM"] = e.subsystem
+	}
+
+	return UEvent{
+		Action:      a,
+		ActionKnown: true,
+		KObj:        kObj,
+		Env:         e.env,
+		SeqNum:      parseSeqNum(e.env),
+		Source:      e.source,
+	}, nil
+}
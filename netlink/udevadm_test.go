@@ -0,0 +1,77 @@
+package netlink
+
+import (
+	"strings"
+	"testing"
+)
+
+const udevadmMonitorOutput = `monitor will print the received events for:
+UDEV - the event which udev sends out after rule processing
+KERNEL - the kernel uevent
+
+KERNEL[2514.128510] add      /devices/pci0000:00/0000:00:14.0/usb1/1-1 (usb)
+ACTION=add
+DEVPATH=/devices/pci0000:00/0000:00:14.0/usb1/1-1
+SUBSYSTEM=usb
+DEVNAME=/dev/bus/usb/001/005
+DEVTYPE=usb_device
+PRODUCT=58f/6387/10b
+SEQNUM=2511
+
+UDEV  [2514.141230] add      /devices/pci0000:00/0000:00:14.0/usb1/1-1 (usb)
+ACTION=add
+DEVPATH=/devices/pci0000:00/0000:00:14.0/usb1/1-1
+SUBSYSTEM=usb
+DEVNAME=/dev/bus/usb/001/005
+DEVTYPE=usb_device
+ID_VENDOR=Generic
+ID_MODEL=Flash_Disk
+ID_SERIAL=Generic_Flash_Disk_A1B2=C3
+SEQNUM=2511
+USEC_INITIALIZED=2514141000
+
+`
+
+func TestParseUdevadmMonitor(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	uevents, err := ParseUdevadmMonitor(strings.NewReader(udevadmMonitorOutput))
+	t.FatalfIf(err != nil, "Unable to parse udevadm output, err: %v", err)
+	t.FatalfIf(len(uevents) != 2, "Wrong number of uevents (got: %d)", len(uevents))
+
+	kernel, udev := uevents[0], uevents[1]
+	t.FatalfIf(kernel.Source != KernelEvent || udev.Source != UdevEvent, "Wrong sources (got: %s, %s)", kernel.Source, udev.Source)
+	t.FatalfIf(kernel.Action != ADD || kernel.KObj != "/devices/pci0000:00/0000:00:14.0/usb1/1-1", "Wrong header (got: %s@%s)", kernel.Action, kernel.KObj)
+	t.FatalfIf(kernel.SeqNum != 2511 || kernel.DevType() != "usb_device", "Wrong kernel env (got: %v)", kernel.Env)
+	t.FatalfIf(udev.Env["ID_SERIAL"] != "Generic_Flash_Disk_A1B2=C3", "Only the first \"=\" should delimit the key (got: %s)", udev.Env["ID_SERIAL"])
+
+	rules := RuleDefinitions{}
+	rules.AddRule(NewEnvRule("ID_VENDOR", "Generic"))
+	t.FatalfIf(rules.Evaluate(kernel) || !rules.Evaluate(udev), "Parsed uevents should be usable with matchers")
+}
+
+func TestParseUdevadmMonitorShapes(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	// Without --property, only headers
+	uevents, err := ParseUdevadmMonitor(strings.NewReader("KERNEL[1.0] remove   /devices/virtual/block/loop0 (block)\nUDEV  [1.1] remove   /devices/virtual/block/loop0 (block)\n"))
+	t.FatalfIf(err != nil || len(uevents) != 2, "Unable to parse headers only (got: %d uevents, err: %v)", len(uevents), err)
+	t.FatalfIf(uevents[1].Subsystem() != "block" || uevents[1].Env["ACTION"] != "remove" || uevents[1].Env["DEVPATH"] != "/devices/virtual/block/loop0", "Env should be filled from header (got: %v)", uevents[1].Env)
+
+	// Without header, CRLF and trailing spaces
+	uevents, err = ParseUdevadmMonitor(strings.NewReader("ACTION=change\r\nDEVPATH=/devices/virtual/net/lo  \r\nSUBSYSTEM=net\r\n\r\n\r\nACTION=remove\nDEVPATH=/devices/virtual/net/lo\n"))
+	t.FatalfIf(err != nil || len(uevents) != 2, "Unable to parse blocks without header (got: %d uevents, err: %v)", len(uevents), err)
+	t.FatalfIf(uevents[0].Action != CHANGE || uevents[0].KObj != "/devices/virtual/net/lo" || uevents[1].Action != REMOVE, "Wrong uevents (got: %v)", uevents)
+
+	uevents, err = ParseUdevadmMonitor(strings.NewReader(""))
+	t.FatalfIf(err != nil || len(uevents) != 0, "Empty output should return no uevent (err: %v)", err)
+
+	for _, output := range []string{
+		"KERNEL[1.0] add /devices/foo (usb)\nnot a property\n",
+		"KERNEL[1.0] plug /devices/foo (usb)\n",
+		"ACTION=add\nSUBSYSTEM=usb\n",
+	} {
+		_, err = ParseUdevadmMonitor(strings.NewReader(output))
+		t.FatalfIf(err == nil, "Wrong output should be rejected (with: %q)", output)
+	}
+}
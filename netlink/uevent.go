@@ -3,9 +3,12 @@ package netlink
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
-	"unsafe"
+	"time"
 )
 
 // See: http://elixir.free-electrons.com/linux/v3.12/source/lib/kobject_uevent.c#L45
@@ -21,6 +24,9 @@ const (
 	UNBIND  KObjAction = "unbind"
 )
 
+// udevHeaderSize is the size of the udev_monitor_netlink_header struct, see https://github.com/systemd/systemd/blob/v239/src/libudev/libudev-monitor.c#L74
+const udevHeaderSize = 40
+
 // The magic value used by udev, see https://github.com/systemd/systemd/blob/v239/src/libudev/libudev-monitor.c#L57
 const libudevMagic = 0xfeedcafe
 
@@ -30,6 +36,26 @@ func (a KObjAction) String() string {
 	return string(a)
 }
 
+// AllActions return all known actions, ie: for iteration or validation
+func AllActions() []KObjAction {
+	return []KObjAction{ADD, REMOVE, CHANGE, MOVE, ONLINE, OFFLINE, BIND, UNBIND}
+}
+
+// IsLifecycle return true if the device appears or disappears (add/remove)
+func (a KObjAction) IsLifecycle() bool {
+	return a == ADD || a == REMOVE
+}
+
+// IsBinding return true if a driver is bound to or unbound from the device (bind/unbind)
+func (a KObjAction) IsBinding() bool {
+	return a == BIND || a == UNBIND
+}
+
+// IsPowerState return true if the device goes online or offline (ie: CPU hotplug)
+func (a KObjAction) IsPowerState() bool {
+	return a == ONLINE || a == OFFLINE
+}
+
 // Action 값을 추출하는 함수
 func ParseKObjAction(raw string) (a KObjAction, err error) {
 	a = KObjAction(raw)
@@ -41,12 +67,247 @@ func ParseKObjAction(raw string) (a KObjAction, err error) {
 	return
 }
 
+// parseAction parse an action, an unknown but well-formed one is only accepted with UnknownActions
+func (opts ParseOptions) parseAction(raw string) (a KObjAction, known bool, err error) {
+	a, err = ParseKObjAction(raw)
+	if err == nil {
+		return a, true, nil
+	}
+	if !opts.UnknownActions || raw == "" || strings.ContainsAny(raw, " \t\n@=") {
+		return "", false, err
+	}
+	return KObjAction(raw), false, nil
+}
+
 type UEvent struct {
 	Action KObjAction
-	KObj   string
-	Env    map[string]string
+	// ActionKnown is false when Action isn't one of AllActions, which only happen with ParseOptions.UnknownActions
+	// (ie: an action added by a newer kernel). It is set by the parsing, so it is false for uevents built by hand.
+	ActionKnown bool
+	KObj        string
+	Env         map[string]string
+	SeqNum      uint64      // parsed from SEQNUM env, zero if absent or invalid
+	Header      *UdevHeader // header of an udev event, nil for kernel events
+	Raw         []byte      // copy of the msg parsed by ParseUEvent, nil for uevents built otherwise
+	Source      Mode        // KernelEvent or UdevEvent depending on the format parsed by ParseUEvent, zero for uevents built otherwise
+
+	// ReceivedAt is the time the msg was read from the socket (or recorded, for replayed uevents), because the kernel
+	// doesn't stamp uevents. It is zero for uevents built otherwise, ie: by hand or by ParseUEvent.
+	ReceivedAt time.Time
+
+	// Sender is the socket which sent the msg, only captured with the Credentials option of UEventConn (nil otherwise)
+	Sender *Sender
+}
+
+// parseSeqNum return the SEQNUM env value or zero if absent or invalid
+func parseSeqNum(env map[string]string) uint64 {
+	seqnum, err := strconv.ParseUint(env["SEQNUM"], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seqnum
+}
+
+// SeqNumGapError reports missing uevents between two received SEQNUM
+type SeqNumGapError struct {
+	Expected uint64 // next expected SEQNUM
+	Got      uint64 // received SEQNUM
+}
+
+func (e *SeqNumGapError) Error() string {
+	return fmt.Sprintf("uevent seqnum gap, %d uevent(s) missing (got: %d, wanted: %d)", e.Got-e.Expected, e.Got, e.Expected)
+}
+
+// SeqNumChecker detect gaps in the SEQNUM of successive uevents.
+// Note: when subscribed to both KernelEvent and UdevEvent, each SEQNUM is received twice
+// and only gaps are reported, never duplicates or older SEQNUM.
+type SeqNumChecker struct {
+	last uint64
+}
+
+// Check return a *SeqNumGapError if some SEQNUM are missing between the last checked uevent and e
+func (s *SeqNumChecker) Check(e UEvent) error {
+	if e.SeqNum == 0 {
+		return nil // unknown seqnum
+	}
+
+	last := s.last
+	if e.SeqNum > last {
+		s.last = e.SeqNum
+	}
+
+	if last == 0 || e.SeqNum <= last+1 {
+		return nil
+	}
+	return &SeqNumGapError{Expected: last + 1, Got: e.SeqNum}
+}
+
+// InitializedSince return the USEC_INITIALIZED env of udev events: the CLOCK_MONOTONIC time (since boot) when udev
+// initialized the device for the first time, it isn't updated by next uevents of the device.
+// Note: the libudev header has no timestamp, so there is no time of the uevent itself on the wire.
+func (e UEvent) InitializedSince() (time.Duration, bool) {
+	v, ok := e.Env["USEC_INITIALIZED"]
+	if !ok {
+		return 0, false
+	}
+	usec, err := strconv.ParseUint(v, 10, 63)
+	if err != nil || usec == 0 {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// Normalize uppercase env keys (ie: "seqnum" become "SEQNUM") and trim leading and trailing whitespaces of env
+// values, for integrations comparing them regardless of case or formatting. KObj, Action and Raw aren't modified,
+// SeqNum is parsed again. When two keys differ only by case, the value of the already uppercase key is kept.
+// It is never applied by default, to preserve the exact data sent by the kernel (see: ParseOptions.Normalize).
+func (e *UEvent) Normalize() {
+	env := make(map[string]string, len(e.Env))
+	for k, v := range e.Env {
+		upper := strings.ToUpper(k)
+		if _, exists := env[upper]; exists && upper != k {
+			continue // keep the value of the uppercase key
+		}
+		env[upper] = strings.TrimSpace(v)
+	}
+	e.Env = env
+	e.SeqNum = parseSeqNum(env)
+}
+
+// EnvDiff return the env vars added, removed and changed since prev, a previous uevent of the same device
+// (ie: remembered by a DeviceTracker). added and changed hold the values of e, removed the values of prev,
+// so the old value of a changed key is prev.Env[key]. Maps are nil when there is no difference of the kind.
+func (e UEvent) EnvDiff(prev UEvent) (added, removed, changed map[string]string) {
+	for k, v := range e.Env {
+		old, ok := prev.Env[k]
+		switch {
+		case !ok:
+			if added == nil {
+				added = make(map[string]string)
+			}
+			added[k] = v
+		case old != v:
+			if changed == nil {
+				changed = make(map[string]string)
+			}
+			changed[k] = v
+		}
+	}
+	for k, v := range prev.Env {
+		if _, ok := e.Env[k]; !ok {
+			if removed == nil {
+				removed = make(map[string]string)
+			}
+			removed[k] = v
+		}
+	}
+	return added, removed, changed
+}
+
+// Project return a copy of the uevent with only the env vars of keys, ie: to print a few fields.
+// Keys missing in env are omitted, other fields (Action, KObj, SeqNum...) are kept.
+func (e UEvent) Project(keys ...string) UEvent {
+	env := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if v, ok := e.Env[k]; ok {
+			env[k] = v
+		}
+	}
+	e.Env = env
+	return e
+}
+
+// Get return the value of the env var key and true if it exists
+func (e UEvent) Get(key string) (string, bool) {
+	v, ok := e.Env[key]
+	return v, ok
+}
+
+// Subsystem return the SUBSYSTEM env value or empty string
+func (e UEvent) Subsystem() string {
+	return e.Env["SUBSYSTEM"]
+}
+
+// DevType return the DEVTYPE env value or empty string
+func (e UEvent) DevType() string {
+	return e.Env["DEVTYPE"]
+}
+
+// DevName return the DEVNAME env value or empty string
+func (e UEvent) DevName() string {
+	return e.Env["DEVNAME"]
+}
+
+// Modalias return the MODALIAS env value or empty string
+func (e UEvent) Modalias() string {
+	return e.Env["MODALIAS"]
+}
+
+// Driver return the DRIVER env value or empty string
+func (e UEvent) Driver() string {
+	return e.Env["DRIVER"]
+}
+
+// Major return the MAJOR env value of block and char devices, false if absent or malformed
+func (e UEvent) Major() (int, bool) {
+	return e.devNum("MAJOR")
+}
+
+// Minor return the MINOR env value of block and char devices, false if absent or malformed
+func (e UEvent) Minor() (int, bool) {
+	return e.devNum("MINOR")
+}
+
+// DevNumber return the major and minor numbers of the device node (ie: 8:1 for /dev/sda1),
+// ok is false unless both MAJOR and MINOR env are valid
+func (e UEvent) DevNumber() (major, minor int, ok bool) {
+	major, okMajor := e.Major()
+	minor, okMinor := e.Minor()
+	if !okMajor || !okMinor {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// devNum parse a device number env value, which must be a non-negative decimal
+func (e UEvent) devNum(key string) (int, bool) {
+	v, ok := e.Env[key]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// Tags return the tags of the TAGS env value set by udev (ie: ":systemd:seat:" gives "systemd" and "seat"),
+// without empty or duplicated ones, nil if there is no tag
+func (e UEvent) Tags() []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(e.Env["TAGS"], ":") {
+		if tag = strings.TrimSpace(tag); tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
 }
 
+// HasTag return true if the tag is in the TAGS env value (ie: HasTag("systemd"))
+func (e UEvent) HasTag(tag string) bool {
+	for _, t := range e.Tags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// String return the uevent in the kernel format.
+// Note: env vars are written in the nondeterministic order of the map, use StringSorted for a stable output.
 func (e UEvent) String() string {
 	rv := fmt.Sprintf("%s@%s\000", e.Action.String(), e.KObj)
 	for k, v := range e.Env {
@@ -55,10 +316,142 @@ func (e UEvent) String() string {
 	return rv
 }
 
+// StringSorted is like String but env vars are sorted by key, ie: for logs or golden files
+func (e UEvent) StringSorted() string {
+	b := strings.Builder{}
+	b.WriteString(e.Action.String() + "@" + e.KObj + "\000")
+	for _, k := range sortedKeys(e.Env) {
+		b.WriteString(k + "=" + e.Env[k] + "\000")
+	}
+	return b.String()
+}
+
+// Bytes return the uevent in the kernel format (see: String)
 func (e UEvent) Bytes() []byte {
 	return []byte(e.String())
 }
 
+// BytesSorted return the uevent in the kernel format with env vars sorted by key (see: StringSorted)
+func (e UEvent) BytesSorted() []byte {
+	return []byte(e.StringSorted())
+}
+
+// sortedKeys return keys of env in lexical order
+func sortedKeys(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// BytesUdev return the uevent as a libudev-monitor frame (ie: like an UdevEvent sent by udevd),
+// with the udev_monitor_netlink_header followed by "KEY=VALUE\0" properties sorted by key.
+// ACTION and DEVPATH properties are added from Action and KObj if missing in env.
+// See: https://github.com/systemd/systemd/blob/v239/src/libudev/libudev-monitor.c#L74
+func (e UEvent) BytesUdev() []byte {
+	env := make(map[string]string, len(e.Env)+2)
+	for k, v := range e.Env {
+		env[k] = v
+	}
+	if _, ok := env["ACTION"]; !ok {
+		env["ACTION"] = e.Action.String()
+	}
+	if _, ok := env["DEVPATH"]; !ok {
+		env["DEVPATH"] = e.KObj
+	}
+
+	var properties bytes.Buffer
+	for _, k := range sortedKeys(env) {
+		properties.WriteString(k + "=" + env[k] + "\000")
+	}
+
+	buf := make([]byte, udevHeaderSize, udevHeaderSize+properties.Len())
+	copy(buf, "libudev\x00")
+	binary.BigEndian.PutUint32(buf[8:], libudevMagic)
+	binary.NativeEndian.PutUint32(buf[12:], udevHeaderSize)            // header_size
+	binary.NativeEndian.PutUint32(buf[16:], udevHeaderSize)            // properties_off
+	binary.NativeEndian.PutUint32(buf[20:], uint32(properties.Len()))  // properties_len
+	binary.BigEndian.PutUint32(buf[24:], hashOrZero(env["SUBSYSTEM"])) // filter_subsystem_hash
+	binary.BigEndian.PutUint32(buf[28:], hashOrZero(env["DEVTYPE"]))   // filter_devtype_hash
+	bloom := tagsBloom64(env["TAGS"])
+	binary.BigEndian.PutUint32(buf[32:], uint32(bloom>>32)) // filter_tag_bloom_hi
+	binary.BigEndian.PutUint32(buf[36:], uint32(bloom))     // filter_tag_bloom_lo
+
+	return append(buf, properties.Bytes()...)
+}
+
+// hashOrZero return the udev hash of s, or zero if s is empty (ie: no filter)
+func hashOrZero(s string) uint32 {
+	if s == "" {
+		return 0
+	}
+	return stringHash32(s)
+}
+
+// uEventJSON is the stable JSON schema of an UEvent
+type uEventJSON struct {
+	Action string            `json:"action"`
+	KObj   string            `json:"kobj"`
+	SeqNum uint64            `json:"seqnum"`
+	Env    map[string]string `json:"env"`
+}
+
+// MarshalJSON implements json.Marshaler, env keys are sorted for a deterministic output
+func (e UEvent) MarshalJSON() ([]byte, error) {
+	env := e.Env
+	if env == nil {
+		env = map[string]string{}
+	}
+	// Note: encoding/json always sorts map keys
+	return json.Marshal(uEventJSON{
+		Action: e.Action.String(),
+		KObj:   e.KObj,
+		SeqNum: e.SeqNum,
+		Env:    env,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler, the action is validated (see: ParseOptions.ParseJSON to accept unknown ones)
+func (e *UEvent) UnmarshalJSON(data []byte) error {
+	uevent, err := ParseOptions{}.ParseJSON(data)
+	if err != nil {
+		return err
+	}
+	*e = *uevent
+	return nil
+}
+
+// ParseJSON decode an uevent marshaled by UEvent.MarshalJSON, ie: lines of a Forwarder.
+// With UnknownActions, an unknown but well-formed action is kept with ActionKnown false, so any uevent round-trips.
+func (opts ParseOptions) ParseJSON(data []byte) (*UEvent, error) {
+	var raw uEventJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	action, known, err := opts.parseAction(raw.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	env := raw.Env
+	if env == nil {
+		env = make(map[string]string)
+	}
+
+	return &UEvent{
+		Action:      action,
+		ActionKnown: known,
+		KObj:        raw.KObj,
+		Env:         env,
+		SeqNum:      raw.SeqNum,
+	}, nil
+}
+
+// Equal return true if both uevents have the same action, kobject and env,
+// otherwise the error describes the first difference found (e is "got", e2 is "wanted")
 func (e UEvent) Equal(e2 UEvent) (bool, error) {
 	if e.Action != e2.Action {
 		return false, fmt.Errorf("Wrong action (got: %s, wanted: %s)", e.Action, e2.Action)
@@ -68,45 +461,62 @@ func (e UEvent) Equal(e2 UEvent) (bool, error) {
 		return false, fmt.Errorf("Wrong kobject (got: %s, wanted: %s)", e.KObj, e2.KObj)
 	}
 
-	if len(e.Env) != len(e2.Env) {
-		return false, fmt.Errorf("Wrong length of env (got: %d, wanted: %d)", len(e.Env), len(e2.Env))
+	for k, v := range e.Env {
+		v2, ok := e2.Env[k]
+		if !ok {
+			return false, fmt.Errorf("Unexpected %s=%s env var in uevent", k, v)
+		}
+		if v != v2 {
+			return false, fmt.Errorf("Wrong %s env var value (got: %s, wanted: %s)", k, v, v2)
+		}
 	}
 
-	var found bool
-	for k, v := range e.Env {
-		found = false
-		for i, e := range e2.Env {
-			if i == k && v == e {
-				found = true
-				break
+	// Same keys on both sides when lengths are equal, otherwise find the extra one
+	if len(e.Env) != len(e2.Env) {
+		for k, v := range e2.Env {
+			if _, ok := e.Env[k]; !ok {
+				return false, fmt.Errorf("Missing %s=%s env var in uevent", k, v)
 			}
 		}
-		if !found {
-			return false, fmt.Errorf("Unable to find %s=%s env var from uevent", k, v)
-		}
 	}
 	return true, nil
 }
 
 // Parse udev event created by udevd.
 // The format of the data header is internal to udev and defined in libudev-monitor.c - see the udev_monitor_netlink_header struct.
-// go-udev only looks at the "magic" number to filter out possibly invalid packets, and at the payload offset. Other fields of the header
-// are ignored.
+// The whole header is parsed into UEvent.Header (see: UdevHeader). The "magic" number filters out possibly invalid packets,
+// the payload offset and length must describe properties which are inside the msg, after the header. Filter fields are
+// only informative: env values remain the primary source.
 // Note, only some of the fields of the header use network byte order, for the rest udev uses native byte order of the platform.
 // 데이터 헤더의 형식은 udev 내부 형식이고, libudev-monitor.c에 정의되어 있습니다.
-func parseUdevEvent(raw []byte) (e *UEvent, err error) {
+func parseUdevEvent(raw []byte, opts ParseOptions) (e *UEvent, skipped int, err error) {
+	// Truncated msg can't contain the whole header
+	if len(raw) < udevHeaderSize {
+		return nil, 0, fmt.Errorf("cannot parse libudev event: truncated header (got: %d bytes, wanted at least: %d)", len(raw), udevHeaderSize)
+	}
+
 	// the magic number is stored in network byte order.
 	// 앞의 8바이트를 제외하고, 이후의 4바이트(uint32)를 추출
 	magic := binary.BigEndian.Uint32(raw[8:])
 	// 추출한 4바이트의 값과 libudevMagic(0xfeedcafe)를 비교.
 	if magic != libudevMagic {
-		return nil, fmt.Errorf("cannot parse libudev event: magic number mismatch")
+		return nil, 0, fmt.Errorf("cannot parse libudev event: magic number mismatch")
 	}
 
+	header := parseUdevHeader(raw)
+
 	// the payload offset int is stored in native byte order.
-	payloadoff := *(*uint32)(unsafe.Pointer(&raw[16]))
+	payloadoff := header.PropertiesOffset
 	if payloadoff >= uint32(len(raw)) {
-		return nil, fmt.Errorf("cannot parse libudev event: invalid data offset")
+		return nil, 0, fmt.Errorf("cannot parse libudev event: invalid data offset")
+	}
+	// Properties can't overlap the header
+	if payloadoff < udevHeaderSize {
+		return nil, 0, fmt.Errorf("cannot parse libudev event: data offset inside header (got: %d, wanted at least: %d)", payloadoff, udevHeaderSize)
+	}
+	// Properties must be received entirely
+	if available := uint32(len(raw)) - payloadoff; header.PropertiesLength > available {
+		return nil, 0, fmt.Errorf("cannot parse libudev event: truncated data (got: %d bytes, wanted: %d)", available, header.PropertiesLength)
 	}
 	// Action(맨 처음 옵션)이 시작되는 부분부터 0x00(끝나는 부분)으로 나눔.
 	fields := bytes.Split(raw[payloadoff:], []byte{0x00}) // 0x00 = end of string
@@ -119,16 +529,19 @@ func parseUdevEvent(raw []byte) (e *UEvent, err error) {
 
 	// Key와 Value형태로 되어있는 Raw 데이터를 분리(=기준)하고, envdata에 Key / Value 형식으로 저장함.
 	for _, envs := range fields[0 : len(fields)-1] {
-		env := bytes.Split(envs, []byte("="))
+		env := bytes.SplitN(envs, []byte("="), 2) // only the first "=" delimits key from value
 		if len(env) != 2 {
+			if opts.Lenient {
+				skipped++
+				continue
+			}
 			err = fmt.Errorf("cannot parse libudev event: invalid env data")
 			return
 		}
 		envdata[string(env[0])] = string(env[1])
 	}
 
-	var action KObjAction
-	action, err = ParseKObjAction(strings.ToLower(envdata["ACTION"])) // 파싱한 데이터 중 "Action" 값을 action변수에 저장
+	action, known, err := opts.parseAction(strings.ToLower(envdata["ACTION"])) // 파싱한 데이터 중 "Action" 값을 action변수에 저장
 	if err != nil {
 		return
 	}
@@ -137,51 +550,138 @@ func parseUdevEvent(raw []byte) (e *UEvent, err error) {
 	kobj := envdata["DEVPATH"]
 
 	e = &UEvent{
-		Action: action,  // Action 값
-		KObj:   kobj,    // Kernel Object(경로 값)
-		Env:    envdata, // 나머지 정보 값들
+		Action:      action,               // Action 값
+		ActionKnown: known,                // false for an action unknown by ParseKObjAction
+		KObj:        kobj,                 // Kernel Object(경로 값)
+		Env:         envdata,              // 나머지 정보 값들
+		SeqNum:      parseSeqNum(envdata), // SEQNUM 값
+		Header:      &header,              // udev 헤더 값
 	}
 
 	return
 }
 
+// UdevHeader is the udev_monitor_netlink_header which precedes the properties of an udev event,
+// see https://github.com/systemd/systemd/blob/v239/src/libudev/libudev-monitor.c#L74
+// Filter fields are only useful to check the socket filter, env values remain the primary source.
+type UdevHeader struct {
+	Magic               uint32 // libudevMagic
+	HeaderSize          uint32
+	PropertiesOffset    uint32
+	PropertiesLength    uint32
+	FilterSubsystemHash uint32 // MurmurHash2 of SUBSYSTEM
+	FilterDevTypeHash   uint32 // MurmurHash2 of DEVTYPE
+	FilterTagBloomHi    uint32 // high bits of the bloom filter of TAGS
+	FilterTagBloomLo    uint32 // low bits of the bloom filter of TAGS
+}
+
+// TagBloom return the 64 bits bloom filter of the tags
+func (h UdevHeader) TagBloom() uint64 {
+	return uint64(h.FilterTagBloomHi)<<32 | uint64(h.FilterTagBloomLo)
+}
+
+// parseUdevHeader parse the header of raw, which must be at least udevHeaderSize long.
+// Magic and filter fields are stored in network byte order, the others in native byte order.
+func parseUdevHeader(raw []byte) UdevHeader {
+	return UdevHeader{
+		Magic:               binary.BigEndian.Uint32(raw[8:]),
+		HeaderSize:          binary.NativeEndian.Uint32(raw[12:]),
+		PropertiesOffset:    binary.NativeEndian.Uint32(raw[16:]),
+		PropertiesLength:    binary.NativeEndian.Uint32(raw[20:]),
+		FilterSubsystemHash: binary.BigEndian.Uint32(raw[24:]),
+		FilterDevTypeHash:   binary.BigEndian.Uint32(raw[28:]),
+		FilterTagBloomHi:    binary.BigEndian.Uint32(raw[32:]),
+		FilterTagBloomLo:    binary.BigEndian.Uint32(raw[36:]),
+	}
+}
+
 // UEvent를 통해 받은 버퍼를 출력에 맞게 파싱.
+// The raw msg is copied into UEvent.Raw, so the buffer could be reused by the caller.
+// Any malformed env entry is an error, see ParseUEventLenient to skip them.
 func ParseUEvent(raw []byte) (e *UEvent, err error) {
-	// 받은 데이터가 40Bytes가 넘고, 앞의 8Bytes가 "libudev\x00" 일때,(Test 시, 해당 조건에 들어갔음)
-	if len(raw) > 40 && bytes.Equal(raw[:8], []byte("libudev\x00")) {
-		return parseUdevEvent(raw)
+	e, _, err = ParseOptions{}.Parse(raw)
+	return
+}
+
+// ParseUEventStrict is ParseUEvent, named to be explicit next to ParseUEventLenient
+func ParseUEventStrict(raw []byte) (*UEvent, error) {
+	return ParseUEvent(raw)
+}
+
+// ParseUEventLenient is like ParseUEvent but malformed env entries (ie: without "=") are skipped instead of
+// failing the whole uevent, it return how many were skipped. Malformed headers and unknown actions are still errors.
+func ParseUEventLenient(raw []byte) (e *UEvent, skipped int, err error) {
+	return ParseOptions{Lenient: true}.Parse(raw)
+}
+
+// ParseOptions allow to relax the parsing of ParseUEvent, the zero value is the strict parsing
+type ParseOptions struct {
+	Lenient        bool // skip malformed env entries instead of failing (see: ParseUEventLenient)
+	UnknownActions bool // keep uevents with an unknown action (UEvent.ActionKnown is false) instead of failing
+	Normalize      bool // normalize env keys and values (see: UEvent.Normalize)
+}
+
+// Parse is like ParseUEvent with the options, it return how many env entries were skipped
+func (opts ParseOptions) Parse(raw []byte) (e *UEvent, skipped int, err error) {
+	// 앞의 8Bytes가 "libudev\x00" 일때,(Test 시, 해당 조건에 들어갔음) 헤더 길이는 parseUdevEvent에서 확인
+	source := KernelEvent
+	if bytes.HasPrefix(raw, []byte("libudev\x00")) {
+		source = UdevEvent
+		e, skipped, err = parseUdevEvent(raw, opts)
+	} else {
+		e, skipped, err = parseKernelEvent(raw, opts)
+	}
+	if err != nil {
+		return nil, 0, err
 	}
-	fields := bytes.Split(raw, []byte{0x00}) // 0x00 = end of string
 
-	if len(fields) == 0 {
-		err = fmt.Errorf("Wrong uevent format")
-		return
+	e.Source = source
+	if opts.Normalize {
+		e.Normalize()
 	}
 
-	headers := bytes.Split(fields[0], []byte("@")) // 0x40 = @
-	if len(headers) != 2 {
-		err = fmt.Errorf("Wrong uevent header")
+	e.Raw = append([]byte(nil), raw...)
+	return e, skipped, nil
+}
+
+// parseKernelEvent parse an uevent sent by the kernel: "action@kobj\x00KEY=VALUE\x00..."
+// The trailing 0x00 is optional and empty fields (ie: padding) are ignored, so an uevent could have no env.
+func parseKernelEvent(raw []byte, opts ParseOptions) (e *UEvent, skipped int, err error) {
+	fields := bytes.Split(bytes.TrimRight(raw, "\x00"), []byte{0x00}) // 0x00 = end of string
+
+	headers := bytes.SplitN(fields[0], []byte("@"), 2) // 0x40 = @
+	if len(headers) != 2 || len(headers[1]) == 0 {
+		err = fmt.Errorf("Wrong uevent header (got: %q)", fields[0])
 		return
 	}
 
-	action, err := ParseKObjAction(string(headers[0]))
+	action, known, err := opts.parseAction(string(headers[0]))
 	if err != nil {
 		return
 	}
 
 	e = &UEvent{
-		Action: action,
-		KObj:   string(headers[1]),
-		Env:    make(map[string]string),
+		Action:      action,
+		ActionKnown: known,
+		KObj:        string(headers[1]),
+		Env:         make(map[string]string),
 	}
 
-	for _, envs := range fields[1 : len(fields)-1] {
-		env := bytes.Split(envs, []byte("="))
+	for _, envs := range fields[1:] {
+		if len(envs) == 0 {
+			continue
+		}
+
+		env := bytes.SplitN(envs, []byte("="), 2) // only the first "=" delimits key from value
 		if len(env) != 2 {
-			err = fmt.Errorf("Wrong uevent env")
-			return
+			if opts.Lenient {
+				skipped++
+				continue
+			}
+			return nil, 0, fmt.Errorf("Wrong uevent env (got: %q)", envs)
 		}
 		e.Env[string(env[0])] = string(env[1])
 	}
+	e.SeqNum = parseSeqNum(e.Env)
 	return
 }
@@ -1,7 +1,13 @@
 package netlink
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math/rand"
+	"reflect"
 	"runtime"
+	"strconv"
 	"testing"
 )
 
@@ -56,10 +62,15 @@ func TestParseUEvent(testing *testing.T) {
 		t.FatalfIf(!ok || err != nil, "Uevent should be equal: bijectivity fail")
 	}
 
+	uevent, err := ParseUEvent(samples[0].Bytes())
+	t.FatalfIf(err != nil || uevent.SeqNum != 2569, "Wrong seqnum (got: %d, wanted: 2569)", uevent.SeqNum)
+	uevent, err = ParseUEvent(samples[1].Bytes())
+	t.FatalfIf(err != nil || uevent.SeqNum != 0, "Seqnum should be zero when absent (got: %d)", uevent.SeqNum)
+
 	raw := samples[0].Bytes()
 	raw[3] = 0x00 // remove @ to fake rawdata
 
-	uevent, err := ParseUEvent(raw)
+	uevent, err = ParseUEvent(raw)
 	t.FatalfIf(err == nil && uevent != nil, "Event parsed successfully but it should be invalid, err: %s", err.Error())
 
 }
@@ -174,6 +185,7 @@ func TestParseUdevEvent(testing *testing.T) {
 		t.FatalfIf(err != nil, "Unable to parse uevent: %s", err)
 		ok, err := uevent.Equal(s.Expected)
 		t.FatalfIf(!ok || err != nil, "Uevent should be equal: bijectivity fail,\n%s", err)
+		t.FatalfIf(strconv.FormatUint(uevent.SeqNum, 10) != s.Expected.Env["SEQNUM"], "Wrong seqnum (got: %d)", uevent.SeqNum)
 	}
 
 	invalidMagic := []byte("libudev\x00\xfe\xed\xca\xff(\x00\x00\x00(\x00\x00\x00\xd5\x03\x00\x00\x8a\xfa\x90\xc8\x00\x00\x00\x00\x02\x00\x04\x00\x10\x80\x00\x00ACTION=remove\x00DEVPATH=foo\x00")
@@ -299,3 +311,609 @@ func TestUEventEquality(testing *testing.T) {
 		t.FatalfIf(tc.mustEqual != res, "not expected result (test n°%d, got: %t, expected: %t), err: %v", i, res, tc.mustEqual, err)
 	}
 }
+
+func TestSeqNumChecker(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	checker := SeqNumChecker{}
+	testcases := []struct {
+		seqnum uint64
+		gap    bool
+	}{
+		{seqnum: 10, gap: false}, // first uevent
+		{seqnum: 11, gap: false},
+		{seqnum: 11, gap: false}, // duplicate (ie: kernel + udev groups)
+		{seqnum: 0, gap: false},  // unknown seqnum
+		{seqnum: 14, gap: true},
+		{seqnum: 12, gap: false}, // out of order
+		{seqnum: 15, gap: false},
+	}
+
+	for i, tc := range testcases {
+		err := checker.Check(UEvent{SeqNum: tc.seqnum})
+		t.FatalfIf(tc.gap != (err != nil), "Testcase n°%d: wrong gap detection (got: %v)", i+1, err)
+	}
+
+	checker = SeqNumChecker{last: 14}
+	gap, ok := checker.Check(UEvent{SeqNum: 17}).(*SeqNumGapError)
+	t.FatalfIf(!ok || gap.Expected != 15 || gap.Got != 17, "Wrong gap error (got: %v)", gap)
+}
+
+func TestUEventAccessors(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	uevent := UEvent{
+		Action: ADD,
+		KObj:   "/devices/pci0000:00/0000:00:14.0/usb1/1-2",
+		Env: map[string]string{
+			"SUBSYSTEM": "usb",
+			"DEVTYPE":   "usb_device",
+			"DEVNAME":   "/dev/bus/usb/001/033",
+			"DRIVER":    "usb",
+			"EMPTY":     "",
+		},
+	}
+
+	t.FatalfIf(uevent.Subsystem() != "usb", "Wrong subsystem (got: %s)", uevent.Subsystem())
+	t.FatalfIf(uevent.DevType() != "usb_device", "Wrong devtype (got: %s)", uevent.DevType())
+	t.FatalfIf(uevent.DevName() != "/dev/bus/usb/001/033", "Wrong devname (got: %s)", uevent.DevName())
+	t.FatalfIf(uevent.Driver() != "usb", "Wrong driver (got: %s)", uevent.Driver())
+	t.FatalfIf(uevent.Modalias() != "", "Modalias should be empty (got: %s)", uevent.Modalias())
+
+	v, ok := uevent.Get("EMPTY")
+	t.FatalfIf(!ok || v != "", "EMPTY env should exist with empty value")
+	_, ok = uevent.Get("MODALIAS")
+	t.FatalfIf(ok, "MODALIAS env shouldn't exist")
+	_, ok = UEvent{}.Get("SUBSYSTEM")
+	t.FatalfIf(ok, "Uevent without env shouldn't have any env var")
+}
+
+func TestUEventDevNumber(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	uevent := UEvent{Action: ADD, KObj: "/devices/pci0000:00/0000:00:1f.2/ata1/host0/target0:0:0/0:0:0:0/block/sda/sda1", Env: map[string]string{"MAJOR": "8", "MINOR": "1"}}
+	major, minor, ok := uevent.DevNumber()
+	t.FatalfIf(!ok || major != 8 || minor != 1, "Wrong device number (got: %d:%d, ok: %v)", major, minor, ok)
+	n, ok := uevent.Major()
+	t.FatalfIf(!ok || n != 8, "Wrong major (got: %d, ok: %v)", n, ok)
+	n, ok = uevent.Minor()
+	t.FatalfIf(!ok || n != 1, "Wrong minor (got: %d, ok: %v)", n, ok)
+
+	_, ok = UEvent{}.Major()
+	t.FatalfIf(ok, "Major shouldn't exist without env")
+	_, _, ok = UEvent{Env: map[string]string{"MAJOR": "8"}}.DevNumber()
+	t.FatalfIf(ok, "Device number shouldn't exist without MINOR")
+
+	for _, value := range []string{"", "abc", "-1", "8 ", "0x8", "99999999999"} {
+		_, ok = UEvent{Env: map[string]string{"MINOR": value}}.Minor()
+		t.FatalfIf(ok, "Malformed minor should be rejected (got: %q)", value)
+		_, _, ok = UEvent{Env: map[string]string{"MAJOR": value, "MINOR": "1"}}.DevNumber()
+		t.FatalfIf(ok, "Malformed major should be rejected (got: %q)", value)
+	}
+}
+
+func TestUEventTags(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	for tags, expected := range map[string][]string{
+		"":                        nil,
+		":":                       nil,
+		":systemd:":               {"systemd"},
+		"systemd":                 {"systemd"},
+		":seat:uaccess:systemd:":  {"seat", "uaccess", "systemd"},
+		"::seat: uaccess ::seat:": {"seat", "uaccess"},
+	} {
+		uevent := UEvent{Env: map[string]string{"TAGS": tags}}
+		got := uevent.Tags()
+		t.FatalfIf(len(got) != len(expected), "Wrong tags of %q (got: %q, wanted: %q)", tags, got, expected)
+		for i := range got {
+			t.FatalfIf(got[i] != expected[i], "Wrong tags of %q (got: %q, wanted: %q)", tags, got, expected)
+			t.FatalfIf(!uevent.HasTag(expected[i]), "%q should have tag %s", tags, expected[i])
+		}
+	}
+
+	t.FatalfIf(UEvent{}.Tags() != nil, "Uevent without TAGS shouldn't have tags")
+	uevent := UEvent{Env: map[string]string{"TAGS": ":seat:systemd:"}}
+	for _, tag := range []string{"", "sys", "seat:systemd", ":systemd:"} {
+		t.FatalfIf(uevent.HasTag(tag), "Uevent shouldn't have tag %q", tag)
+	}
+}
+
+func TestUEventProject(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	uevent := UEvent{Action: ADD, KObj: "/devices/foo", SeqNum: 42, Env: map[string]string{"SUBSYSTEM": "block", "DEVNAME": "sda", "SEQNUM": "42"}}
+	projected := uevent.Project("SUBSYSTEM", "DEVNAME", "UNKNOWN")
+	t.FatalfIf(!reflect.DeepEqual(projected.Env, map[string]string{"SUBSYSTEM": "block", "DEVNAME": "sda"}), "Wrong projected env (got: %v)", projected.Env)
+	t.FatalfIf(projected.Action != ADD || projected.KObj != "/devices/foo" || projected.SeqNum != 42, "Other fields should be kept (got: %v)", projected)
+	t.FatalfIf(len(uevent.Env) != 3, "Original env shouldn't be modified")
+	t.FatalfIf(len(uevent.Project().Env) != 0, "No key should give an empty env")
+}
+
+func TestUEventEqualityDiff(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	base := UEvent{
+		Action: ADD,
+		KObj:   "/devices/virtual/mem/null",
+		Env:    map[string]string{"ACTION": "add", "SUBSYSTEM": "mem", "DEVNAME": "null"},
+	}
+
+	testcases := []struct {
+		env      map[string]string
+		expected string
+	}{
+		{ // reordered env
+			env:      map[string]string{"DEVNAME": "null", "ACTION": "add", "SUBSYSTEM": "mem"},
+			expected: "",
+		},
+		{ // missing key
+			env:      map[string]string{"ACTION": "add", "SUBSYSTEM": "mem"},
+			expected: "Unexpected DEVNAME=null env var in uevent",
+		},
+		{ // extra key
+			env:      map[string]string{"ACTION": "add", "SUBSYSTEM": "mem", "DEVNAME": "null", "MAJOR": "1"},
+			expected: "Missing MAJOR=1 env var in uevent",
+		},
+		{ // same length but different key
+			env:      map[string]string{"ACTION": "add", "SUBSYSTEM": "mem", "MAJOR": "1"},
+			expected: "Unexpected DEVNAME=null env var in uevent",
+		},
+		{ // differing value
+			env:      map[string]string{"ACTION": "add", "SUBSYSTEM": "mem", "DEVNAME": "zero"},
+			expected: "Wrong DEVNAME env var value (got: null, wanted: zero)",
+		},
+	}
+
+	for i, tc := range testcases {
+		other := UEvent{Action: base.Action, KObj: base.KObj, Env: tc.env}
+		ok, err := base.Equal(other)
+		if tc.expected == "" {
+			t.FatalfIf(!ok || err != nil, "Testcase n°%d: uevents should be equal, err: %v", i+1, err)
+			continue
+		}
+		t.FatalfIf(ok || err == nil, "Testcase n°%d: uevents shouldn't be equal", i+1)
+		t.FatalfIf(err.Error() != tc.expected, "Testcase n°%d: wrong diff (got: %s, wanted: %s)", i+1, err, tc.expected)
+	}
+}
+
+func TestParseUEventEnvWithEqualSign(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	// Kernel format
+	sample := UEvent{
+		Action: ADD,
+		KObj:   "/devices/virtual/misc/foo",
+		Env: map[string]string{
+			"ACTION": "add",
+			"KEY":    "a=b=c",
+			"EMPTY":  "",
+		},
+	}
+	uevent, err := ParseUEvent(sample.Bytes())
+	t.FatalfIf(err != nil, "Unable to parse uevent, err: %v", err)
+	t.FatalfIf(uevent.Env["KEY"] != "a=b=c", "Wrong env value (got: %s, wanted: a=b=c)", uevent.Env["KEY"])
+	t.FatalfIf(uevent.Env["EMPTY"] != "", "Wrong env value (got: %s, wanted empty)", uevent.Env["EMPTY"])
+
+	// Libudev format
+	if runtime.GOARCH == "s390x" || runtime.GOARCH == "ppc" {
+		testing.Skip("This test assumes little-endian architecture")
+	}
+	raw := []byte("libudev\x00\xfe\xed\xca\xfe(\x00\x00\x00(\x00\x00\x00\x37\x00\x00\x00\x8a\xfa\x90\xc8\x00\x00\x00\x00\x02\x00\x04\x00\x10\x80\x00\x00" +
+		"ACTION=add\x00DEVPATH=/devices/virtual/misc/foo\x00KEY=a=b=c\x00")
+	uevent, err = ParseUEvent(raw)
+	t.FatalfIf(err != nil, "Unable to parse libudev uevent, err: %v", err)
+	t.FatalfIf(uevent.Env["KEY"] != "a=b=c", "Wrong env value (got: %s, wanted: a=b=c)", uevent.Env["KEY"])
+}
+
+func TestParseUdevEventOffsetInsideHeader(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	raw := UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}.BytesUdev()
+	_, err := ParseUEvent(raw)
+	t.FatalfIf(err != nil, "Unable to parse valid libudev event, err: %v", err)
+
+	for _, offset := range []uint32{0, 8, udevHeaderSize - 1} {
+		binary.NativeEndian.PutUint32(raw[16:], offset) // properties_off
+		uevent, err := ParseUEvent(raw)
+		t.FatalfIf(err == nil || uevent != nil, "Libudev event with data offset %d inside the header should be rejected", offset)
+	}
+}
+
+func TestParseUdevEventTruncatedData(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	raw := UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"SUBSYSTEM": "usb"}}.BytesUdev()
+
+	// Properties cut by a too small read buffer
+	for _, n := range []int{len(raw) - 1, udevHeaderSize + 1} {
+		uevent, err := ParseUEvent(raw[:n])
+		t.FatalfIf(err == nil || uevent != nil, "Libudev event truncated to %d bytes should be rejected", n)
+	}
+
+	// Length bigger than the msg
+	length := binary.NativeEndian.Uint32(raw[20:])
+	binary.NativeEndian.PutUint32(raw[20:], length+1) // properties_len
+	uevent, err := ParseUEvent(raw)
+	t.FatalfIf(err == nil || uevent != nil, "Libudev event with data length beyond the msg should be rejected")
+
+	binary.NativeEndian.PutUint32(raw[20:], length)
+	uevent, err = ParseUEvent(raw)
+	t.FatalfIf(err != nil || uevent.Env["SUBSYSTEM"] != "usb", "Unable to parse valid libudev event (got: %v, err: %v)", uevent, err)
+}
+
+func TestParseTruncatedUEvent(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	valid := []byte("libudev\x00\xfe\xed\xca\xfe(\x00\x00\x00(\x00\x00\x00\x2b\x00\x00\x00\x8a\xfa\x90\xc8\x00\x00\x00\x00\x02\x00\x04\x00\x10\x80\x00\x00" +
+		"ACTION=add\x00DEVPATH=/devices/virtual/misc/foo\x00")
+
+	// Every truncated libudev header must be rejected with a descriptive error
+	for n := 0; n < udevHeaderSize; n++ {
+		uevent, err := ParseUEvent(valid[:n])
+		t.FatalfIf(err == nil || uevent != nil, "Truncated msg of %d bytes should be invalid", n)
+	}
+
+	uevent, err := ParseUEvent([]byte("add@"))
+	t.FatalfIf(err == nil || uevent != nil, "Msg without kobj should be invalid")
+
+	// Random short msg must never panic
+	prefixes := [][]byte{nil, []byte("libudev\x00"), valid[:12], valid[:udevHeaderSize], []byte("add@")}
+	rnd := rand.New(rand.NewSource(42))
+	for i := 0; i < 10000; i++ {
+		prefix := prefixes[rnd.Intn(len(prefixes))]
+		raw := make([]byte, len(prefix)+rnd.Intn(64))
+		copy(raw, prefix)
+		rnd.Read(raw[len(prefix):])
+
+		uevent, err := ParseUEvent(raw)
+		if err != nil {
+			continue
+		}
+		t.FatalfIf(uevent == nil, "Uevent can't be nil without error (with: %q)", raw)
+		_, err = ParseKObjAction(uevent.Action.String())
+		t.FatalfIf(err != nil, "Uevent parsed with invalid action (with: %q)", raw)
+	}
+}
+
+func TestParseKernelEvent(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	// Captured raw kernel uevents
+	misc := []byte("change@/devices/virtual/misc/autofs\x00ACTION=change\x00DEVPATH=/devices/virtual/misc/autofs\x00SUBSYSTEM=misc\x00SYNTH_UUID=0\x00MAJOR=10\x00MINOR=235\x00DEVNAME=autofs\x00DEVMODE=0644\x00SEQNUM=670\x00")
+	net := []byte("change@/devices/virtual/net/lo\x00ACTION=change\x00DEVPATH=/devices/virtual/net/lo\x00SUBSYSTEM=net\x00SYNTH_UUID=0\x00INTERFACE=lo\x00IFINDEX=1\x00SEQNUM=671\x00")
+
+	testCases := []struct {
+		name string
+		raw  []byte
+		kObj string
+		env  int
+	}{
+		{"misc", misc, "/devices/virtual/misc/autofs", 9},
+		{"net", net, "/devices/virtual/net/lo", 7},
+		{"missing trailing end of string", misc[:len(misc)-1], "/devices/virtual/misc/autofs", 9},
+		{"padding", append(append([]byte{}, net...), 0, 0, 0), "/devices/virtual/net/lo", 7},
+		{"empty field", bytes.Replace(net, []byte("\x00SUBSYSTEM"), []byte("\x00\x00SUBSYSTEM"), 1), "/devices/virtual/net/lo", 7},
+		{"header only", []byte("change@/devices/virtual/net/lo\x00"), "/devices/virtual/net/lo", 0},
+		{"header only without end of string", []byte("change@/devices/virtual/net/lo"), "/devices/virtual/net/lo", 0},
+		{"kobj with @", []byte("add@/devices/foo@1\x00ACTION=add\x00"), "/devices/foo@1", 1},
+	}
+	for _, tc := range testCases {
+		uevent, err := ParseUEvent(tc.raw)
+		t.FatalfIf(err != nil, "%s: unable to parse uevent, err: %v", tc.name, err)
+		t.FatalfIf(uevent.KObj != tc.kObj, "%s: wrong kobj (got: %s, wanted: %s)", tc.name, uevent.KObj, tc.kObj)
+		t.FatalfIf(len(uevent.Env) != tc.env, "%s: wrong number of env vars (got: %d, wanted: %d)", tc.name, len(uevent.Env), tc.env)
+	}
+
+	uevent, _ := ParseUEvent(misc[:len(misc)-1])
+	t.FatalfIf(uevent.SeqNum != 670 || uevent.Subsystem() != "misc", "Last env var should be parsed without trailing end of string")
+
+	for _, raw := range []string{"", "\x00", "/devices/foo\x00ACTION=add\x00", "add@/devices/foo\x00ACTION\x00"} {
+		uevent, err := ParseUEvent([]byte(raw))
+		t.FatalfIf(err == nil || uevent != nil, "Msg should be invalid (with: %q)", raw)
+	}
+}
+
+func TestUEventJSON(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	uevent := UEvent{
+		Action: ADD,
+		KObj:   "/devices/virtual/mem/null",
+		Env:    map[string]string{"SUBSYSTEM": "mem", "ACTION": "add", "SEQNUM": "42", "DEVNAME": "null"},
+		SeqNum: 42,
+	}
+
+	data, err := json.Marshal(uevent)
+	t.FatalfIf(err != nil, "Unable to marshal uevent, err: %v", err)
+
+	expected := `{"action":"add","kobj":"/devices/virtual/mem/null","seqnum":42,"env":{"ACTION":"add","DEVNAME":"null","SEQNUM":"42","SUBSYSTEM":"mem"}}`
+	t.FatalfIf(string(data) != expected, "Wrong JSON (got: %s, wanted: %s)", data, expected)
+
+	var got UEvent
+	err = json.Unmarshal(data, &got)
+	t.FatalfIf(err != nil, "Unable to unmarshal uevent, err: %v", err)
+	ok, err := got.Equal(uevent)
+	t.FatalfIf(!ok || err != nil, "Uevent should be equal after round-trip, err: %v", err)
+	t.FatalfIf(got.SeqNum != uevent.SeqNum, "Wrong seqnum after round-trip (got: %d)", got.SeqNum)
+
+	data, err = json.Marshal(UEvent{Action: REMOVE, KObj: "/foo"})
+	t.FatalfIf(err != nil || string(data) != `{"action":"remove","kobj":"/foo","seqnum":0,"env":{}}`, "Wrong JSON without env (got: %s)", data)
+
+	t.FatalfIf(!got.ActionKnown, "Known action should be flagged")
+
+	err = json.Unmarshal([]byte(`{"action":"plug","kobj":"/foo"}`), &got)
+	t.FatalfIf(err == nil, "Unknown action should be rejected")
+
+	// Unknown action (ie: added by a newer kernel) round-trips with UnknownActions
+	unknown := UEvent{Action: KObjAction("plug"), KObj: "/foo", Env: map[string]string{"ACTION": "plug"}, SeqNum: 7}
+	data, err = json.Marshal(unknown)
+	t.FatalfIf(err != nil, "Unable to marshal uevent, err: %v", err)
+	parsed, err := ParseOptions{UnknownActions: true}.ParseJSON(data)
+	t.FatalfIf(err != nil, "Unknown action should be accepted, err: %v", err)
+	t.FatalfIf(parsed.ActionKnown, "Unknown action shouldn't be flagged as known")
+	ok, err = parsed.Equal(unknown)
+	t.FatalfIf(!ok || err != nil || parsed.SeqNum != 7, "Uevent with unknown action should be equal after round-trip, err: %v", err)
+
+	for _, data := range []string{`{"action":"","kobj":"/foo"}`, `{"kobj":"/foo"}`, `{"action":"plug in","kobj":"/foo"}`, `{"action":"add@/foo","kobj":"/foo"}`} {
+		_, err = ParseOptions{UnknownActions: true}.ParseJSON([]byte(data))
+		t.FatalfIf(err == nil, "Malformed action should be rejected (with: %s)", data)
+	}
+}
+
+func TestUEventBytesUdev(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	uevent := UEvent{
+		Action: ADD,
+		KObj:   "/devices/pci0000:00/0000:00:14.0/usb1/1-2",
+		Env: map[string]string{
+			"SUBSYSTEM": "usb",
+			"DEVTYPE":   "usb_device",
+			"SEQNUM":    "4410",
+			"TAGS":      ":systemd:seat:",
+			"KEY":       "a=b",
+		},
+	}
+
+	raw := uevent.BytesUdev()
+	t.FatalfIf(!bytes.HasPrefix(raw, []byte("libudev\x00\xfe\xed\xca\xfe")), "Wrong libudev prefix (got: %q)", raw[:12])
+	t.FatalfIf(binary.BigEndian.Uint32(raw[24:]) != stringHash32("usb"), "Wrong subsystem hash")
+	t.FatalfIf(binary.BigEndian.Uint32(raw[28:]) != stringHash32("usb_device"), "Wrong devtype hash")
+	bloom := uint64(binary.BigEndian.Uint32(raw[32:]))<<32 | uint64(binary.BigEndian.Uint32(raw[36:]))
+	t.FatalfIf(bloom != tagsBloom64(":systemd:seat:") || bloom == 0, "Wrong tags bloom filter")
+	t.FatalfIf(!bytes.Equal(raw, uevent.BytesUdev()), "Frame should be deterministic")
+
+	parsed, err := ParseUEvent(raw)
+	t.FatalfIf(err != nil, "Unable to parse libudev frame, err: %v", err)
+
+	// ACTION and DEVPATH are added to env
+	uevent.Env["ACTION"] = "add"
+	uevent.Env["DEVPATH"] = uevent.KObj
+	ok, err := parsed.Equal(uevent)
+	t.FatalfIf(!ok || err != nil, "Uevent should be equal after round-trip, err: %v", err)
+	t.FatalfIf(parsed.SeqNum != 4410, "Wrong seqnum after round-trip (got: %d)", parsed.SeqNum)
+}
+
+func TestParseUdevHeader(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	uevent := UEvent{
+		Action: ADD,
+		KObj:   "/devices/pci0000:00/0000:00:14.0/usb1/1-2",
+		Env:    map[string]string{"SUBSYSTEM": "usb", "DEVTYPE": "usb_device", "TAGS": ":systemd:"},
+	}
+	raw := uevent.BytesUdev()
+
+	parsed, err := ParseUEvent(raw)
+	t.FatalfIf(err != nil, "Unable to parse libudev frame, err: %v", err)
+	t.FatalfIf(parsed.Header == nil, "Header should be attached to udev event")
+
+	h := parsed.Header
+	t.FatalfIf(h.Magic != libudevMagic, "Wrong magic (got: %#x)", h.Magic)
+	t.FatalfIf(h.HeaderSize != udevHeaderSize || h.PropertiesOffset != udevHeaderSize, "Wrong header size or properties offset")
+	t.FatalfIf(int(h.PropertiesLength) != len(raw)-udevHeaderSize, "Wrong properties length (got: %d)", h.PropertiesLength)
+	t.FatalfIf(h.FilterSubsystemHash != stringHash32("usb"), "Wrong subsystem hash (got: %#x)", h.FilterSubsystemHash)
+	t.FatalfIf(h.FilterDevTypeHash != stringHash32("usb_device"), "Wrong devtype hash (got: %#x)", h.FilterDevTypeHash)
+	t.FatalfIf(h.TagBloom() != tagsBloom64(":systemd:"), "Wrong tag bloom (got: %#x)", h.TagBloom())
+
+	kernel, err := ParseUEvent(uevent.Bytes())
+	t.FatalfIf(err != nil || kernel.Header != nil, "Kernel event shouldn't have udev header")
+}
+
+func TestParseUdevHeaderLittleEndian(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		testing.Skip("This test assumes little-endian architecture")
+	}
+
+	// Header as written by udevd on little-endian platform
+	raw := []byte("libudev\x00\xfe\xed\xca\xfe(\x00\x00\x00(\x00\x00\x00\x2b\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"ACTION=add\x00DEVPATH=/devices/virtual/misc/foo\x00")
+	h := parseUdevHeader(raw)
+	t.FatalfIf(h.HeaderSize != 40 || h.PropertiesOffset != 40 || h.PropertiesLength != 43, "Wrong native endian fields (got: %+v)", h)
+
+	uevent, err := ParseUEvent(raw)
+	t.FatalfIf(err != nil || uevent.KObj != "/devices/virtual/misc/foo", "Unable to parse libudev event (got: %v, err: %v)", uevent, err)
+
+	// Offset isn't read from truncated header
+	for _, n := range []int{12, 16, 19} {
+		uevent, err := ParseUEvent(raw[:n])
+		t.FatalfIf(err == nil || uevent != nil, "Truncated header of %d bytes should be rejected before reading the offset", n)
+	}
+}
+
+func TestUEventSorted(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	uevent := UEvent{
+		Action: ADD,
+		KObj:   "/devices/virtual/misc/foo",
+		Env: map[string]string{
+			"SUBSYSTEM": "misc",
+			"ACTION":    "add",
+			"SEQNUM":    "1",
+			"DEVPATH":   "/devices/virtual/misc/foo",
+			"MAJOR":     "10",
+		},
+	}
+
+	golden := "add@/devices/virtual/misc/foo\x00ACTION=add\x00DEVPATH=/devices/virtual/misc/foo\x00MAJOR=10\x00SEQNUM=1\x00SUBSYSTEM=misc\x00"
+	for i := 0; i < 10; i++ {
+		t.FatalfIf(uevent.StringSorted() != golden, "Wrong sorted string (got: %q, wanted: %q)", uevent.StringSorted(), golden)
+		t.FatalfIf(!bytes.Equal(uevent.BytesSorted(), []byte(golden)), "Wrong sorted bytes (got: %q)", uevent.BytesSorted())
+	}
+
+	parsed, err := ParseUEvent(uevent.BytesSorted())
+	t.FatalfIf(err != nil, "Unable to parse sorted bytes, err: %v", err)
+	ok, err := parsed.Equal(uevent)
+	t.FatalfIf(!ok, "Sorted bytes should be parsed as the same uevent, err: %v", err)
+
+	empty := UEvent{Action: REMOVE, KObj: "/devices/foo"}
+	t.FatalfIf(empty.StringSorted() != "remove@/devices/foo\x00", "Wrong sorted string without env (got: %q)", empty.StringSorted())
+}
+
+func TestParseUEventRaw(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	raw := []byte("add@/devices/foo\x00ACTION=add\x00")
+	uevent, err := ParseUEvent(raw)
+	t.FatalfIf(err != nil, "Unable to parse uevent, err: %v", err)
+	t.FatalfIf(!bytes.Equal(uevent.Raw, raw), "Wrong raw msg (got: %q)", uevent.Raw)
+
+	raw[0] = 'X' // reuse the buffer
+	t.FatalfIf(uevent.Raw[0] != 'a', "Raw msg should be a copy")
+
+	udev := UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}.BytesUdev()
+	uevent, err = ParseUEvent(udev)
+	t.FatalfIf(err != nil || !bytes.Equal(uevent.Raw, udev), "Raw msg should be set for libudev events too")
+}
+
+func TestUEventSource(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	uevent := UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{}}
+	t.FatalfIf(uevent.Source != 0, "Built uevent shouldn't have source")
+
+	kernel, err := ParseUEvent(uevent.Bytes())
+	t.FatalfIf(err != nil || kernel.Source != KernelEvent, "Kernel event should have kernel source (got: %s)", kernel.Source)
+
+	udev, err := ParseUEvent(uevent.BytesUdev())
+	t.FatalfIf(err != nil || udev.Source != UdevEvent, "Udev event should have udev source (got: %s)", udev.Source)
+
+	t.FatalfIf(AllEvents.String() != "kernel|udev" || Mode(4).String() != "Mode(4)", "Wrong mode names")
+}
+
+func TestActionCategories(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	lifecycle, binding, power := 0, 0, 0
+	for _, a := range AllActions() {
+		_, err := ParseKObjAction(a.String())
+		t.FatalfIf(err != nil, "Action %s should be known, err: %v", a, err)
+		if a.IsLifecycle() {
+			lifecycle++
+		}
+		if a.IsBinding() {
+			binding++
+		}
+		if a.IsPowerState() {
+			power++
+		}
+	}
+	t.FatalfIf(len(AllActions()) != 8, "Wrong number of actions, got: %d", len(AllActions()))
+	t.FatalfIf(lifecycle != 2 || binding != 2 || power != 2, "Wrong categories (lifecycle: %d, binding: %d, power: %d)", lifecycle, binding, power)
+	t.FatalfIf(!REMOVE.IsLifecycle() || !UNBIND.IsBinding() || !ONLINE.IsPowerState(), "Wrong category of an action")
+	t.FatalfIf(CHANGE.IsLifecycle() || MOVE.IsBinding() || ADD.IsPowerState(), "Wrong category of an action")
+}
+
+func TestParseUEventLenient(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	kernel := []byte("add@/devices/foo\x00ACTION=add\x00SUBSYSTEM=usb\x00GARBAGE\x00SEQNUM=42\x00")
+	_, err := ParseUEventStrict(kernel)
+	t.FatalfIf(err == nil, "Strict parsing should reject the malformed env entry")
+
+	uevent, skipped, err := ParseUEventLenient(kernel)
+	t.FatalfIf(err != nil, "Lenient parsing shouldn't fail, err: %v", err)
+	t.FatalfIf(skipped != 1, "Wrong number of skipped env entries (got: %d)", skipped)
+	t.FatalfIf(uevent.Subsystem() != "usb" || uevent.SeqNum != 42 || len(uevent.Env) != 3, "Valid env entries should be kept (got: %v)", uevent.Env)
+
+	sample := UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"ACTION": "add", "DEVPATH": "/devices/foo", "BAD": "x"}}
+	udev := bytes.Replace(sample.BytesUdev(), []byte("BAD=x"), []byte("BAD_x"), 1)
+	_, err = ParseUEvent(udev)
+	t.FatalfIf(err == nil, "Strict parsing should reject the malformed udev env entry")
+	uevent, skipped, err = ParseUEventLenient(udev)
+	t.FatalfIf(err != nil || skipped != 1 || uevent.KObj != "/devices/foo" || uevent.Source != UdevEvent, "Wrong lenient udev parsing (got: %+v, skipped: %d, err: %v)", uevent, skipped, err)
+
+	_, _, err = ParseUEventLenient([]byte("add\x00SUBSYSTEM=usb\x00"))
+	t.FatalfIf(err == nil, "Malformed header should still be rejected")
+
+	_, skipped, err = ParseUEventLenient(UEvent{Action: ADD, KObj: "/devices/foo", Env: map[string]string{"A": "b"}}.Bytes())
+	t.FatalfIf(err != nil || skipped != 0, "Nothing should be skipped in a valid uevent (skipped: %d, err: %v)", skipped, err)
+}
+
+func TestParseUnknownAction(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	kernel := []byte("frobnicate@/devices/foo\x00SUBSYSTEM=usb\x00")
+	_, err := ParseUEvent(kernel)
+	t.FatalfIf(err == nil, "Unknown action should be rejected by default")
+
+	uevent, _, err := ParseOptions{UnknownActions: true}.Parse(kernel)
+	t.FatalfIf(err != nil, "Unknown action should be passed through, err: %v", err)
+	t.FatalfIf(uevent.Action != "frobnicate" || uevent.ActionKnown, "Wrong unknown action (got: %s, known: %v)", uevent.Action, uevent.ActionKnown)
+
+	udev := UEvent{Action: "frobnicate", KObj: "/devices/foo", Env: map[string]string{"ACTION": "frobnicate", "DEVPATH": "/devices/foo"}}.BytesUdev()
+	_, err = ParseUEvent(udev)
+	t.FatalfIf(err == nil, "Unknown udev action should be rejected by default")
+	uevent, _, err = ParseOptions{UnknownActions: true}.Parse(udev)
+	t.FatalfIf(err != nil || uevent.Action != "frobnicate" || uevent.ActionKnown, "Wrong unknown udev action (got: %v, err: %v)", uevent, err)
+
+	uevent, err = ParseUEvent([]byte("add@/devices/foo\x00"))
+	t.FatalfIf(err != nil || !uevent.ActionKnown, "Known action should be flagged, err: %v", err)
+
+	for _, raw := range []string{"@/devices/foo\x00", "fro bnicate@/devices/foo\x00"} {
+		_, _, err = ParseOptions{UnknownActions: true}.Parse([]byte(raw))
+		t.FatalfIf(err == nil, "Malformed action should still be rejected (with: %q)", raw)
+	}
+	_, _, err = ParseOptions{UnknownActions: true}.Parse(UEvent{KObj: "/devices/foo", Env: map[string]string{"DEVPATH": "/devices/foo"}}.BytesUdev())
+	t.FatalfIf(err == nil, "Missing udev action should still be rejected")
+}
+
+func TestUEventNormalize(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	raw := []byte("add@/devices/foo\x00Subsystem= usb \x00seqnum=42\x00ID_Vendor=Generic\t\x00DEVTYPE=usb_device\x00devtype=ignored\x00")
+	uevent, err := ParseUEvent(raw)
+	t.FatalfIf(err != nil, "Unable to parse uevent, err: %v", err)
+	t.FatalfIf(uevent.Env["Subsystem"] != " usb " || uevent.SeqNum != 0, "Env shouldn't be normalized by default (got: %v)", uevent.Env)
+
+	uevent.Normalize()
+	expected := map[string]string{"SUBSYSTEM": "usb", "SEQNUM": "42", "ID_VENDOR": "Generic", "DEVTYPE": "usb_device"}
+	t.FatalfIf(!reflect.DeepEqual(uevent.Env, expected), "Wrong normalized env (got: %v)", uevent.Env)
+	t.FatalfIf(uevent.SeqNum != 42, "SeqNum should be parsed again (got: %d)", uevent.SeqNum)
+	t.FatalfIf(uevent.KObj != "/devices/foo" || string(uevent.Raw) != string(raw), "KObj and Raw shouldn't be modified")
+
+	normalized, _, err := ParseOptions{Normalize: true}.Parse(raw)
+	t.FatalfIf(err != nil || !reflect.DeepEqual(normalized.Env, expected), "Wrong env normalized by the parsing (got: %v, err: %v)", normalized.Env, err)
+}
+
+func TestUEventEnvDiff(testing *testing.T) {
+	t := testingWrapper{testing}
+
+	prev := UEvent{Action: ADD, KObj: "/devices/block/sdb/sdb1", Env: map[string]string{"DEVNAME": "sdb1", "MNTPOINT": "/mnt/a", "ID_FS_LABEL": "backup"}}
+	cur := UEvent{Action: CHANGE, KObj: "/devices/block/sdb/sdb1", Env: map[string]string{"DEVNAME": "sdb1", "MNTPOINT": "/mnt/b", "ID_FS_UUID": "1234"}}
+
+	added, removed, changed := cur.EnvDiff(prev)
+	t.FatalfIf(!reflect.DeepEqual(added, map[string]string{"ID_FS_UUID": "1234"}), "Wrong added env (got: %v)", added)
+	t.FatalfIf(!reflect.DeepEqual(removed, map[string]string{"ID_FS_LABEL": "backup"}), "Wrong removed env (got: %v)", removed)
+	t.FatalfIf(!reflect.DeepEqual(changed, map[string]string{"MNTPOINT": "/mnt/b"}), "Wrong changed env (got: %v)", changed)
+
+	added, removed, changed = cur.EnvDiff(cur)
+	t.FatalfIf(added != nil || removed != nil || changed != nil, "Same env should have no diff (got: %v, %v, %v)", added, removed, changed)
+
+	added, removed, changed = cur.EnvDiff(UEvent{})
+	t.FatalfIf(len(added) != 3 || removed != nil || changed != nil, "All env should be added (got: %v, %v, %v)", added, removed, changed)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pilebones/go-udev/crawler"
+	"github.com/pilebones/go-udev/netlink"
+
+	"github.com/kr/pretty"
+)
+
+var (
+	stdout io.Writer = os.Stdout // where JSON lines are written (overridden in tests)
+	fields []string              // env keys to print, set from -fields (nil to print all env)
+
+	forwarder *netlink.Forwarder // ship uevents of monitor mode with -forward (nil if disabled)
+)
+
+// forwardQueueSize is the number of uevents waiting for the collector of -forward before being dropped
+const forwardQueueSize = 1024
+
+// deviceJSON is a crawled device printed with -json, like an "add" uevent with sysfs attributes of -attrs
+type deviceJSON struct {
+	Action string            `json:"action"`
+	KObj   string            `json:"kobj"`
+	SeqNum uint64            `json:"seqnum"`
+	Env    map[string]string `json:"env"`
+	Attrs  map[string]string `json:"attrs,omitempty"`
+}
+
+// parseFields return the env keys of the -fields flag, nil to print all env
+func parseFields(s string) []string {
+	var fields []string
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// printUEvent print an uevent of monitor mode, as a JSON line with -json or pretty-printed in logs,
+// and forward it with -forward. The whole uevent is forwarded: -fields only selects the env printed locally.
+func printUEvent(uevent netlink.UEvent) {
+	if forwarder != nil {
+		forwarder.Send(uevent) // never blocking, dropped if the collector is too slow
+	}
+	if fields != nil {
+		uevent = uevent.Project(fields...)
+	}
+	if *jsonOutput {
+		writeJSON(uevent)
+		return
+	}
+	// Raw msg and udev header would be dumped as bytes and pointer, env already contains the properties
+	uevent.Raw, uevent.Header = nil, nil
+	log.Println("Handle", pretty.Sprint(uevent))
+}
+
+// printDevice print a device of info mode, as a JSON line with -json or in logs
+func printDevice(device crawler.Device) {
+	if fields != nil {
+		device.Env = netlink.UEvent{Env: device.Env}.Project(fields...).Env
+	}
+	if *jsonOutput {
+		writeJSON(deviceJSON{Action: netlink.ADD.String(), KObj: device.KObj, Env: device.Env, Attrs: device.Attrs})
+		return
+	}
+	if device.Attrs != nil {
+		log.Println("Detect device at", device.KObj, "with env", device.Env, "and attributes", device.Attrs)
+		return
+	}
+	log.Println("Detect device at", device.KObj, "with env", device.Env)
+}
+
+// writeJSON write v as one JSON object per line on stdout
+func writeJSON(v interface{}) {
+	if err := json.NewEncoder(stdout).Encode(v); err != nil {
+		log.Println("ERROR: unable to write JSON, err:", err)
+	}
+}